@@ -7,9 +7,12 @@ require (
 	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
 	github.com/coocood/freecache v1.1.1
 	github.com/eclipse/paho.mqtt.golang v1.2.0
+	github.com/nats-io/nats.go v1.10.0
 	github.com/ory/dockertest/v3 v3.6.0
-	github.com/samuel/go-zookeeper v0.0.0-20200724154423-2164a8ac840e // indirect
+	github.com/samuel/go-zookeeper v0.0.0-20200724154423-2164a8ac840e
 	github.com/satori/go.uuid v1.2.0
 	github.com/segmentio/kafka-go v0.2.5
 	github.com/wvanbergen/kazoo-go v0.0.0-20180202103751-f72d8611297a
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c
+	google.golang.org/protobuf v1.27.1
 )
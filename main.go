@@ -19,6 +19,7 @@ package main
 import (
 	connectioncheck "connection-check/pkg"
 	"connection-check/pkg/configuration"
+	"connection-check/pkg/connectionlog/state"
 	"connection-check/pkg/health"
 	"context"
 	"flag"
@@ -30,9 +31,8 @@ import (
 )
 
 func main() {
-	time.Sleep(5 * time.Second) //wait for routing tables in cluster
-
 	confLocation := flag.String("config", "config.json", "configuration file")
+	exportLocalState := flag.Bool("export-local-state", false, "writes the local state store (local_state_file) as json to stdout and exits")
 	flag.Parse()
 
 	config, err := configuration.Load(*confLocation)
@@ -40,6 +40,19 @@ func main() {
 		log.Fatal("ERROR: unable to load config ", err)
 	}
 
+	if *exportLocalState {
+		if config.LocalStateFile == "" || config.LocalStateFile == "-" {
+			log.Fatal("ERROR: missing local_state_file")
+		}
+		err = state.ExportLocalStore(config.LocalStateFile, os.Stdout)
+		if err != nil {
+			log.Fatal("ERROR: unable to export local state store ", err)
+		}
+		return
+	}
+
+	time.Sleep(5 * time.Second) //wait for routing tables in cluster
+
 	check, err := connectioncheck.New(config)
 	if err != nil {
 		log.Fatal("ERROR: unable to init connectioncheck ", err)
@@ -48,11 +61,31 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	healthChecker := connectioncheck.NewHealthChecker(time.Duration(config.IntervalSeconds)*time.Second*2, config.HealthErrorLimit)
-	check.RunInterval(ctx, time.Duration(config.IntervalSeconds)*time.Second, healthChecker)
+	if check.Broker != nil {
+		healthChecker.AddCheck("broker", check.Broker)
+	}
+	if check.Outbox != nil {
+		healthChecker.AddCheck("outbox", check.Outbox)
+	}
+	if sinks, ok := check.Logger.(*connectioncheck.MultiLogger); ok {
+		healthChecker.AddCheck("event_sinks", sinks)
+	}
+	loggerState := check.LoggerState
+	if fallback, ok := loggerState.(*connectioncheck.FallbackLoggerState); ok {
+		healthChecker.AddCheck("local_state", fallback)
+		loggerState = fallback.Remote
+	}
+	if checkable, ok := loggerState.(health.Checkable); ok {
+		healthChecker.AddCheck("connection_log_state", checkable)
+	}
+	checkDone := check.RunInterval(ctx, time.Duration(config.IntervalSeconds)*time.Second, healthChecker)
 	health.StartEndpoint(ctx, config.HealthPort, healthChecker)
 
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	sig := <-shutdown
 	log.Println("received shutdown signal", sig)
+	cancel()
+	<-checkDone   //the current run may still produce events
+	check.Close() //flushes pending events
 }
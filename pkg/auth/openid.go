@@ -17,10 +17,12 @@
 package auth
 
 import (
+	"connection-check/pkg/httpclient"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"net/url"
@@ -35,12 +37,9 @@ type OpenidToken struct {
 	RequestTime      time.Time `json:"-"`
 }
 
-func GetOpenidToken(authEndpoint string, authClientId string, authClientSecret string) (openid OpenidToken, err error) {
+func GetOpenidToken(client *httpclient.Client, authEndpoint string, authClientId string, authClientSecret string) (openid OpenidToken, err error) {
 	requesttime := time.Now()
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
-	resp, err := client.PostForm(authEndpoint+"/auth/realms/master/protocol/openid-connect/token", url.Values{
+	req, err := newTokenRequest(authEndpoint, url.Values{
 		"client_id":     {authClientId},
 		"client_secret": {authClientSecret},
 		"grant_type":    {"client_credentials"},
@@ -48,6 +47,11 @@ func GetOpenidToken(authEndpoint string, authClientId string, authClientSecret s
 	if err != nil {
 		return openid, err
 	}
+	resp, err := client.Do(httpclient.Idempotent(req))
+	if err != nil {
+		return openid, err
+	}
+	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
 		b, _ := ioutil.ReadAll(resp.Body)
 		err = errors.New(resp.Status + ": " + string(b))
@@ -58,21 +62,22 @@ func GetOpenidToken(authEndpoint string, authClientId string, authClientSecret s
 	return
 }
 
-func RefreshOpenidToken(authEndpoint string, authClientId string, authClientSecret string, oldOpenid OpenidToken) (openid OpenidToken, err error) {
+func RefreshOpenidToken(client *httpclient.Client, authEndpoint string, authClientId string, authClientSecret string, oldOpenid OpenidToken) (openid OpenidToken, err error) {
 	requesttime := time.Now()
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
-	resp, err := client.PostForm(authEndpoint+"/auth/realms/master/protocol/openid-connect/token", url.Values{
+	req, err := newTokenRequest(authEndpoint, url.Values{
 		"client_id":     {authClientId},
 		"client_secret": {authClientSecret},
 		"refresh_token": {oldOpenid.RefreshToken},
 		"grant_type":    {"refresh_token"},
 	})
-
 	if err != nil {
 		return openid, err
 	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return openid, err
+	}
+	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
 		b, _ := ioutil.ReadAll(resp.Body)
 		err = errors.New(resp.Status + ": " + string(b))
@@ -82,3 +87,12 @@ func RefreshOpenidToken(authEndpoint string, authClientId string, authClientSecr
 	openid.RequestTime = requesttime
 	return
 }
+
+func newTokenRequest(authEndpoint string, values url.Values) (req *http.Request, err error) {
+	req, err = http.NewRequest("POST", authEndpoint+"/auth/realms/master/protocol/openid-connect/token", strings.NewReader(values.Encode()))
+	if err != nil {
+		return req, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
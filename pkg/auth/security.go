@@ -17,18 +17,20 @@
 package auth
 
 import (
+	"connection-check/pkg/httpclient"
 	"encoding/json"
 	"log"
 	"sync"
 	"time"
 )
 
-func New(authEndpoint string, authClientId string, authClientSecret string, authExpirationTimeBuffer float64) *Security {
+func New(authEndpoint string, authClientId string, authClientSecret string, authExpirationTimeBuffer float64, client *httpclient.Client) *Security {
 	result := &Security{
 		authEndpoint:             authEndpoint,
 		authClientSecret:         authClientSecret,
 		authClientId:             authClientId,
 		authExpirationTimeBuffer: authExpirationTimeBuffer,
+		client:                   client,
 	}
 	return result
 }
@@ -40,6 +42,7 @@ type Security struct {
 	authClientSecret         string
 	openid                   *OpenidToken
 	mux                      sync.Mutex
+	client                   *httpclient.Client
 }
 
 func (this *Security) ResetAccess() {
@@ -65,7 +68,7 @@ func (this *Security) Access() (token string, err error) {
 
 	if this.openid.RefreshToken != "" && this.openid.RefreshExpiresIn > duration+this.authExpirationTimeBuffer {
 		log.Println("refresh token", this.openid.RefreshExpiresIn, duration)
-		openid, err := RefreshOpenidToken(this.authEndpoint, this.authClientId, this.authClientSecret, *this.openid)
+		openid, err := RefreshOpenidToken(this.client, this.authEndpoint, this.authClientId, this.authClientSecret, *this.openid)
 		if err != nil {
 			log.Println("WARNING: unable to use refreshtoken", err)
 		} else {
@@ -76,7 +79,7 @@ func (this *Security) Access() (token string, err error) {
 	}
 
 	log.Println("get new access token")
-	openid, err := GetOpenidToken(this.authEndpoint, this.authClientId, this.authClientSecret)
+	openid, err := GetOpenidToken(this.client, this.authEndpoint, this.authClientId, this.authClientSecret)
 	this.openid = &openid
 	if err != nil {
 		log.Println("ERROR: unable to get new access token", err)
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/vernemq"
+	"log"
+	"sync"
+	"time"
+)
+
+const BrokerHealthMaxAge = 10 * time.Second
+
+type BrokerHealth interface {
+	GetClusterHealth(expectedNodes int) (result vernemq.ClusterHealth, err error)
+}
+
+//caches the vernemq cluster health; used for readiness and to stop disconnects while the broker cluster is degraded
+func NewBrokerMonitor(verne BrokerHealth, expectedNodes int, maxAge time.Duration) *BrokerMonitor {
+	return &BrokerMonitor{
+		verne:         verne,
+		expectedNodes: expectedNodes,
+		maxAge:        maxAge,
+	}
+}
+
+type BrokerMonitor struct {
+	verne         BrokerHealth
+	expectedNodes int
+	maxAge        time.Duration
+	lastCheck     time.Time
+	lastHealth    vernemq.ClusterHealth
+	lastErr       error
+	mux           sync.Mutex
+}
+
+func (this *BrokerMonitor) Get() (result vernemq.ClusterHealth, err error) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if this.lastCheck.IsZero() || time.Since(this.lastCheck) > this.maxAge {
+		this.lastHealth, this.lastErr = this.verne.GetClusterHealth(this.expectedNodes)
+		this.lastCheck = time.Now()
+		if this.lastErr != nil {
+			log.Println("ERROR: unable to check vernemq cluster health", this.lastErr)
+		} else if this.lastHealth.Degraded {
+			log.Println("WARNING: vernemq cluster is degraded", this.lastHealth.RunningNodes, len(this.lastHealth.Nodes), this.lastHealth.Partitioned)
+		}
+	}
+	return this.lastHealth, this.lastErr
+}
+
+//true if the cluster health is unknown or degraded
+func (this *BrokerMonitor) IsDegraded() bool {
+	if this == nil {
+		return false
+	}
+	health, err := this.Get()
+	return err != nil || health.Degraded
+}
+
+func (this *BrokerMonitor) Check() (ok bool, info interface{}) {
+	if this == nil {
+		return true, nil
+	}
+	health, err := this.Get()
+	if err != nil {
+		return false, map[string]interface{}{"error": err.Error()}
+	}
+	return !health.Degraded, health
+}
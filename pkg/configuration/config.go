@@ -47,6 +47,121 @@ type ConfigStruct struct {
 	AuthClientSecret      string   `json:"auth_client_secret"`
 	HandledProtocols      []string `json:"handled_protocols"`
 
+	TopicGeneratorRemoteUrl             string `json:"topic_generator_remote_url"`
+	TopicGeneratorRemoteCacheExpiration int    `json:"topic_generator_remote_cache_expiration"`
+
+	ServiceSelectionInteractions     []string `json:"service_selection_interactions"`
+	ServiceSelectionFunctionPrefixes []string `json:"service_selection_function_prefixes"`
+	ServiceSelectionAspectIds        []string `json:"service_selection_aspect_ids"`
+	ServiceSelectionIncludeLocalIds  []string `json:"service_selection_include_local_ids"`
+	ServiceSelectionExcludeLocalIds  []string `json:"service_selection_exclude_local_ids"`
+
+	VernemqNodeResultLimit int  `json:"vernemq_node_result_limit"`
+	VernemqHealthCheck     bool `json:"vernemq_health_check"`
+	VernemqExpectedNodes   int  `json:"vernemq_expected_nodes"`
+
+	VernemqNodeResultMaxLimit int `json:"vernemq_node_result_max_limit"`
+
+	VernemqMountpoint          string            `json:"vernemq_mountpoint"`
+	VernemqProtocolMountpoints map[string]string `json:"vernemq_protocol_mountpoints"`
+
+	SharedSubscriptionGroups     []string `json:"shared_subscription_groups"`
+	SharedSubscriptionMinMembers int      `json:"shared_subscription_min_members"`
+
+	EventSessionDetails bool `json:"event_session_details"`
+	EventLegacyFormat   bool `json:"event_legacy_format"`
+
+	EventDedupWindow string `json:"event_dedup_window"`
+	EventEncoding    string `json:"event_encoding"`
+
+	EventInitialDisconnected bool `json:"event_initial_disconnected"`
+
+	EnforceDisconnects     bool     `json:"enforce_disconnects"`
+	EnforceDryRun          bool     `json:"enforce_dry_run"`
+	EnforceCleanupSessions bool     `json:"enforce_cleanup_sessions"`
+	EnforceAllowlist       []string `json:"enforce_allowlist"`
+	EnforceMaxDisconnects  int      `json:"enforce_max_disconnects"`
+	EnforceAuditLog        string   `json:"enforce_audit_log"`
+
+	ProbeBrokerUrl     string `json:"probe_broker_url"`
+	ProbeClientId      string `json:"probe_client_id"`
+	ProbeUser          string `json:"probe_user"`
+	ProbePassword      string `json:"probe_password"`
+	ProbeRequestTopic  string `json:"probe_request_topic"`
+	ProbeResponseTopic string `json:"probe_response_topic"`
+	ProbePayload       string `json:"probe_payload"`
+	ProbeService       string `json:"probe_service"`
+	ProbeTimeout       string `json:"probe_timeout"`
+	ProbeQos           int    `json:"probe_qos"`
+
+	HttpTimeout                 string `json:"http_timeout"`
+	HttpMaxRetries              int    `json:"http_max_retries"`
+	HttpRetryBaseDelay          string `json:"http_retry_base_delay"`
+	HttpRetryMaxDelay           string `json:"http_retry_max_delay"`
+	HttpCircuitBreakerThreshold int    `json:"http_circuit_breaker_threshold"`
+	HttpCircuitBreakerCooldown  string `json:"http_circuit_breaker_cooldown"`
+	HttpCaFile                  string `json:"http_ca_file"`
+	HttpClientCertFile          string `json:"http_client_cert_file"`
+	HttpClientKeyFile           string `json:"http_client_key_file"`
+	HttpInsecureSkipVerify      bool   `json:"http_insecure_skip_verify"`
+	HttpProxyUrl                string `json:"http_proxy_url"`
+
+	KafkaBootstrap     string `json:"kafka_bootstrap"`
+	KafkaAsyncProducer bool   `json:"kafka_async_producer"`
+	KafkaBatchSize     int    `json:"kafka_batch_size"`
+	KafkaBatchTimeout  string `json:"kafka_batch_timeout"`
+	KafkaMaxPending    int    `json:"kafka_max_pending"`
+	KafkaRetryMax      int    `json:"kafka_retry_max"`
+	KafkaRetryBackoff  string `json:"kafka_retry_backoff"`
+	KafkaFlushTimeout  string `json:"kafka_flush_timeout"`
+
+	KafkaTls                   bool   `json:"kafka_tls"`
+	KafkaTlsCaFile             string `json:"kafka_tls_ca_file"`
+	KafkaTlsClientCertFile     string `json:"kafka_tls_client_cert_file"`
+	KafkaTlsClientKeyFile      string `json:"kafka_tls_client_key_file"`
+	KafkaTlsInsecureSkipVerify bool   `json:"kafka_tls_insecure_skip_verify"`
+	KafkaSaslMechanism         string `json:"kafka_sasl_mechanism"`
+	KafkaSaslUser              string `json:"kafka_sasl_user"`
+	KafkaSaslPassword          string `json:"kafka_sasl_password"`
+
+	KafkaTopicPartitions        int                         `json:"kafka_topic_partitions"`
+	KafkaTopicReplicationFactor int                         `json:"kafka_topic_replication_factor"`
+	KafkaTopicConfigs           map[string]string           `json:"kafka_topic_configs"`
+	KafkaTopics                 map[string]KafkaTopicConfig `json:"kafka_topics"`
+	KafkaVerifyTopics           bool                        `json:"kafka_verify_topics"`
+
+	OutboxDir           string `json:"outbox_dir"`
+	OutboxMaxBytes      int64  `json:"outbox_max_bytes"`
+	OutboxMaxAge        string `json:"outbox_max_age"`
+	OutboxRetryInterval string `json:"outbox_retry_interval"`
+
+	EventSinks        []string `json:"event_sinks"`
+	WebhookUrl        string   `json:"webhook_url"`
+	WebhookSecret     string   `json:"webhook_secret"`
+	NatsUrl           string   `json:"nats_url"`
+	NatsDeviceSubject string   `json:"nats_device_subject"`
+	NatsHubSubject    string   `json:"nats_hub_subject"`
+	EventFile         string   `json:"event_file"`
+
+	MqttStatusBrokerUrl          string `json:"mqtt_status_broker_url"`
+	MqttStatusClientId           string `json:"mqtt_status_client_id"`
+	MqttStatusUser               string `json:"mqtt_status_user"`
+	MqttStatusPassword           string `json:"mqtt_status_password"`
+	MqttStatusQos                int    `json:"mqtt_status_qos"`
+	MqttStatusDeviceTopic        string `json:"mqtt_status_device_topic"`
+	MqttStatusHubTopic           string `json:"mqtt_status_hub_topic"`
+	MqttStatusCaFile             string `json:"mqtt_status_ca_file"`
+	MqttStatusClientCertFile     string `json:"mqtt_status_client_cert_file"`
+	MqttStatusClientKeyFile      string `json:"mqtt_status_client_key_file"`
+	MqttStatusInsecureSkipVerify bool   `json:"mqtt_status_insecure_skip_verify"`
+
+	ConnectionLogStateSource string `json:"connection_log_state_source"`
+	LocalStateFile           string `json:"local_state_file"`
+
+	ConnectionLogStateChunkSize   int  `json:"connection_log_state_chunk_size"`
+	ConnectionLogStateParallelism int  `json:"connection_log_state_parallelism"`
+	ConnectionLogStateGzip        bool `json:"connection_log_state_gzip"`
+
 	DeviceLogTopic string `json:"device_log_topic"`
 	HubLogTopic    string `json:"hub_log_topic"`
 
@@ -59,6 +174,13 @@ type ConfigStruct struct {
 
 type Config = *ConfigStruct
 
+//settings of one kafka topic; unset values use kafka_topic_partitions, kafka_topic_replication_factor and kafka_topic_configs
+type KafkaTopicConfig struct {
+	Partitions        int               `json:"partitions"`
+	ReplicationFactor int               `json:"replication_factor"`
+	Configs           map[string]string `json:"configs"`
+}
+
 func Load(location string) (config Config, err error) {
 	file, error := os.Open(location)
 	if error != nil {
@@ -130,16 +252,30 @@ func HandleEnvironmentVars(config Config) {
 				}
 				configValue.FieldByName(fieldName).Set(reflect.ValueOf(val))
 			}
-			if configValue.FieldByName(fieldName).Kind() == reflect.Map {
+			if configValue.FieldByName(fieldName).Kind() == reflect.Map && configValue.FieldByName(fieldName).Type() == reflect.TypeOf(map[string]string{}) {
 				value := map[string]string{}
 				for _, element := range strings.Split(envValue, ",") {
-					keyVal := strings.Split(element, ":")
-					key := strings.TrimSpace(keyVal[0])
-					val := strings.TrimSpace(keyVal[1])
+					//split at the last ':' to allow keys like urn:infai:ses:protocol:123
+					index := strings.LastIndex(element, ":")
+					if index < 0 {
+						continue
+					}
+					key := strings.TrimSpace(element[:index])
+					val := strings.TrimSpace(element[index+1:])
 					value[key] = val
 				}
 				configValue.FieldByName(fieldName).Set(reflect.ValueOf(value))
 			}
+			if configValue.FieldByName(fieldName).Kind() == reflect.Map && configValue.FieldByName(fieldName).Type() != reflect.TypeOf(map[string]string{}) {
+				//other maps are expected as json, e.g. KAFKA_TOPICS={"device_log":{"partitions":3}}
+				value := reflect.New(configValue.FieldByName(fieldName).Type())
+				err := json.Unmarshal([]byte(envValue), value.Interface())
+				if err != nil {
+					log.Println("WARNING: invalid json in environment variable", envName, err)
+					continue
+				}
+				configValue.FieldByName(fieldName).Set(value.Elem())
+			}
 		}
 	}
 }
@@ -20,14 +20,18 @@ import (
 	security "connection-check/pkg/auth"
 	"connection-check/pkg/configuration"
 	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/connectionlog/outbox"
 	"connection-check/pkg/connectionlog/state"
 	"connection-check/pkg/devices"
+	"connection-check/pkg/httpclient"
 	"connection-check/pkg/model"
+	"connection-check/pkg/probe"
 	"connection-check/pkg/topicgenerator"
 	"connection-check/pkg/topicgenerator/common"
 	"connection-check/pkg/vernemq"
 	"context"
 	"errors"
+	uuid "github.com/satori/go.uuid"
 	"log"
 	"strings"
 	"time"
@@ -38,10 +42,16 @@ func New(config configuration.Config) (*ConnectionCheck, error) {
 	if !ok {
 		return nil, errors.New("unknown topic generator " + config.TopicGenerator)
 	}
-	logger, err := logger.New(config.ZookeeperUrl, true, false, config.DeviceLogTopic, config.HubLogTopic)
+	serviceSelection, err := common.NewServiceSelection(
+		config.ServiceSelectionInteractions,
+		config.ServiceSelectionFunctionPrefixes,
+		config.ServiceSelectionAspectIds,
+		config.ServiceSelectionIncludeLocalIds,
+		config.ServiceSelectionExcludeLocalIds)
 	if err != nil {
 		return nil, err
 	}
+	common.SetServiceSelection(serviceSelection)
 	handledProtocols := map[string]bool{}
 	for _, protocolId := range config.HandledProtocols {
 		handledProtocols[strings.TrimSpace(protocolId)] = true
@@ -55,36 +65,183 @@ func New(config configuration.Config) (*ConnectionCheck, error) {
 			err = nil
 		}
 	}
+	if config.EnforceDisconnects {
+		//the enforcer must know the client ids of the own sessions; generated ids are set on a copy of the config
+		copied := *config
+		config = &copied
+		if config.ProbeBrokerUrl != "" && config.ProbeClientId == "" {
+			config.ProbeClientId = "connection-check-probe-" + uuid.NewV4().String()
+		}
+		if config.MqttStatusBrokerUrl != "" && config.MqttStatusClientId == "" {
+			config.MqttStatusClientId = "connection-check-status-" + uuid.NewV4().String()
+		}
+	}
+	client, err := httpclient.NewFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if configure, ok := topicgenerator.Configurators[config.TopicGenerator]; ok {
+		err = configure(config, client)
+		if err != nil {
+			return nil, err
+		}
+	}
+	eventLogger, eventOutbox, err := NewEventLogger(config, client)
+	if err != nil {
+		return nil, err
+	}
+	loggerState, err := newLoggerState(config, client)
+	if err != nil {
+		return nil, err
+	}
+	var localState *state.LocalStore
+	if config.LocalStateFile != "" && config.LocalStateFile != "-" {
+		localState, err = state.NewLocalStore(config.LocalStateFile)
+		if err != nil {
+			return nil, err
+		}
+		loggerState = &FallbackLoggerState{Remote: loggerState, Local: localState}
+		log.Println("fall back to the local state store", config.LocalStateFile)
+	}
+	verne := vernemq.New(config.VernemqManagementUrl)
+	verne.Client = client
+	if config.VernemqNodeResultLimit > 0 {
+		verne.NodeResultLimit = config.VernemqNodeResultLimit
+	}
+	if config.VernemqNodeResultMaxLimit > 0 {
+		verne.NodeResultMaxLimit = config.VernemqNodeResultMaxLimit
+	}
+	verne.SharedSubscriptionGroups = config.SharedSubscriptionGroups
+	if config.SharedSubscriptionMinMembers > 0 {
+		verne.SharedSubscriptionMinMembers = config.SharedSubscriptionMinMembers
+	}
+	mountpoints := Mountpoints{Default: config.VernemqMountpoint, Protocols: config.VernemqProtocolMountpoints}
+	if mountpoints.Default != vernemq.AllMountpoints || len(mountpoints.Protocols) > 0 {
+		log.Println("use vernemq mountpoints: default =", mountpoints.Default, "protocols =", mountpoints.Protocols)
+	}
+	var broker *BrokerMonitor
+	if config.VernemqHealthCheck {
+		broker = NewBrokerMonitor(verne, config.VernemqExpectedNodes, BrokerHealthMaxAge)
+	}
+	var prober Prober
+	if config.ProbeBrokerUrl != "" {
+		prober, err = probe.NewFromConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("probe request-capable devices with online subscription")
+	}
+	var dedup *Dedup
+	if config.EventDedupWindow != "" && config.EventDedupWindow != "-" {
+		window, err := time.ParseDuration(config.EventDedupWindow)
+		if err != nil {
+			return nil, errors.New("invalid event_dedup_window: " + err.Error())
+		}
+		dedup = NewDedup(window)
+		log.Println("suppress duplicate events within", window)
+	}
+	var enforcer *Enforcer
+	if config.EnforceDisconnects {
+		enforcer, err = NewEnforcer(verne, mountpoints.All(), config.EnforceDryRun, config.EnforceCleanupSessions, config.EnforceAllowlist, config.EnforceMaxDisconnects, config.EnforceAuditLog)
+		if err != nil {
+			return nil, err
+		}
+		if config.ProbeBrokerUrl != "" {
+			enforcer.AddOwnClient(config.ProbeClientId)
+		}
+		if config.MqttStatusBrokerUrl != "" {
+			enforcer.AddOwnClient(config.MqttStatusClientId)
+		}
+		log.Println("enforce disconnects of unknown sessions; dry-run:", enforcer.DryRun)
+	}
 	return &ConnectionCheck{
-		Logger:                     logger,
-		LoggerState:                state.New(config.ConnectionLogStateUrl),
-		Verne:                      vernemq.New(config.VernemqManagementUrl),
-		Devices:                    devices.New(config),
-		TokenGen:                   security.New(config.AuthEndpoint, config.AuthClientId, config.AuthClientSecret, 2),
+		Logger:                     eventLogger,
+		LoggerState:                loggerState,
+		LocalState:                 localState,
+		Verne:                      verne,
+		Broker:                     broker,
+		Enforcer:                   enforcer,
+		Dedup:                      dedup,
+		Outbox:                     eventOutbox,
+		Prober:                     prober,
+		Devices:                    devices.New(config, client),
+		TokenGen:                   security.New(config.AuthEndpoint, config.AuthClientId, config.AuthClientSecret, 2, client),
 		SubscriptionTopicGenerator: topic,
 		BatchSize:                  config.BatchSize,
 		BatchSleep:                 batchSleep,
 		HandledProtocols:           handledProtocols,
+		Mountpoints:                mountpoints,
+		EventSessionDetails:        config.EventSessionDetails,
+		InitialDisconnected:        config.EventInitialDisconnected,
 		Debug:                      config.Debug,
 	}, nil
 }
 
+const StateSourceHttp = "http"
+const StateSourceKafka = "kafka"
+
+func newLoggerState(config configuration.Config, client *httpclient.Client) (LoggerState, error) {
+	switch config.ConnectionLogStateSource {
+	case "", "-", StateSourceHttp:
+		logState := state.New(config.ConnectionLogStateUrl, client)
+		logState.ChunkSize = config.ConnectionLogStateChunkSize
+		if config.ConnectionLogStateParallelism > 0 {
+			logState.Parallelism = config.ConnectionLogStateParallelism
+		}
+		logState.Gzip = config.ConnectionLogStateGzip
+		return logState, nil
+	case StateSourceKafka:
+		cluster, err := newKafkaCluster(config, client)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("read connection-log state from", config.DeviceLogTopic, "and", config.HubLogTopic)
+		return state.NewKafkaState(cluster, config.DeviceLogTopic, config.HubLogTopic)
+	default:
+		return nil, errors.New("unknown connection_log_state_source " + config.ConnectionLogStateSource)
+	}
+}
+
 type ConnectionCheck struct {
 	Logger                     Logger
 	LoggerState                LoggerState
+	LocalState                 *state.LocalStore //optional; stores emitted states for the FallbackLoggerState
 	Verne                      Verne
+	Broker                     *BrokerMonitor //optional; disconnects are suppressed while the broker cluster is degraded
+	Enforcer                   *Enforcer      //optional; disconnects sessions of unknown devices and hubs
+	Dedup                      *Dedup         //optional; suppresses transitions that were already emitted within a time window
+	Outbox                     *outbox.Outbox //optional; stores events until kafka delivered them
+	Prober                     Prober         //optional; devices with online subscription are only online if they respond to the probe
 	Devices                    Devices
 	TokenGen                   TokenGenerator
 	SubscriptionTopicGenerator TopicGenerator
 	BatchSize                  int
 	BatchSleep                 time.Duration
 	HandledProtocols           map[string]bool
+	Mountpoints                Mountpoints
+	EventSessionDetails        bool //if true, connect events contain the session found by vernemq
+	InitialDisconnected        bool //if true, a disconnect is published for offline devices and hubs without known state
 	Debug                      bool
 	intervalContext            context.Context
+	runId                      string //identifies the events of one check run
+}
+
+//flushes and closes the event logger
+func (this *ConnectionCheck) Close() {
+	this.Logger.Close()
+	if closer, ok := this.LoggerState.(interface{ Close() }); ok {
+		closer.Close()
+	}
+	if closer, ok := this.Prober.(interface{ Close() }); ok {
+		closer.Close()
+	}
 }
 
-func (this *ConnectionCheck) RunInterval(ctx context.Context, duration time.Duration, health *HealthChecker) {
+//done is closed after ctx is done and the current run is finished
+func (this *ConnectionCheck) RunInterval(ctx context.Context, duration time.Duration, health *HealthChecker) (done <-chan bool) {
+	stopped := make(chan bool)
 	go func() {
+		defer close(stopped)
 		this.run(health)
 		ticker := time.NewTicker(duration)
 		defer ticker.Stop()
@@ -97,15 +254,44 @@ func (this *ConnectionCheck) RunInterval(ctx context.Context, duration time.Dura
 			}
 		}
 	}()
+	return stopped
 }
 
 func (this *ConnectionCheck) run(health *HealthChecker) {
 	health.LogIntervalStart()
-	this.runDevices(health)
-	this.runHubs(health)
+	if state, ok := this.LoggerState.(ReadyLoggerState); ok && !state.Ready() {
+		log.Println("WARNING: connection-log state not ready; skip check")
+		return
+	}
+	this.runId = uuid.NewV4().String()
+	this.Dedup.Prune()
+	this.Enforcer.Reset()
+	devicesErr := this.runDevices(health)
+	hubsErr := this.runHubs(health)
+	if devicesErr == nil && hubsErr == nil {
+		this.runEnforcement()
+	}
 }
 
-func (this *ConnectionCheck) runDevices(health *HealthChecker) {
+//disconnects sessions of unknown devices and hubs; only called after a complete run
+func (this *ConnectionCheck) runEnforcement() {
+	if this.Enforcer == nil {
+		return
+	}
+	if this.Broker.IsDegraded() {
+		log.Println("WARNING: vernemq cluster is degraded; skip enforcement")
+		return
+	}
+	startTime := time.Now()
+	log.Println("start enforcement")
+	count, err := this.Enforcer.Run()
+	if err != nil {
+		log.Println("ERROR: enforcement failed", err)
+	}
+	log.Println("finish enforcement", count, "dry-run:", this.Enforcer.DryRun, time.Since(startTime))
+}
+
+func (this *ConnectionCheck) runDevices(health *HealthChecker) error {
 	startTime := time.Now()
 
 	var statistics *Statistics
@@ -115,11 +301,16 @@ func (this *ConnectionCheck) runDevices(health *HealthChecker) {
 
 	log.Println("start device-check")
 	err := this.RunDevices(statistics)
+	flushErr := this.Logger.Flush()
+	if err == nil {
+		err = flushErr
+	}
 	health.LogErrorDevices(err)
 	log.Println("finish device-check", err, time.Since(startTime), statistics.String())
+	return err
 }
 
-func (this *ConnectionCheck) runHubs(health *HealthChecker) {
+func (this *ConnectionCheck) runHubs(health *HealthChecker) error {
 	startTime := time.Now()
 
 	var statistics *Statistics
@@ -129,8 +320,13 @@ func (this *ConnectionCheck) runHubs(health *HealthChecker) {
 
 	log.Println("start hub-check")
 	err := this.RunHubs(statistics)
+	flushErr := this.Logger.Flush()
+	if err == nil {
+		err = flushErr
+	}
 	health.LogErrorHubs(err)
 	log.Println("finish hub-check", err, time.Since(startTime), statistics.String())
+	return err
 }
 
 func (this *ConnectionCheck) RunDevices(statistics *Statistics) (err error) {
@@ -167,6 +363,25 @@ func (this *ConnectionCheck) RunHubs(statistics *Statistics) (err error) {
 	return nil
 }
 
+//result of the vernemq check of a hub
+type hubObservation struct {
+	hub        model.Hub
+	mountpoint string
+	client     *vernemq.Client //nil if offline
+	start      time.Time       //start of the vernemq request
+}
+
+//result of the vernemq check (and probe) of a device
+type deviceObservation struct {
+	device           model.Device
+	topics           []string
+	mountpoint       string
+	subscription     *vernemq.Subscription //nil if offline
+	disconnectReason string
+	start            time.Time //start of the first vernemq request
+}
+
+//the known states are requested after vernemq is checked, so that changes of other loggers during the check are seen
 func (this *ConnectionCheck) RunHubBatch(limit int, offset int, statistics *Statistics) (count int, err error) {
 	token, err := this.TokenGen.Access()
 	if err != nil {
@@ -179,54 +394,99 @@ func (this *ConnectionCheck) RunHubBatch(limit int, offset int, statistics *Stat
 	}
 	statistics.AddTimeListRequests(time.Since(listStart))
 	ids := []string{}
-	filteredHubs := []model.Hub{}
+	observations := []hubObservation{}
 	for _, hub := range hubs {
-		if this.hubMatchesHandledProtocols(token, hub, statistics) {
-			ids = append(ids, hub.Id)
-			filteredHubs = append(filteredHubs, hub)
+		this.Enforcer.AddKnownClient(hub.Id)
+		matches, mountpoint := this.hubMatchesHandledProtocols(token, hub, statistics)
+		if !matches {
+			continue
+		}
+		statistics.AddChecked(1)
+		statistics.AddCheckedMountpoint(mountpoint)
+		timeVerneStart := time.Now()
+		client, err := this.Verne.CheckOnlineClient(mountpoint, hub.Id)
+		if err != nil {
+			return count, err
 		}
+		statistics.AddTimeVerneRequests(time.Since(timeVerneStart))
+		if client != nil {
+			statistics.AddConnected(1)
+		}
+		ids = append(ids, hub.Id)
+		observations = append(observations, hubObservation{hub: hub, mountpoint: mountpoint, client: client, start: timeVerneStart})
 	}
 	logStateStart := time.Now()
-	onlineStates, err := this.LoggerState.GetHubLogStates(token, ids)
+	knownStates, err := this.LoggerState.GetHubLogStates(token, ids)
+	err = this.handleStateLookupFailure(err)
 	if err != nil {
 		return count, err
 	}
 	statistics.AddTimeRequestLogState(time.Since(logStateStart))
-	statistics.AddChecked(len(filteredHubs))
-	for _, hub := range filteredHubs {
-		timeVerneStart := time.Now()
-		subscriptionIsOnline, err := this.Verne.CheckOnlineClient(hub.Id)
+	for _, observation := range observations {
+		known, ok := knownStates[observation.hub.Id]
+		if !ok {
+			statistics.AddStateLookupFailed(1) //checked again in the next run
+			continue
+		}
+		err = this.updateHub(observation, known, statistics)
 		if err != nil {
 			return count, err
 		}
-		statistics.AddTimeVerneRequests(time.Since(timeVerneStart))
-		if subscriptionIsOnline {
-			statistics.AddConnected(1)
-		}
+	}
+	return len(hubs), nil
+}
 
-		hubHasOnlineState := onlineStates[hub.Id]
+func (this *ConnectionCheck) updateHub(observation hubObservation, known state.LogState, statistics *Statistics) (err error) {
+	hub, mountpoint := observation.hub, observation.mountpoint
+	subscriptionIsOnline := observation.client != nil
+	initial := known.State == state.Unknown
+	if initial {
+		statistics.AddUnknown(1)
+	}
+	disconnect := !subscriptionIsOnline && (known.State == state.Connected || (initial && this.InitialDisconnected))
+	connect := subscriptionIsOnline && known.State != state.Connected
+	if (disconnect || connect) && this.changedDuringObservation(known, observation.start, statistics) {
+		if this.Debug {
+			log.Println("DEBUG: hub state changed during the check; skip update", hub, known.Time)
+		}
+		return nil
+	}
 
-		if hubHasOnlineState && !subscriptionIsOnline {
-			statistics.AddUpdateDisconnected(1)
-			err = this.Logger.LogHubDisconnect(hub.Id)
+	if disconnect {
+		if this.Broker.IsDegraded() {
+			statistics.AddSuppressedDisconnects(1)
 			if this.Debug {
-				log.Println("DEBUG: connect hub", hub)
+				log.Println("DEBUG: vernemq cluster is degraded; skip disconnect of hub", hub, mountpoint)
+			}
+		} else {
+			emitted, logErr := this.logTransition(DedupHub, hub.Id, false, statistics, func(onDelivery func(err error)) error {
+				return this.Logger.LogHubDisconnect(hub.Id, logger.EventInfo{Reason: logger.ReasonClientGone, RunId: this.runId, Initial: initial, OnDelivery: onDelivery})
+			})
+			err = logErr
+			if emitted {
+				statistics.AddUpdateDisconnected(1)
+				if this.Debug {
+					log.Println("DEBUG: disconnect hub", hub, mountpoint)
+				}
 			}
 		}
-		if !hubHasOnlineState && subscriptionIsOnline {
+	}
+	if connect {
+		emitted, logErr := this.logTransition(DedupHub, hub.Id, true, statistics, func(onDelivery func(err error)) error {
+			return this.Logger.LogHubConnect(hub.Id, logger.EventInfo{Reason: logger.ReasonClientFound, RunId: this.runId, Initial: initial, OnDelivery: onDelivery, Session: this.clientSession(observation.client)})
+		})
+		err = logErr
+		if emitted {
 			statistics.AddUpdateConnected(1)
-			err = this.Logger.LogHubConnect(hub.Id)
 			if this.Debug {
-				log.Println("DEBUG: disconnect hub", hub)
+				log.Println("DEBUG: connect hub", hub, mountpoint)
 			}
 		}
-		if err != nil {
-			return count, err
-		}
 	}
-	return len(hubs), nil
+	return err
 }
 
+//the known states are requested after vernemq is checked, so that changes of other loggers during the check are seen
 func (this *ConnectionCheck) RunDeviceBatch(limit int, offset int, statistics *Statistics) (count int, err error) {
 	token, err := this.TokenGen.Access()
 	if err != nil {
@@ -240,17 +500,10 @@ func (this *ConnectionCheck) RunDeviceBatch(limit int, offset int, statistics *S
 	}
 	statistics.AddTimeListRequests(time.Since(listStart))
 	ids := []string{}
-	for _, device := range devices {
-		ids = append(ids, device.Id)
-	}
-	logStateStart := time.Now()
-	onlineStates, err := this.LoggerState.GetDeviceLogStates(token, ids)
-	if err != nil {
-		return count, err
-	}
-	statistics.AddTimeRequestLogState(time.Since(logStateStart))
+	observations := []deviceObservation{}
 	dtCache := map[string]model.DeviceType{}
 	for _, device := range devices {
+		this.Enforcer.AddKnownDevice(device)
 		dt, ok := dtCache[device.DeviceTypeId]
 		if !ok {
 			dtStart := time.Now()
@@ -269,42 +522,145 @@ func (this *ConnectionCheck) RunDeviceBatch(limit int, offset int, statistics *S
 		if err != nil {
 			return count, err
 		}
+		this.Enforcer.AddKnownTopics(topics)
 		statistics.AddChecked(1)
 		timeVerneStart := time.Now()
-		subscriptionIsOnline, err := this.Verne.CheckOnlineSubscriptions(topics)
-		if err != nil {
-			return count, err
+		var subscription *vernemq.Subscription
+		mountpoint := ""
+		for _, mountpoint = range this.Mountpoints.ForDeviceType(dt, this.HandledProtocols) {
+			subscription, err = this.Verne.CheckOnlineSubscriptions(mountpoint, topics)
+			if err != nil {
+				return count, err
+			}
+			if subscription != nil {
+				break
+			}
 		}
+		statistics.AddCheckedMountpoint(mountpoint)
 		statistics.AddTimeVerneRequests(time.Since(timeVerneStart))
-
-		if subscriptionIsOnline {
+		disconnectReason := logger.ReasonNoSubscription
+		if subscription != nil && this.Prober != nil {
+			alive, applicable, err := this.Prober.Probe(device, dt, this.HandledProtocols)
+			if err != nil {
+				//an inconclusive probe does not overrule the subscription check
+				log.Println("ERROR: unable to probe device", device.Id, err)
+			} else if applicable {
+				statistics.AddProbed(1)
+				if !alive {
+					statistics.AddProbeFailed(1)
+					if this.Debug {
+						log.Println("DEBUG: device has online subscription but does not respond to probe", device, mountpoint)
+					}
+					subscription = nil
+					disconnectReason = logger.ReasonProbeFailed
+				}
+			}
+		}
+		if subscription != nil {
 			statistics.AddConnected(1)
 		}
+		ids = append(ids, device.Id)
+		observations = append(observations, deviceObservation{
+			device:           device,
+			topics:           topics,
+			mountpoint:       mountpoint,
+			subscription:     subscription,
+			disconnectReason: disconnectReason,
+			start:            timeVerneStart,
+		})
+	}
+	logStateStart := time.Now()
+	knownStates, err := this.LoggerState.GetDeviceLogStates(token, ids)
+	err = this.handleStateLookupFailure(err)
+	if err != nil {
+		return count, err
+	}
+	statistics.AddTimeRequestLogState(time.Since(logStateStart))
+	for _, observation := range observations {
+		known, ok := knownStates[observation.device.Id]
+		if !ok {
+			statistics.AddStateLookupFailed(1) //checked again in the next run
+			continue
+		}
+		err = this.updateDevice(observation, known, statistics)
+		if err != nil {
+			return count, err
+		}
+	}
+	return len(devices), nil
+}
 
-		deviceHasOnlineState := onlineStates[device.Id]
+func (this *ConnectionCheck) updateDevice(observation deviceObservation, known state.LogState, statistics *Statistics) (err error) {
+	device, mountpoint, topics := observation.device, observation.mountpoint, observation.topics
+	subscriptionIsOnline := observation.subscription != nil
+	initial := known.State == state.Unknown
+	if initial {
+		statistics.AddUnknown(1)
+	}
+	disconnect := !subscriptionIsOnline && (known.State == state.Connected || (initial && this.InitialDisconnected))
+	connect := subscriptionIsOnline && known.State != state.Connected
+	if (disconnect || connect) && this.changedDuringObservation(known, observation.start, statistics) {
+		if this.Debug {
+			log.Println("DEBUG: device state changed during the check; skip update", device, known.Time)
+		}
+		return nil
+	}
 
-		if deviceHasOnlineState && !subscriptionIsOnline {
-			statistics.AddUpdateDisconnected(1)
-			err = this.Logger.LogDeviceDisconnect(device.Id)
+	if disconnect {
+		if this.Broker.IsDegraded() {
+			statistics.AddSuppressedDisconnects(1)
 			if this.Debug {
-				log.Println("DEBUG: disconnect device", device)
+				log.Println("DEBUG: vernemq cluster is degraded; skip disconnect of device", device, mountpoint)
+			}
+		} else {
+			emitted, logErr := this.logTransition(DedupDevice, device.Id, false, statistics, func(onDelivery func(err error)) error {
+				return this.Logger.LogDeviceDisconnect(device.Id, logger.EventInfo{Reason: observation.disconnectReason, RunId: this.runId, Initial: initial, OnDelivery: onDelivery, Topics: topics})
+			})
+			err = logErr
+			if emitted {
+				statistics.AddUpdateDisconnected(1)
+				if this.Debug {
+					log.Println("DEBUG: disconnect device", device, mountpoint)
+				}
 			}
 		}
-		if !deviceHasOnlineState && subscriptionIsOnline {
+	}
+	if connect {
+		emitted, logErr := this.logTransition(DedupDevice, device.Id, true, statistics, func(onDelivery func(err error)) error {
+			return this.Logger.LogDeviceConnect(device.Id, logger.EventInfo{Reason: logger.ReasonSubscriptionFound, RunId: this.runId, Initial: initial, OnDelivery: onDelivery, Topics: topics, Session: this.subscriptionSession(observation.subscription)})
+		})
+		err = logErr
+		if emitted {
 			statistics.AddUpdateConnected(1)
-			err = this.Logger.LogDeviceConnect(device.Id)
 			if this.Debug {
-				log.Println("DEBUG: connect device", device)
+				log.Println("DEBUG: connect device", device, mountpoint)
 			}
 		}
-		if err != nil {
-			return count, err
-		}
 	}
-	return len(devices), nil
+	return err
+}
+
+//partially failed state lookups (see state.ChunkError) don't fail the batch; ids of failed chunks are missing in the result and skipped
+func (this *ConnectionCheck) handleStateLookupFailure(err error) error {
+	chunkErr, ok := err.(*state.ChunkError)
+	if !ok {
+		return err
+	}
+	log.Println("WARNING: skip ids of failed state lookup chunks", chunkErr)
+	return nil
 }
 
-func (this *ConnectionCheck) hubMatchesHandledProtocols(token string, hub model.Hub, statistics *Statistics) bool {
+//true if another logger recorded a state change after the vernemq observation started; the observation may be outdated
+func (this *ConnectionCheck) changedDuringObservation(known state.LogState, observationStart time.Time, statistics *Statistics) bool {
+	if known.Time.IsZero() || !known.Time.After(observationStart) {
+		return false
+	}
+	statistics.AddSkippedNewerState(1)
+	return true
+}
+
+//returns the mountpoint of the first device-type of the hub that uses a handled protocol
+func (this *ConnectionCheck) hubMatchesHandledProtocols(token string, hub model.Hub, statistics *Statistics) (matches bool, mountpoint string) {
 	dtCache := map[string]model.DeviceType{}
 	for _, deviceLocalId := range hub.DeviceLocalIds {
 		localIdStart := time.Now()
@@ -329,12 +685,12 @@ func (this *ConnectionCheck) hubMatchesHandledProtocols(token string, hub model.
 			}
 			if err == nil {
 				if common.DeviceTypeUsesHandledProtocol(dt, this.HandledProtocols) {
-					return true
+					return true, this.Mountpoints.ForDeviceType(dt, this.HandledProtocols)[0]
 				}
 			}
 		}
 	}
-	return false
+	return false, ""
 }
 
 func (this *ConnectionCheck) deviceTypeMatchesHandledProtocols(dt model.DeviceType) bool {
@@ -345,3 +701,36 @@ func (this *ConnectionCheck) deviceTypeMatchesHandledProtocols(dt model.DeviceTy
 	}
 	return false
 }
+
+func (this *ConnectionCheck) subscriptionSession(subscription *vernemq.Subscription) *logger.Session {
+	if !this.EventSessionDetails || subscription == nil {
+		return nil
+	}
+	topic := subscription.Topic
+	if subscription.ShareGroup != "" {
+		topic = vernemq.SharedTopic(subscription.ShareGroup, subscription.Topic)
+	}
+	return &logger.Session{
+		ClientId:        subscription.ClientId,
+		User:            subscription.User,
+		PeerHost:        subscription.PeerHost,
+		PeerPort:        subscription.PeerPort,
+		ProtocolVersion: subscription.ProtocolVersion,
+		Mountpoint:      subscription.Mountpoint,
+		Topic:           topic,
+	}
+}
+
+func (this *ConnectionCheck) clientSession(client *vernemq.Client) *logger.Session {
+	if !this.EventSessionDetails || client == nil {
+		return nil
+	}
+	return &logger.Session{
+		ClientId:        client.Id,
+		User:            client.User,
+		PeerHost:        client.PeerHost,
+		PeerPort:        client.PeerPort,
+		ProtocolVersion: client.ProtocolVersion,
+		Mountpoint:      client.Mountpoint,
+	}
+}
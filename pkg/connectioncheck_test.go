@@ -17,6 +17,7 @@
 package connectioncheck
 
 import (
+	"connection-check/pkg/connectionlog/logger"
 	"connection-check/pkg/model"
 	"connection-check/pkg/test/docker"
 	"connection-check/pkg/test/mocks"
@@ -149,12 +150,12 @@ func TestConnectionCheck(t *testing.T) {
 
 	t.Run("check events", func(t *testing.T) {
 		expected := []mocks.LogEvent{
-			{Id: "false_online", Kind: "device", Connected: false},
-			{Id: "false_offline", Kind: "device", Connected: true},
-			{Id: "false_offline_2", Kind: "device", Connected: true},
-			{Id: "false_online_hub", Kind: "hub", Connected: false},
-			{Id: "false_offline_hub", Kind: "hub", Connected: true},
-			{Id: "not_actually_ignored", Kind: "hub", Connected: false},
+			{Id: "false_online", Kind: "device", Connected: false, Reason: logger.ReasonNoSubscription},
+			{Id: "false_offline", Kind: "device", Connected: true, Reason: logger.ReasonSubscriptionFound},
+			{Id: "false_offline_2", Kind: "device", Connected: true, Reason: logger.ReasonSubscriptionFound},
+			{Id: "false_online_hub", Kind: "hub", Connected: false, Reason: logger.ReasonClientGone},
+			{Id: "false_offline_hub", Kind: "hub", Connected: true, Reason: logger.ReasonClientFound},
+			{Id: "not_actually_ignored", Kind: "hub", Connected: false, Reason: logger.ReasonClientGone},
 		}
 
 		if !reflect.DeepEqual(loggerMock.Events, expected) {
@@ -249,10 +250,10 @@ func TestConnectionCheckPlaceholder(t *testing.T) {
 
 	t.Run("check events", func(t *testing.T) {
 		expected := []mocks.LogEvent{
-			{Id: "false_online", Kind: "device", Connected: false},
-			{Id: "false_offline", Kind: "device", Connected: true},
-			{Id: "false_offline_p", Kind: "device", Connected: true},
-			{Id: "false_offline_s", Kind: "device", Connected: true},
+			{Id: "false_online", Kind: "device", Connected: false, Reason: logger.ReasonNoSubscription},
+			{Id: "false_offline", Kind: "device", Connected: true, Reason: logger.ReasonSubscriptionFound},
+			{Id: "false_offline_p", Kind: "device", Connected: true, Reason: logger.ReasonSubscriptionFound},
+			{Id: "false_offline_s", Kind: "device", Connected: true, Reason: logger.ReasonSubscriptionFound},
 		}
 
 		if !reflect.DeepEqual(loggerMock.Events, expected) {
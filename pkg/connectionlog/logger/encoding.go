@@ -0,0 +1,203 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"bytes"
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"encoding/json"
+	"errors"
+	"github.com/satori/go.uuid"
+	"time"
+)
+
+//values of event_encoding
+const (
+	EncodingJson              = "json"               //legacy json, see schemas/connection_log.schema.json
+	EncodingCloudEventsJson   = "cloudevents-json"   //cloudevents structured mode, see schemas/cloudevent.schema.json
+	EncodingCloudEventsBinary = "cloudevents-binary" //cloudevents binary mode with kafka headers, see schemas/cloudevent_binary_headers.schema.json
+	EncodingProtobuf          = "protobuf"           //see schemas/connection_log.proto
+)
+
+const CloudEventSpecVersion = "1.0"
+
+//cloudevents type of the events
+const (
+	CloudEventTypeDevice = "connection-check.device_log"
+	CloudEventTypeHub    = "connection-check.hub_log"
+)
+
+const (
+	ContentTypeJson            = "application/json"
+	ContentTypeCloudEventsJson = "application/cloudevents+json"
+	ContentTypeProtobuf        = "application/x-protobuf"
+)
+
+const HeaderContentType = "content-type"
+
+//prefix of the cloudevents attributes in kafka headers (binary mode)
+const CloudEventHeaderPrefix = "ce_"
+
+//connection event of a device or hub; the json fields are described by schemas/connection_log.schema.json
+type Event struct {
+	Type      string    `json:"-"` //CloudEventTypeDevice or CloudEventTypeHub; empty if decoded from a message without type
+	Id        string    `json:"id"`
+	Connected bool      `json:"connected"`
+	Time      time.Time `json:"time"`
+	EventMetadata
+}
+
+//structured cloudevent
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Id              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+type Encoder interface {
+	//returns the message value and optional headers
+	Encode(event Event) (message []byte, headers []kafka.Header, err error)
+	//true if the message can not be decoded without the headers; only sinks that implement kafka.HeaderProducer can be used
+	RequiresHeaders() bool
+}
+
+func NewEncoder(encoding string) (Encoder, error) {
+	switch encoding {
+	case "", EncodingJson:
+		return JsonEncoder{}, nil
+	case EncodingCloudEventsJson:
+		return CloudEventsEncoder{}, nil
+	case EncodingCloudEventsBinary:
+		return CloudEventsEncoder{Binary: true}, nil
+	case EncodingProtobuf:
+		return ProtobufEncoder{}, nil
+	default:
+		return nil, errors.New("unknown event encoding " + encoding)
+	}
+}
+
+//legacy json without headers
+type JsonEncoder struct{}
+
+func (this JsonEncoder) Encode(event Event) (message []byte, headers []kafka.Header, err error) {
+	message, err = json.Marshal(event)
+	return message, nil, err
+}
+
+func (this JsonEncoder) RequiresHeaders() bool {
+	return false
+}
+
+//the data of the cloudevent is the legacy json; the id of the device or hub is the subject
+//structured mode sets the content-type header to application/cloudevents+json
+//binary mode sends the attributes as "ce_" headers and the data as message
+type CloudEventsEncoder struct {
+	Binary bool
+}
+
+func (this CloudEventsEncoder) Encode(event Event) (message []byte, headers []kafka.Header, err error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return message, headers, err
+	}
+	cloudEvent := CloudEvent{
+		SpecVersion:     CloudEventSpecVersion,
+		Id:              uuid.NewV4().String(),
+		Source:          EventSource,
+		Type:            event.Type,
+		Subject:         event.Id,
+		Time:            event.Time,
+		DataContentType: ContentTypeJson,
+		Data:            data,
+	}
+	if this.Binary {
+		return data, []kafka.Header{
+			{Key: CloudEventHeaderPrefix + "specversion", Value: cloudEvent.SpecVersion},
+			{Key: CloudEventHeaderPrefix + "id", Value: cloudEvent.Id},
+			{Key: CloudEventHeaderPrefix + "source", Value: cloudEvent.Source},
+			{Key: CloudEventHeaderPrefix + "type", Value: cloudEvent.Type},
+			{Key: CloudEventHeaderPrefix + "subject", Value: cloudEvent.Subject},
+			{Key: CloudEventHeaderPrefix + "time", Value: cloudEvent.Time.Format(time.RFC3339Nano)},
+			{Key: HeaderContentType, Value: ContentTypeJson},
+		}, nil
+	}
+	message, err = json.Marshal(cloudEvent)
+	return message, []kafka.Header{{Key: HeaderContentType, Value: ContentTypeCloudEventsJson}}, err
+}
+
+func (this CloudEventsEncoder) RequiresHeaders() bool {
+	return this.Binary
+}
+
+//ConnectionLog message of schemas/connection_log.proto
+type ProtobufEncoder struct{}
+
+func (this ProtobufEncoder) Encode(event Event) (message []byte, headers []kafka.Header, err error) {
+	message, err = marshalProtobuf(event)
+	if err != nil {
+		return message, headers, err
+	}
+	return message, []kafka.Header{{Key: HeaderContentType, Value: ContentTypeProtobuf}}, nil
+}
+
+func (this ProtobufEncoder) RequiresHeaders() bool {
+	return false
+}
+
+//decodes messages of all encodings without headers; the data of binary cloudevents is decoded like legacy json
+//json messages of other producers only need id and connected; a time that is no RFC 3339 string is ignored
+func DecodeEvent(message []byte) (result Event, err error) {
+	trimmed := bytes.TrimSpace(message)
+	if len(trimmed) == 0 {
+		return result, errors.New("empty event message")
+	}
+	if trimmed[0] != '{' {
+		return unmarshalProtobuf(message)
+	}
+	envelope := struct {
+		SpecVersion string          `json:"specversion"`
+		Type        string          `json:"type"`
+		Data        json.RawMessage `json:"data"`
+	}{}
+	err = json.Unmarshal(trimmed, &envelope)
+	if err != nil {
+		return result, err
+	}
+	data := trimmed
+	if envelope.SpecVersion != "" {
+		data = envelope.Data
+	}
+	lenient := struct {
+		Event
+		Time json.RawMessage `json:"time"`
+	}{}
+	err = json.Unmarshal(data, &lenient)
+	if err != nil {
+		return result, err
+	}
+	result = lenient.Event
+	result.Type = envelope.Type
+	if len(lenient.Time) > 0 && json.Unmarshal(lenient.Time, &result.Time) != nil {
+		result.Time = time.Time{}
+	}
+	return result, nil
+}
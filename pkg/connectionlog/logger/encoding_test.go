@@ -0,0 +1,273 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func testEvent() Event {
+	return Event{
+		Type:      CloudEventTypeDevice,
+		Id:        "d1",
+		Connected: true,
+		Time:      time.Date(2020, 6, 25, 10, 0, 0, 123, time.UTC),
+		EventMetadata: EventMetadata{
+			Version: EventVersion,
+			Source:  EventSource,
+			Reason:  ReasonSubscriptionFound,
+			RunId:   "run1",
+			Topics:  []string{"command/d1/+", "command/d1/#"},
+			Session: &Session{ClientId: "c1", User: "u1", PeerHost: "10.0.0.1", PeerPort: 1883, ProtocolVersion: 4, Topic: "command/d1/+"},
+			Initial: true,
+		},
+	}
+}
+
+func headerMap(headers []kafka.Header) map[string]string {
+	result := map[string]string{}
+	for _, header := range headers {
+		result[header.Key] = header.Value
+	}
+	return result
+}
+
+func TestEncoders(t *testing.T) {
+	event := testEvent()
+	legacy, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, encoding := range []string{EncodingJson, EncodingCloudEventsJson, EncodingCloudEventsBinary, EncodingProtobuf} {
+		t.Run(encoding, func(t *testing.T) {
+			encoder, err := NewEncoder(encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message, headers, err := encoder.Encode(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoder.RequiresHeaders() != (encoding == EncodingCloudEventsBinary) {
+				t.Error(encoder.RequiresHeaders())
+			}
+			decoded, err := DecodeEvent(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoding == EncodingCloudEventsJson {
+				if decoded.Type != event.Type {
+					t.Error(decoded.Type)
+				}
+			} else {
+				decoded.Type = event.Type
+			}
+			if !decoded.Time.Equal(event.Time) {
+				t.Error(decoded.Time)
+			}
+			decoded.Time = event.Time
+			if !reflect.DeepEqual(decoded, event) {
+				t.Errorf("%#v", decoded)
+			}
+
+			switch encoding {
+			case EncodingJson:
+				if string(message) != string(legacy) || headers != nil {
+					t.Error(string(message), headers)
+				}
+			case EncodingCloudEventsJson:
+				cloudEvent := CloudEvent{}
+				err = json.Unmarshal(message, &cloudEvent)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cloudEvent.SpecVersion != "1.0" || cloudEvent.Id == "" || cloudEvent.Source != EventSource || cloudEvent.Type != CloudEventTypeDevice || cloudEvent.Subject != "d1" || !cloudEvent.Time.Equal(event.Time) || string(cloudEvent.Data) != string(legacy) {
+					t.Error(string(message))
+				}
+				if !reflect.DeepEqual(headerMap(headers), map[string]string{"content-type": ContentTypeCloudEventsJson}) {
+					t.Error(headers)
+				}
+			case EncodingCloudEventsBinary:
+				actual := headerMap(headers)
+				if string(message) != string(legacy) || actual["ce_id"] == "" || actual["ce_time"] != "2020-06-25T10:00:00.000000123Z" {
+					t.Error(string(message), actual)
+				}
+				delete(actual, "ce_id")
+				delete(actual, "ce_time")
+				expected := map[string]string{
+					"ce_specversion": "1.0",
+					"ce_source":      EventSource,
+					"ce_type":        CloudEventTypeDevice,
+					"ce_subject":     "d1",
+					"content-type":   ContentTypeJson,
+				}
+				if !reflect.DeepEqual(actual, expected) {
+					t.Error(actual)
+				}
+			case EncodingProtobuf:
+				if !reflect.DeepEqual(headerMap(headers), map[string]string{"content-type": ContentTypeProtobuf}) {
+					t.Error(headers)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewEncoder("xml")
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestProtobufWireFormat(t *testing.T) {
+	//id: "d1", connected: true, time: {seconds: 1}, version: -1 (negative int32 values use 10 bytes)
+	expected := []byte{0x0a, 0x02, 'd', '1', 0x10, 0x01, 0x1a, 0x02, 0x08, 0x01, 0x20, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}
+	event := Event{Id: "d1", Connected: true, Time: time.Unix(1, 0), EventMetadata: EventMetadata{Version: -1}}
+	actual, err := marshalProtobuf(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%x", actual)
+	}
+	decoded, err := unmarshalProtobuf(append(actual, 0x5d, 1, 2, 3, 4)) //unknown fixed32 field 11
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Id != "d1" || !decoded.Connected || decoded.Time.Unix() != 1 || decoded.Version != -1 {
+		t.Error(decoded)
+	}
+	_, err = unmarshalProtobuf(actual[:len(actual)-2])
+	if err == nil {
+		t.Error("expected error on truncated message")
+	}
+}
+
+func TestDecodeEventOfOtherProducers(t *testing.T) {
+	event, err := DecodeEvent([]byte(` {"id":"d1","connected":true,"time":1593079200}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Id != "d1" || !event.Connected || !event.Time.IsZero() {
+		t.Error(event)
+	}
+	_, err = DecodeEvent([]byte(``))
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+type headerProducerMock struct {
+	producerMock
+	Headers [][]kafka.Header
+}
+
+func (this *headerProducerMock) ProduceMessageWithHeaders(topic string, message string, key string, headers []kafka.Header, callback func(err error)) (err error) {
+	this.Headers = append(this.Headers, headers)
+	return this.ProduceMessage(topic, message, key, callback)
+}
+
+func TestLoggerEncoder(t *testing.T) {
+	producer := &headerProducerMock{}
+	logger := &Logger{producer: producer, deviceLogTopic: "device_log", hubLogTopic: "gateway_log", Encoder: CloudEventsEncoder{Binary: true}}
+	err := logger.LogHubConnect("h1", EventInfo{Reason: ReasonClientFound})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.Headers) != 1 || headerMap(producer.Headers[0])["ce_type"] != CloudEventTypeHub || headerMap(producer.Headers[0])["ce_subject"] != "h1" {
+		t.Error(producer.Headers)
+	}
+	if producer.Topics[0] != "gateway_log" || producer.Keys[0] != "h1" {
+		t.Error(producer.Topics, producer.Keys)
+	}
+	hubLog := Event{}
+	err = json.Unmarshal([]byte(producer.Messages[0]), &hubLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hubLog.Id != "h1" || !hubLog.Connected || hubLog.Reason != ReasonClientFound {
+		t.Error(hubLog)
+	}
+}
+
+//the published schemas have to list the fields of the encoded events
+func TestSchemas(t *testing.T) {
+	event := testEvent()
+	schemaKeys := func(file string, field string) (result []string) {
+		content, err := ioutil.ReadFile("../../../schemas/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema := map[string]json.RawMessage{}
+		err = json.Unmarshal(content, &schema)
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		switch field {
+		case "properties":
+			properties := map[string]interface{}{}
+			err = json.Unmarshal(schema["properties"], &properties)
+			for key := range properties {
+				result = append(result, key)
+			}
+		case "required":
+			err = json.Unmarshal(schema["required"], &result)
+		}
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		sort.Strings(result)
+		return result
+	}
+	messageKeys := func(message []byte) (result []string) {
+		fields := map[string]interface{}{}
+		err := json.Unmarshal(message, &fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key := range fields {
+			result = append(result, key)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	legacy, _, _ := JsonEncoder{}.Encode(event)
+	if keys := schemaKeys("connection_log.schema.json", "properties"); !reflect.DeepEqual(keys, messageKeys(legacy)) {
+		t.Error(keys, messageKeys(legacy))
+	}
+	structured, _, _ := CloudEventsEncoder{}.Encode(event)
+	if keys := schemaKeys("cloudevent.schema.json", "required"); !reflect.DeepEqual(keys, messageKeys(structured)) {
+		t.Error(keys, messageKeys(structured))
+	}
+	_, headers, _ := CloudEventsEncoder{Binary: true}.Encode(event)
+	headerKeys := []string{}
+	for key := range headerMap(headers) {
+		headerKeys = append(headerKeys, key)
+	}
+	sort.Strings(headerKeys)
+	if keys := schemaKeys("cloudevent_binary_headers.schema.json", "required"); !reflect.DeepEqual(keys, headerKeys) {
+		t.Error(keys, headerKeys)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"connection-check/pkg/configuration"
+	"errors"
+	"github.com/Shopify/sarama"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ProducerConfig struct {
+	Async        bool
+	Idempotent   bool
+	BatchSize    int           //messages per batch of the AsyncProducer
+	BatchTimeout time.Duration //max time a message waits for its batch
+	MaxPending   int           //max count of undelivered messages; further produce calls block
+	RetryMax     int           //retries of a failed delivery
+	RetryBackoff time.Duration
+	FlushTimeout time.Duration //max time Flush waits for pending deliveries
+}
+
+var DefaultProducerConfig = ProducerConfig{
+	Async:        false,
+	Idempotent:   false,
+	BatchSize:    100,
+	BatchTimeout: 100 * time.Millisecond,
+	MaxPending:   10000,
+	RetryMax:     5,
+	RetryBackoff: 500 * time.Millisecond,
+	FlushTimeout: 30 * time.Second,
+}
+
+//ints <= 0 and empty durations use the DefaultProducerConfig
+func NewProducerConfig(config configuration.Config) (result ProducerConfig, err error) {
+	result = DefaultProducerConfig
+	result.Async = config.KafkaAsyncProducer
+	ints := []struct {
+		value  int
+		target *int
+	}{
+		{config.KafkaBatchSize, &result.BatchSize},
+		{config.KafkaMaxPending, &result.MaxPending},
+		{config.KafkaRetryMax, &result.RetryMax},
+	}
+	for _, i := range ints {
+		if i.value > 0 {
+			*i.target = i.value
+		}
+	}
+	durations := []struct {
+		value  string
+		target *time.Duration
+	}{
+		{config.KafkaBatchTimeout, &result.BatchTimeout},
+		{config.KafkaRetryBackoff, &result.RetryBackoff},
+		{config.KafkaFlushTimeout, &result.FlushTimeout},
+	}
+	for _, duration := range durations {
+		if duration.value != "" && duration.value != "-" {
+			*duration.target, err = time.ParseDuration(duration.value)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+//batches messages and reports the delivery of each message to its callback
+//delivery errors are logged and returned by the next Flush(); messages of a key keep their order
+type AsyncProducer struct {
+	broker     []string
+	logger     *log.Logger
+	producer   sarama.AsyncProducer
+	cluster    Cluster
+	config     ProducerConfig
+	mux        sync.Mutex
+	usedTopics map[string]bool
+	pending    chan bool //one entry per undelivered message
+	failed     int       //count of failed deliveries since the last flush
+	failedMux  sync.Mutex
+	done       sync.WaitGroup
+	closeMux   sync.RWMutex //held by send while a message is passed to the producer
+	closed     bool
+}
+
+var ErrProducerClosed = errors.New("kafka producer is closed")
+
+func NewAsyncProducer(broker []string, cluster Cluster, config ProducerConfig) (result *AsyncProducer, err error) {
+	sarama_conf := cluster.saramaConfig()
+	sarama_conf.Producer.Return.Errors = true
+	sarama_conf.Producer.Return.Successes = true
+	sarama_conf.Producer.Flush.Messages = config.BatchSize
+	sarama_conf.Producer.Flush.Frequency = config.BatchTimeout
+	sarama_conf.Producer.Retry.Max = config.RetryMax
+	sarama_conf.Producer.Retry.Backoff = config.RetryBackoff
+	sarama_conf.Net.MaxOpenRequests = 1 //keeps the order of messages on retries
+	if config.Idempotent {
+		sarama_conf.Producer.Idempotent = true
+		sarama_conf.Producer.RequiredAcks = sarama.WaitForAll
+	}
+	producer, err := sarama.NewAsyncProducer(broker, sarama_conf)
+	if err != nil {
+		return result, err
+	}
+	result = newAsyncProducer(producer, cluster, config)
+	result.broker = broker
+	return result, nil
+}
+
+func newAsyncProducer(producer sarama.AsyncProducer, cluster Cluster, config ProducerConfig) *AsyncProducer {
+	if config.MaxPending <= 0 {
+		config.MaxPending = DefaultProducerConfig.MaxPending
+	}
+	if config.FlushTimeout <= 0 {
+		config.FlushTimeout = DefaultProducerConfig.FlushTimeout
+	}
+	result := &AsyncProducer{
+		producer:   producer,
+		cluster:    cluster,
+		config:     config,
+		usedTopics: map[string]bool{},
+		pending:    make(chan bool, config.MaxPending),
+	}
+	result.done.Add(2)
+	go func() {
+		defer result.done.Done()
+		for msg := range producer.Successes() {
+			result.delivered(msg, nil)
+		}
+	}()
+	go func() {
+		defer result.done.Done()
+		for err := range producer.Errors() {
+			result.delivered(err.Msg, err.Err)
+		}
+	}()
+	return result
+}
+
+//the pending entry is released last, so that Flush() returns after all callbacks are done
+func (this *AsyncProducer) delivered(msg *sarama.ProducerMessage, err error) {
+	defer func() { <-this.pending }()
+	if err != nil {
+		log.Println("ERROR: unable to deliver kafka message", msg.Topic, err)
+		this.failedMux.Lock()
+		this.failed++
+		this.failedMux.Unlock()
+	}
+	if callback, ok := msg.Metadata.(func(err error)); ok && callback != nil {
+		callback(err)
+	}
+}
+
+func (this *AsyncProducer) Log(logger *log.Logger) {
+	this.logger = logger
+}
+
+func (this *AsyncProducer) Produce(topic string, message string) (err error) {
+	return this.send(topic, message, nil, nil, nil)
+}
+
+func (this *AsyncProducer) ProduceWithKey(topic string, message string, key string) (err error) {
+	return this.send(topic, message, sarama.StringEncoder(key), nil, nil)
+}
+
+func (this *AsyncProducer) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	return this.send(topic, message, sarama.StringEncoder(key), nil, callback)
+}
+
+func (this *AsyncProducer) ProduceMessageWithHeaders(topic string, message string, key string, headers []Header, callback func(err error)) (err error) {
+	return this.send(topic, message, sarama.StringEncoder(key), headers, callback)
+}
+
+func (this *AsyncProducer) send(topic string, message string, key sarama.Encoder, headers []Header, callback func(err error)) (err error) {
+	if this.logger != nil {
+		this.logger.Println("DEBUG: produce ", topic, message)
+	}
+	this.mux.Lock()
+	err = this.cluster.EnsureTopic(topic, &this.usedTopics)
+	this.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	this.closeMux.RLock()
+	defer this.closeMux.RUnlock()
+	if this.closed {
+		return ErrProducerClosed
+	}
+	this.pending <- true //blocks while MaxPending messages are undelivered
+	this.producer.Input() <- &sarama.ProducerMessage{Topic: topic, Key: key, Value: sarama.StringEncoder(message), Headers: saramaHeaders(headers), Timestamp: time.Now(), Metadata: callback}
+	return nil
+}
+
+func (this *AsyncProducer) Flush() error {
+	deadline := time.Now().Add(this.config.FlushTimeout)
+	for len(this.pending) > 0 {
+		if time.Now().After(deadline) {
+			return errors.New("timeout on kafka flush; " + strconv.Itoa(len(this.pending)) + " messages are not delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	this.failedMux.Lock()
+	failed := this.failed
+	this.failed = 0
+	this.failedMux.Unlock()
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " kafka messages could not be delivered")
+	}
+	return nil
+}
+
+//flushes pending messages and closes the producer
+//later produce calls return ErrProducerClosed instead of sending to the closed input channel
+func (this *AsyncProducer) Close() {
+	err := this.Flush()
+	if err != nil {
+		log.Println("ERROR: on kafka producer close", err)
+	}
+	this.closeMux.Lock()
+	if this.closed {
+		this.closeMux.Unlock()
+		return
+	}
+	this.closed = true
+	this.closeMux.Unlock()
+	this.producer.AsyncClose()
+	this.done.Wait()
+}
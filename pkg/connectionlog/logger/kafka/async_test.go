@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsyncProducerDelivery(t *testing.T) {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.Return.Successes = true
+	mock := mocks.NewAsyncProducer(t, saramaConfig)
+	mock.ExpectInputAndSucceed()
+	mock.ExpectInputAndFail(errors.New("test error"))
+	mock.ExpectInputAndSucceed()
+
+	config := DefaultProducerConfig
+	config.FlushTimeout = time.Second
+	producer := newAsyncProducer(mock, Cluster{}, config)
+	producer.usedTopics["test"] = true
+
+	mux := sync.Mutex{}
+	delivered := 0
+	failed := 0
+	callback := func(err error) {
+		mux.Lock()
+		defer mux.Unlock()
+		if err != nil {
+			failed++
+		} else {
+			delivered++
+		}
+	}
+
+	for _, message := range []string{"msg1", "msg2", "msg3"} {
+		err := producer.ProduceMessage("test", message, "key", callback)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := producer.Flush()
+	if err == nil {
+		t.Error("expected delivery error on flush")
+	}
+	mux.Lock()
+	if delivered != 2 || failed != 1 {
+		t.Error(delivered, failed)
+	}
+	mux.Unlock()
+
+	err = producer.Flush()
+	if err != nil {
+		t.Error("delivery errors should be reset by flush", err)
+	}
+	producer.Close()
+
+	err = producer.ProduceMessage("test", "msg4", "key", callback)
+	if err != ErrProducerClosed {
+		t.Error(err)
+	}
+	producer.Close()
+}
+
+func TestAsyncProducerFlushTimeout(t *testing.T) {
+	config := DefaultProducerConfig
+	config.FlushTimeout = 50 * time.Millisecond
+	producer := &AsyncProducer{config: config, pending: make(chan bool, 2)}
+	producer.pending <- true
+	err := producer.Flush()
+	if err == nil {
+		t.Error("expected flush timeout")
+	}
+}
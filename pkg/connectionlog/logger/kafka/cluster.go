@@ -0,0 +1,163 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"connection-check/pkg/configuration"
+	"errors"
+	"github.com/Shopify/sarama"
+	"log"
+	"runtime/debug"
+	"strings"
+)
+
+//kafka cluster connection
+//if Bootstrap is set, brokers are connected directly and topics are created with the kafka admin protocol;
+//otherwise brokers and controller are read from Zookeeper
+type Cluster struct {
+	Zookeeper    string
+	Bootstrap    []string
+	Security     *Security              //optional; tls and sasl settings of all connections
+	DefaultTopic TopicConfig            //settings of topics not listed in Topics; unset values use DefaultTopicConfig
+	Topics       map[string]TopicConfig //settings per topic
+	VerifyTopics bool                   //if true, missing topics are not created but reported as error
+}
+
+//splits a comma separated list of bootstrap servers
+func NewCluster(zookeeper string, bootstrap string) Cluster {
+	result := Cluster{Zookeeper: zookeeper}
+	for _, server := range strings.Split(bootstrap, ",") {
+		server = strings.TrimSpace(server)
+		if server != "" {
+			result.Bootstrap = append(result.Bootstrap, server)
+		}
+	}
+	return result
+}
+
+//creates the cluster with security and topic settings of the config; tokens are used for OAUTHBEARER
+func NewClusterFromConfig(config configuration.Config, tokens TokenSource) (result Cluster, err error) {
+	result = NewCluster(config.ZookeeperUrl, config.KafkaBootstrap)
+	result.Security, err = NewSecurityFromConfig(config, tokens)
+	if err != nil {
+		return result, err
+	}
+	result.DefaultTopic = TopicConfig{
+		Partitions:        config.KafkaTopicPartitions,
+		ReplicationFactor: config.KafkaTopicReplicationFactor,
+		Configs:           config.KafkaTopicConfigs,
+	}
+	result.Topics = map[string]TopicConfig{}
+	for topic, topicConfig := range config.KafkaTopics {
+		result.Topics[topic] = TopicConfig{
+			Partitions:        topicConfig.Partitions,
+			ReplicationFactor: topicConfig.ReplicationFactor,
+			Configs:           topicConfig.Configs,
+		}
+	}
+	result.VerifyTopics = config.KafkaVerifyTopics
+	return result, nil
+}
+
+func (this Cluster) UsesBootstrap() bool {
+	return len(this.Bootstrap) > 0
+}
+
+func (this Cluster) Brokers() (brokers []string, err error) {
+	if this.UsesBootstrap() {
+		return this.Bootstrap, nil
+	}
+	return GetBroker(this.Zookeeper)
+}
+
+func (this Cluster) Controller() (controller string, err error) {
+	if !this.UsesBootstrap() {
+		return GetKafkaController(this.Zookeeper)
+	}
+	client, err := sarama.NewClient(this.Bootstrap, this.saramaConfig())
+	if err != nil {
+		return controller, err
+	}
+	defer client.Close()
+	broker, err := client.Controller()
+	if err != nil {
+		return controller, err
+	}
+	return broker.Addr(), nil
+}
+
+//creates missing topics with their TopicConfig; the settings of existing topics are verified
+func (this Cluster) InitTopic(topics ...string) (err error) {
+	admin, err := this.topicAdmin()
+	if err != nil {
+		return err
+	}
+	defer admin.close()
+	return this.initTopics(admin, topics...)
+}
+
+//existing topics are ignored
+func (this Cluster) InitTopicWithConfig(numPartitions int, replicationFactor int, topics ...string) (err error) {
+	if !this.UsesBootstrap() {
+		dialer, err := this.Security.Dialer()
+		if err != nil {
+			return err
+		}
+		return initTopicWithDialer(dialer, this.Zookeeper, numPartitions, replicationFactor, topics...)
+	}
+	admin, err := sarama.NewClusterAdmin(this.Bootstrap, this.saramaConfig())
+	if err != nil {
+		log.Println("ERROR: unable to connect to kafka controller", err)
+		return err
+	}
+	defer admin.Close()
+	for _, topic := range topics {
+		err = admin.CreateTopic(topic, &sarama.TopicDetail{
+			NumPartitions:     int32(numPartitions),
+			ReplicationFactor: int16(replicationFactor),
+		}, false)
+		if topicErr, ok := err.(*sarama.TopicError); ok && topicErr.Err == sarama.ErrTopicAlreadyExists {
+			err = nil
+		}
+		if err != nil {
+			debug.PrintStack()
+			return errors.New("unable to create topic " + topic + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (this Cluster) EnsureTopic(topic string, knownTopics *map[string]bool) (err error) {
+	if (*knownTopics)[topic] {
+		return nil
+	}
+	err = this.InitTopic(topic)
+	if err != nil {
+		log.Println("ERROR:", err)
+		debug.PrintStack()
+		return err
+	}
+	(*knownTopics)[topic] = true
+	return
+}
+
+func (this Cluster) saramaConfig() *sarama.Config {
+	result := sarama.NewConfig()
+	result.Version = sarama.V2_2_0_0
+	this.Security.apply(result)
+	return result
+}
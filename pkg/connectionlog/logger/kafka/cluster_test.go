@@ -0,0 +1,69 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"reflect"
+	"testing"
+)
+
+func TestNewCluster(t *testing.T) {
+	cluster := NewCluster("zk:2181", " kafka-0:9092, kafka-1:9092 ,")
+	if !reflect.DeepEqual(cluster.Bootstrap, []string{"kafka-0:9092", "kafka-1:9092"}) || !cluster.UsesBootstrap() {
+		t.Error(cluster)
+	}
+	cluster = NewCluster("zk:2181", "")
+	if cluster.UsesBootstrap() || cluster.Zookeeper != "zk:2181" {
+		t.Error(cluster)
+	}
+}
+
+func TestClusterBootstrap(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+		"CreateTopicsRequest": sarama.NewMockCreateTopicsResponse(t),
+	})
+
+	cluster := Cluster{Bootstrap: []string{broker.Addr()}}
+
+	brokers, err := cluster.Brokers()
+	if err != nil || !reflect.DeepEqual(brokers, []string{broker.Addr()}) {
+		t.Error(brokers, err)
+	}
+
+	controller, err := cluster.Controller()
+	if err != nil || controller != broker.Addr() {
+		t.Error(controller, err)
+	}
+
+	known := map[string]bool{}
+	err = cluster.EnsureTopic("device_log", &known)
+	if err != nil || !known["device_log"] {
+		t.Error(err, known)
+	}
+
+	//the mock rejects topics with reserved prefix
+	err = cluster.InitTopic("_reserved")
+	if err == nil {
+		t.Error("expected error")
+	}
+}
@@ -27,14 +27,18 @@ import (
 )
 
 func NewConsumer(zk string, groupid string, topic string, listener func(topic string, msg []byte, time time.Time) error, errorhandler func(err error, consumer *Consumer)) (consumer *Consumer, err error) {
-	consumer = &Consumer{groupId: groupid, zkUrl: zk, topic: topic, listener: listener, errorhandler: errorhandler}
+	return NewClusterConsumer(Cluster{Zookeeper: zk}, groupid, topic, listener, errorhandler)
+}
+
+func NewClusterConsumer(cluster Cluster, groupid string, topic string, listener func(topic string, msg []byte, time time.Time) error, errorhandler func(err error, consumer *Consumer)) (consumer *Consumer, err error) {
+	consumer = &Consumer{groupId: groupid, cluster: cluster, topic: topic, listener: listener, errorhandler: errorhandler}
 	err = consumer.start()
 	return
 }
 
 type Consumer struct {
 	count        int
-	zkUrl        string
+	cluster      Cluster
 	groupId      string
 	topic        string
 	ctx          context.Context
@@ -51,19 +55,25 @@ func (this *Consumer) Stop() {
 func (this *Consumer) start() error {
 	log.Println("DEBUG: consume topic: \"" + this.topic + "\"")
 	this.ctx, this.cancel = context.WithCancel(context.Background())
-	broker, err := GetBroker(this.zkUrl)
+	broker, err := this.cluster.Brokers()
 	if err != nil {
 		log.Println("ERROR: unable to get broker list", err)
 		return err
 	}
-	err = InitTopic(this.zkUrl, this.topic)
+	err = this.cluster.InitTopic(this.topic)
 	if err != nil {
 		log.Println("ERROR: unable to create topic", err)
 		return err
 	}
+	dialer, err := this.cluster.Security.Dialer()
+	if err != nil {
+		log.Println("ERROR: unable to create kafka dialer", err)
+		return err
+	}
 	r := kafka.NewReader(kafka.ReaderConfig{
 		CommitInterval: 0, //synchronous commits
 		Brokers:        broker,
+		Dialer:         dialer,
 		GroupID:        this.groupId,
 		Topic:          this.topic,
 		MaxWait:        1 * time.Second,
@@ -29,15 +29,38 @@ var Fatal = false
 type ProducerInterface interface {
 	Produce(topic string, message string) (err error)
 	ProduceWithKey(topic string, message string, key string) (err error)
+	//callback is called with the delivery result of the message; may be nil
+	ProduceMessage(topic string, message string, key string, callback func(err error)) (err error)
+	//waits until all produced messages are delivered; returns an error if messages could not be delivered since the last flush
+	Flush() error
 	Log(logger *log.Logger)
 	Close()
 }
 
+//record header of a kafka message
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+//implemented by producers that can deliver message headers (e.g. cloudevents in binary mode)
+type HeaderProducer interface {
+	//like ProduceMessage; headers may be nil
+	ProduceMessageWithHeaders(topic string, message string, key string, headers []Header, callback func(err error)) (err error)
+}
+
+func saramaHeaders(headers []Header) (result []sarama.RecordHeader) {
+	for _, header := range headers {
+		result = append(result, sarama.RecordHeader{Key: []byte(header.Key), Value: []byte(header.Value)})
+	}
+	return result
+}
+
 type SyncProducer struct {
 	broker         []string
 	logger         *log.Logger
 	producer       sarama.SyncProducer
-	zk             string
+	cluster        Cluster
 	syncIdempotent bool
 	mux            sync.Mutex
 	usedTopics     map[string]bool
@@ -47,55 +70,36 @@ func (this *SyncProducer) Close() {
 	this.producer.Close()
 }
 
-type AsyncProducer struct {
-	broker     []string
-	logger     *log.Logger
-	producer   sarama.AsyncProducer
-	zk         string
-	usedTopics map[string]bool
-}
-
-func (this *AsyncProducer) Close() {
-	this.producer.Close()
+func PrepareProducer(zk string, sync bool, syncIdempotent bool) (ProducerInterface, error) {
+	config := DefaultProducerConfig
+	config.Async = !sync
+	config.Idempotent = syncIdempotent
+	return PrepareProducerWithConfig(Cluster{Zookeeper: zk}, config)
 }
 
-func PrepareProducer(zk string, sync bool, syncIdempotent bool) (ProducerInterface, error) {
+func PrepareProducerWithConfig(cluster Cluster, config ProducerConfig) (ProducerInterface, error) {
 	var err error
-	broker, err := GetBroker(zk)
+	broker, err := cluster.Brokers()
 	if err != nil {
 		return nil, err
 	}
 	if len(broker) == 0 {
 		return nil, errors.New("missing kafka broker")
 	}
-	if sync {
-		result := &SyncProducer{broker: broker, zk: zk, syncIdempotent: syncIdempotent, usedTopics: map[string]bool{}}
-		sarama_conf := sarama.NewConfig()
-		sarama_conf.Version = sarama.V2_2_0_0
-		sarama_conf.Producer.Return.Errors = true
-		sarama_conf.Producer.Return.Successes = true
-		if syncIdempotent {
-			sarama_conf.Producer.Idempotent = true
-			sarama_conf.Net.MaxOpenRequests = 1
-			sarama_conf.Producer.RequiredAcks = sarama.WaitForAll
-		}
-		result.producer, err = sarama.NewSyncProducer(result.broker, sarama_conf)
-		return result, err
-	} else {
-		result := &AsyncProducer{broker: broker, zk: zk, usedTopics: map[string]bool{}}
-		sarama_conf := sarama.NewConfig()
-		sarama_conf.Version = sarama.V2_2_0_0
-		sarama_conf.Producer.Return.Errors = true
-		sarama_conf.Producer.Return.Successes = false
-		result.producer, err = sarama.NewAsyncProducer(result.broker, sarama_conf)
-		go func() {
-			err, ok := <-result.producer.Errors()
-			if ok {
-				log.Fatal(err)
-			}
-		}()
-		return result, err
+	if config.Async {
+		return NewAsyncProducer(broker, cluster, config)
 	}
+	result := &SyncProducer{broker: broker, cluster: cluster, syncIdempotent: config.Idempotent, usedTopics: map[string]bool{}}
+	sarama_conf := cluster.saramaConfig()
+	sarama_conf.Producer.Return.Errors = true
+	sarama_conf.Producer.Return.Successes = true
+	if config.Idempotent {
+		sarama_conf.Producer.Idempotent = true
+		sarama_conf.Net.MaxOpenRequests = 1
+		sarama_conf.Producer.RequiredAcks = sarama.WaitForAll
+	}
+	result.producer, err = sarama.NewSyncProducer(result.broker, sarama_conf)
+	return result, err
 }
 
 func (this *SyncProducer) Log(logger *log.Logger) {
@@ -108,7 +112,7 @@ func (this *SyncProducer) Produce(topic string, message string) (err error) {
 	if this.logger != nil {
 		this.logger.Println("DEBUG: produce ", topic, message)
 	}
-	err = EnsureTopic(topic, this.zk, &this.usedTopics)
+	err = this.cluster.EnsureTopic(topic, &this.usedTopics)
 	if err != nil {
 		return err
 	}
@@ -116,44 +120,37 @@ func (this *SyncProducer) Produce(topic string, message string) (err error) {
 	return err
 }
 
-func (this *AsyncProducer) Produce(topic string, message string) (err error) {
-	if this.logger != nil {
-		this.logger.Println("DEBUG: produce ", topic, message)
-	}
-	err = EnsureTopic(topic, this.zk, &this.usedTopics)
-	if err != nil {
-		return err
-	}
-	this.producer.Input() <- &sarama.ProducerMessage{Topic: topic, Key: nil, Value: sarama.StringEncoder(message), Timestamp: time.Now()}
-	return
+func (this *SyncProducer) ProduceWithKey(topic string, message string, key string) (err error) {
+	return this.send(topic, message, key, nil)
 }
 
-func (this *SyncProducer) ProduceWithKey(topic string, message string, key string) (err error) {
+func (this *SyncProducer) send(topic string, message string, key string, headers []Header) (err error) {
 	this.mux.Lock()
 	defer this.mux.Unlock()
 	if this.logger != nil {
 		this.logger.Println("DEBUG: produce ", topic, message)
 	}
-	err = EnsureTopic(topic, this.zk, &this.usedTopics)
+	err = this.cluster.EnsureTopic(topic, &this.usedTopics)
 	if err != nil {
 		return err
 	}
-	_, _, err = this.producer.SendMessage(&sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder(key), Value: sarama.StringEncoder(message), Timestamp: time.Now()})
+	_, _, err = this.producer.SendMessage(&sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder(key), Value: sarama.StringEncoder(message), Headers: saramaHeaders(headers), Timestamp: time.Now()})
 	return err
 }
 
-func (this *AsyncProducer) ProduceWithKey(topic string, message string, key string) (err error) {
-	if this.logger != nil {
-		this.logger.Println("DEBUG: produce ", topic, message)
-	}
-	err = EnsureTopic(topic, this.zk, &this.usedTopics)
-	if err != nil {
-		return err
+func (this *SyncProducer) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	return this.ProduceMessageWithHeaders(topic, message, key, nil, callback)
+}
+
+func (this *SyncProducer) ProduceMessageWithHeaders(topic string, message string, key string, headers []Header, callback func(err error)) (err error) {
+	err = this.send(topic, message, key, headers)
+	if callback != nil {
+		callback(err)
 	}
-	this.producer.Input() <- &sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder(key), Value: sarama.StringEncoder(message), Timestamp: time.Now()}
-	return
+	return err
 }
 
-func (this *AsyncProducer) Log(logger *log.Logger) {
-	this.logger = logger
+//messages of the SyncProducer are delivered on return of ProduceWithKey
+func (this *SyncProducer) Flush() error {
+	return nil
 }
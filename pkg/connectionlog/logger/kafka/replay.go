@@ -0,0 +1,241 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+//consumes every partition of a topic from the first offset, without consumer group and commits
+//used to build tables from (compacted) topics; CaughtUp reports if all messages that existed on start are consumed
+//tombstones are passed to the listener with an empty msg, so that the key can be removed from the table
+func NewReplayConsumer(cluster Cluster, topic string, listener func(topic string, key []byte, msg []byte, time time.Time) error, errorhandler func(err error, consumer *ReplayConsumer)) (consumer *ReplayConsumer, err error) {
+	consumer = &ReplayConsumer{cluster: cluster, topic: topic, listener: listener, errorhandler: errorhandler}
+	err = consumer.start()
+	return
+}
+
+type ReplayConsumer struct {
+	cluster        Cluster
+	topic          string
+	listener       func(topic string, key []byte, msg []byte, time time.Time) error
+	errorhandler   func(err error, consumer *ReplayConsumer)
+	mux            sync.Mutex
+	cancel         context.CancelFunc //cancels the readers of the current start; guarded by mux
+	started        bool               //offsets of all partitions are known
+	behind         int                //count of partitions that are not caught up
+	restartPending bool               //a RestartAfter is scheduled
+	stopped        bool               //Stop was called; pending restarts are dropped
+	restartMux     sync.Mutex         //serializes stop and start of restarts
+	wg             sync.WaitGroup
+}
+
+func (this *ReplayConsumer) CaughtUp() bool {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	return this.started && this.behind == 0
+}
+
+func (this *ReplayConsumer) Stop() {
+	this.mux.Lock()
+	this.stopped = true
+	this.mux.Unlock()
+	this.restartMux.Lock()
+	defer this.restartMux.Unlock()
+	this.stop()
+}
+
+//cancels the readers and waits until they are done
+func (this *ReplayConsumer) stop() {
+	this.mux.Lock()
+	cancel := this.cancel
+	this.cancel = nil
+	this.started = false
+	this.mux.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	this.wg.Wait()
+}
+
+//consumes the topic again from the first offset; does nothing after Stop
+func (this *ReplayConsumer) Restart() error {
+	this.restartMux.Lock()
+	defer this.restartMux.Unlock()
+	this.stop()
+	this.mux.Lock()
+	stopped := this.stopped
+	this.mux.Unlock()
+	if stopped {
+		return nil
+	}
+	return this.start()
+}
+
+//restarts the consumer after delay; calls while a restart is pending are ignored, so that failing partitions share one restart
+//an error of the restart is passed to the errorhandler
+func (this *ReplayConsumer) RestartAfter(delay time.Duration) {
+	this.mux.Lock()
+	if this.restartPending || this.stopped {
+		this.mux.Unlock()
+		return
+	}
+	this.restartPending = true
+	this.mux.Unlock()
+	go func() {
+		time.Sleep(delay)
+		this.mux.Lock()
+		this.restartPending = false
+		this.mux.Unlock()
+		err := this.Restart()
+		if err != nil {
+			this.errorhandler(err, this)
+		}
+	}()
+}
+
+//the caller has to hold restartMux; all readers of previous starts are done
+func (this *ReplayConsumer) start() error {
+	log.Println("DEBUG: replay topic: \"" + this.topic + "\"")
+	ctx, cancel := context.WithCancel(context.Background())
+	this.mux.Lock()
+	this.started = false
+	this.cancel = cancel
+	this.mux.Unlock()
+	brokers, err := this.cluster.Brokers()
+	if err != nil {
+		log.Println("ERROR: unable to get broker list", err)
+		return err
+	}
+	dialer, err := this.cluster.Security.Dialer()
+	if err != nil {
+		log.Println("ERROR: unable to create kafka dialer", err)
+		return err
+	}
+	if dialer == nil {
+		dialer = kafka.DefaultDialer
+	}
+	offsets, err := this.readOffsets(ctx, dialer, brokers)
+	if err != nil {
+		log.Println("ERROR: unable to read offsets of topic", this.topic, err)
+		return err
+	}
+	this.mux.Lock()
+	this.started = true
+	this.behind = 0
+	for _, offset := range offsets {
+		if offset.last > offset.first {
+			this.behind++
+		}
+	}
+	this.mux.Unlock()
+	for _, offset := range offsets {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     brokers,
+			Dialer:      dialer,
+			Topic:       this.topic,
+			Partition:   offset.partition,
+			MaxWait:     1 * time.Second,
+			Logger:      log.New(ioutil.Discard, "", 0),
+			ErrorLogger: log.New(ioutil.Discard, "", 0),
+		})
+		this.wg.Add(1)
+		go this.consume(ctx, reader, offset)
+	}
+	return nil
+}
+
+type partitionOffsets struct {
+	partition int
+	first     int64
+	last      int64 //offset of the next message at start
+}
+
+func (this *ReplayConsumer) readOffsets(ctx context.Context, dialer *kafka.Dialer, brokers []string) (result []partitionOffsets, err error) {
+	var partitions []kafka.Partition
+	err = errors.New("no broker available")
+	for _, broker := range brokers {
+		partitions, err = dialer.LookupPartitions(ctx, "tcp", broker, this.topic)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+	for _, partition := range partitions {
+		conn, err := this.dialLeader(ctx, dialer, brokers, partition.ID)
+		if err != nil {
+			return result, err
+		}
+		first, last, err := conn.ReadOffsets()
+		conn.Close()
+		if err != nil {
+			return result, err
+		}
+		result = append(result, partitionOffsets{partition: partition.ID, first: first, last: last})
+	}
+	return result, nil
+}
+
+//tries every bootstrap server, so that one unavailable broker does not prevent the replay
+func (this *ReplayConsumer) dialLeader(ctx context.Context, dialer *kafka.Dialer, brokers []string, partition int) (conn *kafka.Conn, err error) {
+	err = errors.New("no broker available")
+	for _, broker := range brokers {
+		conn, err = dialer.DialLeader(ctx, "tcp", broker, this.topic, partition)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return conn, err
+}
+
+func (this *ReplayConsumer) consume(ctx context.Context, reader *kafka.Reader, offset partitionOffsets) {
+	defer this.wg.Done()
+	defer reader.Close()
+	caughtUp := offset.last <= offset.first
+	for {
+		m, err := reader.ReadMessage(ctx)
+		if err == io.EOF || err == context.Canceled || ctx.Err() != nil {
+			log.Println("close replay consumer for topic ", this.topic, offset.partition)
+			return
+		}
+		if err != nil {
+			log.Println("ERROR: while replaying topic ", this.topic, offset.partition, err)
+			this.errorhandler(err, this)
+			return
+		}
+		err = this.listener(m.Topic, m.Key, m.Value, m.Time)
+		if err != nil {
+			log.Println("ERROR: unable to handle message (skip)", this.topic, m.Offset, err)
+		}
+		if !caughtUp && m.Offset >= offset.last-1 {
+			caughtUp = true
+			this.mux.Lock()
+			this.behind--
+			this.mux.Unlock()
+			log.Println("replay of topic caught up", this.topic, offset.partition)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"github.com/ory/dockertest/v3"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayConsumer(t *testing.T) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	closeZk, _, zkIp, err := Zookeeper(pool)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer closeZk()
+	zookeeperUrl := zkIp + ":2181"
+
+	closeKafka, err := Kafka(pool, zookeeperUrl)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer closeKafka()
+
+	time.Sleep(2 * time.Second)
+
+	cluster := Cluster{Zookeeper: zookeeperUrl, DefaultTopic: TopicConfig{Partitions: 2}}
+	err = cluster.InitTopic("replay")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	producer, err := PrepareProducerWithConfig(cluster, ProducerConfig{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer producer.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		err = producer.ProduceWithKey("replay", "msg-"+key, key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	mux := sync.Mutex{}
+	result := []string{}
+	consumer, err := NewReplayConsumer(cluster, "replay", func(topic string, key []byte, msg []byte, time time.Time) error {
+		mux.Lock()
+		defer mux.Unlock()
+		result = append(result, string(msg))
+		return nil
+	}, func(err error, consumer *ReplayConsumer) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer consumer.Stop()
+
+	for i := 0; i < 30 && !consumer.CaughtUp(); i++ {
+		time.Sleep(time.Second)
+	}
+	if !consumer.CaughtUp() {
+		t.Error("replay not caught up")
+		return
+	}
+	mux.Lock()
+	sort.Strings(result)
+	if len(result) != 3 || result[0] != "msg-a" || result[2] != "msg-c" {
+		t.Error(result)
+	}
+	mux.Unlock()
+
+	err = producer.ProduceWithKey("replay", "msg-d", "d")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(3 * time.Second)
+	mux.Lock()
+	defer mux.Unlock()
+	if len(result) != 4 {
+		t.Error(result)
+	}
+}
+
+func TestReplayConsumerRestartAfter(t *testing.T) {
+	mux := sync.Mutex{}
+	failures := 0
+	//no broker is listening; every restart fails while reading the offsets
+	consumer := &ReplayConsumer{cluster: Cluster{Bootstrap: []string{"127.0.0.1:1"}}, topic: "replay"}
+	consumer.errorhandler = func(err error, consumer *ReplayConsumer) {
+		mux.Lock()
+		defer mux.Unlock()
+		failures++
+	}
+	//e.g. all partitions fail at the same time
+	for i := 0; i < 5; i++ {
+		consumer.RestartAfter(10 * time.Millisecond)
+	}
+	for i := 0; i < 50; i++ {
+		mux.Lock()
+		done := failures > 0
+		mux.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	mux.Lock()
+	if failures != 1 {
+		t.Error(failures)
+	}
+	mux.Unlock()
+	if consumer.CaughtUp() {
+		t.Error("failed consumer should not be caught up")
+	}
+
+	consumer.Stop()
+	consumer.RestartAfter(10 * time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	mux.Lock()
+	if failures != 1 {
+		t.Error("stopped consumer should not restart", failures)
+	}
+	mux.Unlock()
+}
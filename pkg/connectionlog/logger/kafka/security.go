@@ -0,0 +1,254 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/tlsconfig"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	xdgscram "github.com/xdg/scram"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SaslPlain = "PLAIN"
+const SaslScramSha256 = "SCRAM-SHA-256"
+const SaslScramSha512 = "SCRAM-SHA-512"
+const SaslOAuthBearer = "OAUTHBEARER"
+
+//source of OAUTHBEARER access tokens (e.g. auth.Security); a "Bearer " prefix is removed
+type TokenSource interface {
+	Access() (token string, err error)
+}
+
+//tls and sasl settings of kafka connections; a nil *Security connects without both
+type Security struct {
+	Tls           *tls.Config //nil = plaintext
+	SaslMechanism string      //empty = no sasl; SaslPlain, SaslScramSha256, SaslScramSha512 or SaslOAuthBearer
+	SaslUser      string
+	SaslPassword  string
+	Tokens        TokenSource //used by SaslOAuthBearer
+}
+
+//returns nil if neither tls nor sasl is configured
+func NewSecurityFromConfig(config configuration.Config, tokens TokenSource) (result *Security, err error) {
+	useTls := config.KafkaTls || config.KafkaTlsCaFile != "" || config.KafkaTlsClientCertFile != "" || config.KafkaTlsClientKeyFile != "" || config.KafkaTlsInsecureSkipVerify
+	mechanism := strings.ToUpper(strings.TrimSpace(config.KafkaSaslMechanism))
+	if mechanism == "-" {
+		mechanism = ""
+	}
+	if !useTls && mechanism == "" {
+		return nil, nil
+	}
+	result = &Security{SaslMechanism: mechanism, SaslUser: config.KafkaSaslUser, SaslPassword: config.KafkaSaslPassword, Tokens: tokens}
+	if useTls {
+		result.Tls, err = tlsconfig.New(config.KafkaTlsCaFile, config.KafkaTlsClientCertFile, config.KafkaTlsClientKeyFile, config.KafkaTlsInsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, result.Validate()
+}
+
+func (this *Security) Validate() error {
+	if this == nil {
+		return nil
+	}
+	switch this.SaslMechanism {
+	case "":
+		return nil
+	case SaslPlain, SaslScramSha256, SaslScramSha512:
+		if this.SaslUser == "" {
+			return errors.New("missing kafka_sasl_user for sasl mechanism " + this.SaslMechanism)
+		}
+		return nil
+	case SaslOAuthBearer:
+		if this.Tokens == nil {
+			return errors.New("missing token source for sasl mechanism " + SaslOAuthBearer)
+		}
+		return nil
+	default:
+		return errors.New("unknown kafka sasl mechanism " + this.SaslMechanism)
+	}
+}
+
+//sets the tls and sasl settings of a sarama config
+func (this *Security) apply(config *sarama.Config) {
+	if this == nil {
+		return
+	}
+	if this.Tls != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = this.Tls
+	}
+	if this.SaslMechanism == "" {
+		return
+	}
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.Mechanism = sarama.SASLMechanism(this.SaslMechanism)
+	config.Net.SASL.User = this.SaslUser
+	config.Net.SASL.Password = this.SaslPassword
+	switch this.SaslMechanism {
+	case SaslScramSha256:
+		config.Net.SASL.SCRAMClient = newScramClient(xdgscram.HashGeneratorFcn(sha256.New), scramExchangeTimeout(config))
+	case SaslScramSha512:
+		config.Net.SASL.SCRAMClient = newScramClient(xdgscram.HashGeneratorFcn(sha512.New), scramExchangeTimeout(config))
+	case SaslOAuthBearer:
+		config.Net.SASL.TokenProvider = tokenProvider{tokens: this.Tokens}
+	}
+}
+
+//returns a kafka-go dialer with the tls and sasl settings; nil if no security is configured
+func (this *Security) Dialer() (result *kafka.Dialer, err error) {
+	if this == nil {
+		return nil, nil
+	}
+	result = &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true, TLS: this.Tls}
+	switch this.SaslMechanism {
+	case SaslPlain:
+		result.SASLMechanism = plain.Mechanism{Username: this.SaslUser, Password: this.SaslPassword}
+	case SaslScramSha256:
+		result.SASLMechanism, err = scram.Mechanism(scram.SHA256, this.SaslUser, this.SaslPassword)
+	case SaslScramSha512:
+		result.SASLMechanism, err = scram.Mechanism(scram.SHA512, this.SaslUser, this.SaslPassword)
+	case SaslOAuthBearer:
+		result.SASLMechanism = oauthBearer{tokens: this.Tokens}
+	}
+	return result, err
+}
+
+func accessToken(tokens TokenSource) (string, error) {
+	token, err := tokens.Access()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(token, "Bearer "), nil
+}
+
+//sarama.SCRAMClient based on github.com/xdg/scram
+//sarama 1.22 uses one SCRAMClient for all broker connections, so concurrent exchanges are serialized:
+//Begin waits until the previous exchange is done, failed or exceeded the timeout (sarama does not report network errors to the client)
+type scramClient struct {
+	hash         xdgscram.HashGeneratorFcn
+	timeout      time.Duration
+	lock         chan bool //held from Begin until the end of the exchange
+	mux          sync.Mutex
+	conversation *xdgscram.ClientConversation
+	release      func() //releases the lock of the current exchange; only the first call counts
+}
+
+func newScramClient(hash xdgscram.HashGeneratorFcn, timeout time.Duration) *scramClient {
+	return &scramClient{hash: hash, timeout: timeout, lock: make(chan bool, 1)}
+}
+
+//an exchange takes two round trips
+func scramExchangeTimeout(config *sarama.Config) time.Duration {
+	return 2 * (config.Net.WriteTimeout + config.Net.ReadTimeout)
+}
+
+func (this *scramClient) Begin(userName, password, authzID string) error {
+	this.lock <- true
+	once := sync.Once{}
+	unlock := func() { once.Do(func() { <-this.lock }) }
+	timer := time.AfterFunc(this.timeout, unlock)
+	release := func() {
+		timer.Stop()
+		unlock()
+	}
+	client, err := this.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		release()
+		return err
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	this.conversation = client.NewConversation()
+	this.release = release
+	return nil
+}
+
+func (this *scramClient) Step(challenge string) (response string, err error) {
+	conversation, release := this.current()
+	response, err = conversation.Step(challenge)
+	if err != nil {
+		release()
+	}
+	return response, err
+}
+
+//sarama calls Done after every step; the exchange ends with Done() == true
+func (this *scramClient) Done() bool {
+	conversation, release := this.current()
+	done := conversation.Done()
+	if done {
+		release()
+	}
+	return done
+}
+
+func (this *scramClient) current() (*xdgscram.ClientConversation, func()) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	return this.conversation, this.release
+}
+
+//sarama.AccessTokenProvider
+type tokenProvider struct {
+	tokens TokenSource
+}
+
+func (this tokenProvider) Token() (*sarama.AccessToken, error) {
+	token, err := accessToken(this.tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &sarama.AccessToken{Token: token}, nil
+}
+
+//kafka-go sasl.Mechanism for OAUTHBEARER (RFC 7628)
+type oauthBearer struct {
+	tokens TokenSource
+}
+
+var _ sasl.Mechanism = oauthBearer{}
+
+func (this oauthBearer) Start(ctx context.Context) (mechanism string, initialResponse []byte, err error) {
+	token, err := accessToken(this.tokens)
+	if err != nil {
+		return "", nil, err
+	}
+	return SaslOAuthBearer, []byte("n,,\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+//the server only sends a challenge if the token was rejected
+func (this oauthBearer) Next(ctx context.Context, challenge []byte) (done bool, response []byte, err error) {
+	if len(challenge) > 0 {
+		return false, nil, errors.New("oauthbearer authentication failed: " + string(challenge))
+	}
+	return true, nil, nil
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"connection-check/pkg/configuration"
+	"context"
+	"crypto/sha512"
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	xdgscram "github.com/xdg/scram"
+	"sync"
+	"testing"
+	"time"
+)
+
+type tokenMock struct {
+	token string
+	err   error
+}
+
+func (this tokenMock) Access() (string, error) {
+	return this.token, this.err
+}
+
+func TestNewSecurityFromConfig(t *testing.T) {
+	t.Run("none", func(t *testing.T) {
+		security, err := NewSecurityFromConfig(&configuration.ConfigStruct{KafkaSaslMechanism: "-"}, nil)
+		if err != nil || security != nil {
+			t.Error(security, err)
+		}
+		dialer, err := security.Dialer()
+		if err != nil || dialer != nil {
+			t.Error(dialer, err)
+		}
+		config := Cluster{}.saramaConfig()
+		if config.Net.TLS.Enable || config.Net.SASL.Enable {
+			t.Error(config.Net)
+		}
+	})
+
+	t.Run("unknown mechanism", func(t *testing.T) {
+		_, err := NewSecurityFromConfig(&configuration.ConfigStruct{KafkaSaslMechanism: "GSSAPI"}, nil)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("missing user", func(t *testing.T) {
+		_, err := NewSecurityFromConfig(&configuration.ConfigStruct{KafkaSaslMechanism: "scram-sha-512"}, nil)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("missing ca file", func(t *testing.T) {
+		_, err := NewSecurityFromConfig(&configuration.ConfigStruct{KafkaTlsCaFile: "/does/not/exist.pem"}, nil)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("plain over tls", func(t *testing.T) {
+		security, err := NewSecurityFromConfig(&configuration.ConfigStruct{KafkaTls: true, KafkaSaslMechanism: "plain", KafkaSaslUser: "user", KafkaSaslPassword: "pw"}, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		config := Cluster{Security: security}.saramaConfig()
+		if !config.Net.TLS.Enable || config.Net.TLS.Config == nil || !config.Net.SASL.Enable || config.Net.SASL.Mechanism != sarama.SASLTypePlaintext || config.Net.SASL.User != "user" {
+			t.Error(config.Net)
+		}
+		if err = config.Validate(); err != nil {
+			t.Error(err)
+		}
+		dialer, err := security.Dialer()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if dialer.TLS == nil || dialer.SASLMechanism != (plain.Mechanism{Username: "user", Password: "pw"}) {
+			t.Error(dialer)
+		}
+	})
+
+	t.Run("scram", func(t *testing.T) {
+		security, err := NewSecurityFromConfig(&configuration.ConfigStruct{KafkaSaslMechanism: SaslScramSha512, KafkaSaslUser: "user", KafkaSaslPassword: "pw"}, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		config := Cluster{Security: security}.saramaConfig()
+		if config.Net.TLS.Enable || config.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 || config.Net.SASL.SCRAMClient == nil {
+			t.Error(config.Net)
+		}
+		if err = config.Validate(); err != nil {
+			t.Error(err)
+		}
+		dialer, err := security.Dialer()
+		if err != nil || dialer.TLS != nil || dialer.SASLMechanism == nil {
+			t.Error(dialer, err)
+		}
+	})
+
+	t.Run("oauthbearer", func(t *testing.T) {
+		security, err := NewSecurityFromConfig(&configuration.ConfigStruct{KafkaTls: true, KafkaSaslMechanism: SaslOAuthBearer}, tokenMock{token: "Bearer foo"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		config := Cluster{Security: security}.saramaConfig()
+		if err = config.Validate(); err != nil {
+			t.Error(err)
+			return
+		}
+		token, err := config.Net.SASL.TokenProvider.Token()
+		if err != nil || token.Token != "foo" {
+			t.Error(token, err)
+		}
+		dialer, err := security.Dialer()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mechanism, response, err := dialer.SASLMechanism.Start(context.Background())
+		if err != nil || mechanism != SaslOAuthBearer || string(response) != "n,,\x01auth=Bearer foo\x01\x01" {
+			t.Error(mechanism, string(response), err)
+		}
+		done, _, err := dialer.SASLMechanism.Next(context.Background(), nil)
+		if !done || err != nil {
+			t.Error(done, err)
+		}
+		_, _, err = dialer.SASLMechanism.Next(context.Background(), []byte(`{"status":"invalid_token"}`))
+		if err == nil {
+			t.Error("expected rejected token error")
+		}
+	})
+
+	t.Run("token error", func(t *testing.T) {
+		provider := tokenProvider{tokens: tokenMock{err: errors.New("unavailable")}}
+		_, err := provider.Token()
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestScramClient(t *testing.T) {
+	hash := xdgscram.HashGeneratorFcn(sha512.New)
+	reference, err := hash.NewClient("user", "pw", "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	credentials := reference.GetStoredCredentials(xdgscram.KeyFactors{Salt: "salt", Iters: 4096})
+	server, err := hash.NewServer(func(user string) (xdgscram.StoredCredentials, error) {
+		if user != "user" {
+			return xdgscram.StoredCredentials{}, errors.New("unknown user")
+		}
+		return credentials, nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//same exchange as sarama.Broker.sendAndReceiveSASLSCRAMv1
+	exchange := func(client *scramClient) error {
+		conversation := server.NewConversation()
+		err := client.Begin("user", "pw", "")
+		if err != nil {
+			return err
+		}
+		msg, err := client.Step("")
+		for err == nil && !client.Done() {
+			var challenge string
+			challenge, err = conversation.Step(msg)
+			if err != nil {
+				break
+			}
+			time.Sleep(time.Millisecond) //network round trip
+			msg, err = client.Step(challenge)
+		}
+		if err == nil && !conversation.Valid() {
+			err = errors.New("invalid conversation")
+		}
+		return err
+	}
+
+	//the client is reused for a second connection
+	client := newScramClient(hash, time.Minute)
+	for i := 0; i < 2; i++ {
+		if err = exchange(client); err != nil {
+			t.Error(i, err)
+			return
+		}
+	}
+
+	//sarama shares the client between concurrent broker connections
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := exchange(client); err != nil {
+				t.Error(i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	//an exchange that is aborted without report (e.g. a network error) blocks the next one only until the timeout
+	client = newScramClient(hash, 50*time.Millisecond)
+	if err = client.Begin("user", "pw", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = exchange(client); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/segmentio/kafka-go"
+	"github.com/wvanbergen/kazoo-go"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//settings of a created topic; existing topics are only verified against explicitly configured settings
+type TopicConfig struct {
+	Partitions        int
+	ReplicationFactor int
+	Configs           map[string]string //topic-level configs, e.g. cleanup.policy=compact or retention.ms
+}
+
+var DefaultTopicConfig = TopicConfig{Partitions: 1, ReplicationFactor: 1}
+
+//returns the settings of the topic; unset values are taken from Cluster.DefaultTopic or DefaultTopicConfig
+func (this Cluster) TopicConfig(topic string) (result TopicConfig) {
+	result = this.configuredTopic(topic)
+	if result.Partitions <= 0 {
+		result.Partitions = DefaultTopicConfig.Partitions
+	}
+	if result.ReplicationFactor <= 0 {
+		result.ReplicationFactor = DefaultTopicConfig.ReplicationFactor
+	}
+	return result
+}
+
+//returns the settings of Cluster.Topics and Cluster.DefaultTopic without DefaultTopicConfig; unset values are 0 or nil
+func (this Cluster) configuredTopic(topic string) (result TopicConfig) {
+	result = this.DefaultTopic
+	if config, ok := this.Topics[topic]; ok {
+		if config.Partitions > 0 {
+			result.Partitions = config.Partitions
+		}
+		if config.ReplicationFactor > 0 {
+			result.ReplicationFactor = config.ReplicationFactor
+		}
+		if config.Configs != nil {
+			result.Configs = config.Configs
+		}
+	}
+	return result
+}
+
+//returns an error listing every setting of actual that differs from this
+//unset partitions and replication factor (<= 0) and configs of actual that are not listed in this are ignored
+func (this TopicConfig) Verify(topic string, actual TopicConfig) error {
+	mismatches := []string{}
+	if this.Partitions > 0 && actual.Partitions != this.Partitions {
+		mismatches = append(mismatches, "partitions "+strconv.Itoa(actual.Partitions)+" (expected "+strconv.Itoa(this.Partitions)+")")
+	}
+	if this.ReplicationFactor > 0 && actual.ReplicationFactor != this.ReplicationFactor {
+		mismatches = append(mismatches, "replication factor "+strconv.Itoa(actual.ReplicationFactor)+" (expected "+strconv.Itoa(this.ReplicationFactor)+")")
+	}
+	names := []string{}
+	for name := range this.Configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, ok := actual.Configs[name]
+		if !ok {
+			mismatches = append(mismatches, name+" not set (expected "+this.Configs[name]+")")
+		} else if value != this.Configs[name] {
+			mismatches = append(mismatches, name+"="+value+" (expected "+this.Configs[name]+")")
+		}
+	}
+	if len(mismatches) > 0 {
+		return errors.New("settings of existing topic " + topic + " do not match: " + strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+func (this TopicConfig) configNames() (result []string) {
+	for name := range this.Configs {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+//reads and creates topics with the kafka admin protocol (bootstrap) or zookeeper
+type topicAdmin interface {
+	describe(topic string, configNames []string) (actual TopicConfig, exists bool, err error)
+	create(topic string, config TopicConfig) error //an already existing topic is no error
+	close()
+}
+
+func (this Cluster) topicAdmin() (topicAdmin, error) {
+	if this.UsesBootstrap() {
+		admin, err := sarama.NewClusterAdmin(this.Bootstrap, this.saramaConfig())
+		if err != nil {
+			log.Println("ERROR: unable to connect to kafka controller", err)
+			return nil, err
+		}
+		return &saramaTopicAdmin{admin: admin}, nil
+	}
+	dialer, err := this.Security.Dialer()
+	if err != nil {
+		return nil, err
+	}
+	kz, err := newKazoo(this.Zookeeper)
+	if err != nil {
+		log.Println("ERROR: unable to connect to zookeeper", err)
+		return nil, err
+	}
+	return &zookeeperTopicAdmin{kz: kz, zookeeper: this.Zookeeper, dialer: dialer}, nil
+}
+
+//creates missing topics with their TopicConfig and verifies the explicitly configured settings of existing topics
+//with VerifyTopics, missing topics are not created but reported as error
+func (this Cluster) initTopics(admin topicAdmin, topics ...string) error {
+	for _, topic := range topics {
+		configured := this.configuredTopic(topic)
+		actual, exists, err := admin.describe(topic, configured.configNames())
+		if err != nil {
+			debug.PrintStack()
+			return errors.New("unable to describe topic " + topic + ": " + err.Error())
+		}
+		if exists {
+			err = configured.Verify(topic, actual)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if this.VerifyTopics {
+			return errors.New("missing topic " + topic)
+		}
+		expected := this.TopicConfig(topic)
+		err = admin.create(topic, expected)
+		if err != nil {
+			debug.PrintStack()
+			return errors.New("unable to create topic " + topic + ": " + err.Error())
+		}
+		log.Println("created topic", topic, expected.Partitions, expected.ReplicationFactor, expected.Configs)
+	}
+	return nil
+}
+
+type saramaTopicAdmin struct {
+	admin sarama.ClusterAdmin
+}
+
+func (this *saramaTopicAdmin) describe(topic string, configNames []string) (actual TopicConfig, exists bool, err error) {
+	metadata, err := this.admin.DescribeTopics([]string{topic})
+	if err != nil {
+		return actual, false, err
+	}
+	for _, element := range metadata {
+		if element.Name != topic || element.Err == sarama.ErrUnknownTopicOrPartition {
+			continue
+		}
+		if element.Err != sarama.ErrNoError {
+			return actual, false, element.Err
+		}
+		exists = true
+		actual.Partitions = len(element.Partitions)
+		if len(element.Partitions) > 0 {
+			actual.ReplicationFactor = len(element.Partitions[0].Replicas)
+		}
+	}
+	if !exists || len(configNames) == 0 {
+		return actual, exists, nil
+	}
+	entries, err := this.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic, ConfigNames: configNames})
+	if err != nil {
+		return actual, exists, err
+	}
+	actual.Configs = map[string]string{}
+	for _, entry := range entries {
+		actual.Configs[entry.Name] = entry.Value
+	}
+	return actual, exists, nil
+}
+
+func (this *saramaTopicAdmin) create(topic string, config TopicConfig) error {
+	entries := map[string]*string{}
+	for name, value := range config.Configs {
+		value := value
+		entries[name] = &value
+	}
+	err := this.admin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     int32(config.Partitions),
+		ReplicationFactor: int16(config.ReplicationFactor),
+		ConfigEntries:     entries,
+	}, false)
+	if topicErr, ok := err.(*sarama.TopicError); ok && topicErr.Err == sarama.ErrTopicAlreadyExists {
+		return nil
+	}
+	return err
+}
+
+func (this *saramaTopicAdmin) close() {
+	this.admin.Close()
+}
+
+//zookeeper only contains the topic-level overrides of the configs; settings inherited from the broker count as not set
+type zookeeperTopicAdmin struct {
+	kz        *kazoo.Kazoo
+	zookeeper string
+	dialer    *kafka.Dialer
+}
+
+func (this *zookeeperTopicAdmin) describe(topic string, configNames []string) (actual TopicConfig, exists bool, err error) {
+	element := this.kz.Topic(topic)
+	exists, err = element.Exists()
+	if err != nil || !exists {
+		return actual, exists, err
+	}
+	partitions, err := element.Partitions()
+	if err != nil {
+		return actual, exists, err
+	}
+	actual.Partitions = len(partitions)
+	if len(partitions) > 0 {
+		actual.ReplicationFactor = len(partitions[0].Replicas)
+	}
+	if len(configNames) > 0 {
+		actual.Configs, err = element.Config()
+		if err == zk.ErrNoNode {
+			actual.Configs, err = map[string]string{}, nil
+		}
+	}
+	return actual, exists, err
+}
+
+func (this *zookeeperTopicAdmin) create(topic string, config TopicConfig) error {
+	entries := []kafka.ConfigEntry{}
+	for _, name := range config.configNames() {
+		entries = append(entries, kafka.ConfigEntry{ConfigName: name, ConfigValue: config.Configs[name]})
+	}
+	return createTopicsWithDialer(this.dialer, this.zookeeper, kafka.TopicConfig{
+		Topic:             topic,
+		NumPartitions:     config.Partitions,
+		ReplicationFactor: config.ReplicationFactor,
+		ConfigEntries:     entries,
+	})
+}
+
+func (this *zookeeperTopicAdmin) close() {
+	this.kz.Close()
+}
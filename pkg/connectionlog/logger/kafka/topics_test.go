@@ -0,0 +1,163 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type topicAdminMock struct {
+	topics  map[string]TopicConfig
+	created []string
+}
+
+func (this *topicAdminMock) describe(topic string, configNames []string) (actual TopicConfig, exists bool, err error) {
+	actual, exists = this.topics[topic]
+	return actual, exists, nil
+}
+
+func (this *topicAdminMock) create(topic string, config TopicConfig) error {
+	this.topics[topic] = config
+	this.created = append(this.created, topic)
+	return nil
+}
+
+func (this *topicAdminMock) close() {}
+
+func TestClusterTopicConfig(t *testing.T) {
+	cluster := Cluster{
+		DefaultTopic: TopicConfig{ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "-1"}},
+		Topics: map[string]TopicConfig{
+			"device_log": {Partitions: 6, Configs: map[string]string{"cleanup.policy": "compact"}},
+		},
+	}
+	expected := TopicConfig{Partitions: 6, ReplicationFactor: 3, Configs: map[string]string{"cleanup.policy": "compact"}}
+	if actual := cluster.TopicConfig("device_log"); !reflect.DeepEqual(actual, expected) {
+		t.Error(actual)
+	}
+	expected = TopicConfig{Partitions: 1, ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "-1"}}
+	if actual := cluster.TopicConfig("other"); !reflect.DeepEqual(actual, expected) {
+		t.Error(actual)
+	}
+	if actual := (Cluster{}).TopicConfig("other"); !reflect.DeepEqual(actual, DefaultTopicConfig) {
+		t.Error(actual)
+	}
+}
+
+func TestTopicConfigVerify(t *testing.T) {
+	expected := TopicConfig{Partitions: 3, ReplicationFactor: 2, Configs: map[string]string{"cleanup.policy": "compact", "retention.ms": "-1"}}
+	err := expected.Verify("device_log", TopicConfig{Partitions: 3, ReplicationFactor: 2, Configs: map[string]string{"cleanup.policy": "compact", "retention.ms": "-1", "segment.ms": "100"}})
+	if err != nil {
+		t.Error(err)
+	}
+	err = expected.Verify("device_log", TopicConfig{Partitions: 1, ReplicationFactor: 1, Configs: map[string]string{"cleanup.policy": "delete"}})
+	if err == nil {
+		t.Error("expected mismatch")
+		return
+	}
+	message := "settings of existing topic device_log do not match: partitions 1 (expected 3), replication factor 1 (expected 2), cleanup.policy=delete (expected compact), retention.ms not set (expected -1)"
+	if err.Error() != message {
+		t.Error(err)
+	}
+}
+
+func TestInitTopics(t *testing.T) {
+	cluster := Cluster{Topics: map[string]TopicConfig{"device_log": {Partitions: 3, Configs: map[string]string{"cleanup.policy": "compact"}}}}
+
+	t.Run("create missing", func(t *testing.T) {
+		admin := &topicAdminMock{topics: map[string]TopicConfig{}}
+		err := cluster.initTopics(admin, "device_log", "gateway_log")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !reflect.DeepEqual(admin.created, []string{"device_log", "gateway_log"}) || admin.topics["device_log"].Partitions != 3 {
+			t.Error(admin.created, admin.topics)
+		}
+	})
+
+	t.Run("existing matches", func(t *testing.T) {
+		admin := &topicAdminMock{topics: map[string]TopicConfig{"device_log": {Partitions: 3, ReplicationFactor: 1, Configs: map[string]string{"cleanup.policy": "compact"}}}}
+		err := cluster.initTopics(admin, "device_log")
+		if err != nil || len(admin.created) != 0 {
+			t.Error(err, admin.created)
+		}
+	})
+
+	t.Run("existing mismatch", func(t *testing.T) {
+		admin := &topicAdminMock{topics: map[string]TopicConfig{"device_log": {Partitions: 1, ReplicationFactor: 1}}}
+		err := cluster.initTopics(admin, "device_log")
+		if err == nil || !strings.Contains(err.Error(), "partitions 1 (expected 3)") {
+			t.Error(err)
+		}
+	})
+
+	t.Run("existing without topic config", func(t *testing.T) {
+		admin := &topicAdminMock{topics: map[string]TopicConfig{"gateway_log": {Partitions: 3, ReplicationFactor: 3}}}
+		err := (Cluster{}).initTopics(admin, "gateway_log")
+		if err != nil || len(admin.created) != 0 {
+			t.Error(err, admin.created)
+		}
+	})
+
+	t.Run("verify only", func(t *testing.T) {
+		verify := cluster
+		verify.VerifyTopics = true
+		admin := &topicAdminMock{topics: map[string]TopicConfig{"device_log": {Partitions: 3, ReplicationFactor: 1, Configs: map[string]string{"cleanup.policy": "compact"}}}}
+		err := verify.initTopics(admin, "device_log")
+		if err != nil {
+			t.Error(err)
+		}
+		err = verify.initTopics(admin, "gateway_log")
+		if err == nil || err.Error() != "missing topic gateway_log" || len(admin.created) != 0 {
+			t.Error(err, admin.created)
+		}
+	})
+}
+
+func TestClusterBootstrapExistingTopic(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("device_log", 0, broker.BrokerID()),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t), //retention.ms=5000
+	})
+
+	cluster := Cluster{Bootstrap: []string{broker.Addr()}, Topics: map[string]TopicConfig{"device_log": {Configs: map[string]string{"retention.ms": "5000"}}}}
+	err := cluster.InitTopic("device_log")
+	if err != nil {
+		t.Error(err)
+	}
+
+	cluster.Topics["device_log"] = TopicConfig{Partitions: 2, Configs: map[string]string{"retention.ms": "1000"}}
+	err = cluster.InitTopic("device_log")
+	if err == nil || err.Error() != "settings of existing topic device_log do not match: partitions 1 (expected 2), retention.ms=5000 (expected 1000)" {
+		t.Error(err)
+	}
+
+	cluster.VerifyTopics = true
+	err = cluster.InitTopic("gateway_log")
+	if err == nil || err.Error() != "missing topic gateway_log" {
+		t.Error(err)
+	}
+}
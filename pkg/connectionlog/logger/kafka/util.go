@@ -22,21 +22,10 @@ import (
 	"github.com/wvanbergen/kazoo-go"
 	"io/ioutil"
 	"log"
-	"runtime/debug"
 )
 
 func EnsureTopic(topic string, zk string, knownTopics *map[string]bool) (err error) {
-	if (*knownTopics)[topic] {
-		return nil
-	}
-	err = InitTopic(zk, topic)
-	if err != nil {
-		log.Println("ERROR:", err)
-		debug.PrintStack()
-		return err
-	}
-	(*knownTopics)[topic] = true
-	return
+	return Cluster{Zookeeper: zk}.EnsureTopic(topic, knownTopics)
 }
 
 func GetBroker(zk string) (brokers []string, err error) {
@@ -82,6 +71,27 @@ func InitTopic(zkUrl string, topics ...string) (err error) {
 }
 
 func InitTopicWithConfig(zkUrl string, numPartitions int, replicationFactor int, topics ...string) (err error) {
+	return initTopicWithDialer(nil, zkUrl, numPartitions, replicationFactor, topics...)
+}
+
+//a nil dialer uses kafka.DefaultDialer
+func initTopicWithDialer(dialer *kafka.Dialer, zkUrl string, numPartitions int, replicationFactor int, topics ...string) (err error) {
+	configs := []kafka.TopicConfig{}
+	for _, topic := range topics {
+		configs = append(configs, kafka.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     numPartitions,
+			ReplicationFactor: replicationFactor,
+		})
+	}
+	return createTopicsWithDialer(dialer, zkUrl, configs...)
+}
+
+//existing topics are ignored; a nil dialer uses kafka.DefaultDialer
+func createTopicsWithDialer(dialer *kafka.Dialer, zkUrl string, topics ...kafka.TopicConfig) (err error) {
+	if dialer == nil {
+		dialer = kafka.DefaultDialer
+	}
 	controller, err := GetKafkaController(zkUrl)
 	if err != nil {
 		log.Println("ERROR: unable to find controller", err)
@@ -91,21 +101,25 @@ func InitTopicWithConfig(zkUrl string, numPartitions int, replicationFactor int,
 		log.Println("ERROR: unable to find controller")
 		return errors.New("unable to find controller")
 	}
-	initConn, err := kafka.Dial("tcp", controller)
+	initConn, err := dialer.Dial("tcp", controller)
 	if err != nil {
 		log.Println("ERROR: while init topic connection ", err)
 		return err
 	}
 	defer initConn.Close()
 	for _, topic := range topics {
-		err = initConn.CreateTopics(kafka.TopicConfig{
-			Topic:             topic,
-			NumPartitions:     numPartitions,
-			ReplicationFactor: replicationFactor,
-		})
+		err = initConn.CreateTopics(topic)
 		if err != nil {
 			return
 		}
 	}
 	return nil
 }
+
+func newKazoo(zkUrl string) (*kazoo.Kazoo, error) {
+	zookeeper := kazoo.NewConfig()
+	zookeeper.Logger = log.New(ioutil.Discard, "", 0)
+	zk, chroot := kazoo.ParseConnectionString(zkUrl)
+	zookeeper.Chroot = chroot
+	return kazoo.NewKazoo(zk, zookeeper)
+}
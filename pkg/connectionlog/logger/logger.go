@@ -18,70 +18,100 @@ package logger
 
 import (
 	"connection-check/pkg/connectionlog/logger/kafka"
-	"encoding/json"
 	"time"
 )
 
 func New(zk string, sync bool, idempotent bool, deviceLogTopic string, hubLogTopic string) (logger *Logger, err error) {
-	producer, err := kafka.PrepareProducer(zk, sync, idempotent)
+	config := kafka.DefaultProducerConfig
+	config.Async = !sync
+	config.Idempotent = idempotent
+	return NewWithConfig(kafka.Cluster{Zookeeper: zk}, config, deviceLogTopic, hubLogTopic)
+}
+
+func NewWithConfig(cluster kafka.Cluster, config kafka.ProducerConfig, deviceLogTopic string, hubLogTopic string) (logger *Logger, err error) {
+	producer, err := kafka.PrepareProducerWithConfig(cluster, config)
 	if err != nil {
 		return logger, err
 	}
-	return &Logger{producer: producer, deviceLogTopic: deviceLogTopic, hubLogTopic: hubLogTopic}, nil
+	return NewWithProducer(producer, deviceLogTopic, hubLogTopic), nil
+}
+
+func NewWithProducer(producer kafka.ProducerInterface, deviceLogTopic string, hubLogTopic string) *Logger {
+	return &Logger{producer: producer, deviceLogTopic: deviceLogTopic, hubLogTopic: hubLogTopic}
 }
 
 type Logger struct {
 	producer       kafka.ProducerInterface
 	deviceLogTopic string
 	hubLogTopic    string
+	LegacyFormat   bool    //if true, events only contain id, connected, time and the optional session
+	Encoder        Encoder //if nil, events are encoded as legacy json
 }
 
-func (this *Logger) LogDeviceDisconnect(id string) error {
-	b, err := json.Marshal(DeviceLog{
-		Connected: false,
-		Id:        id,
-		Time:      time.Now(),
-	})
-	if err != nil {
-		return err
-	}
-	return this.producer.ProduceWithKey(this.deviceLogTopic, string(b), id)
+func (this *Logger) LogDeviceDisconnect(id string, info EventInfo) error {
+	return this.logDevice(id, false, info)
 }
 
-func (this *Logger) LogDeviceConnect(id string) error {
-	b, err := json.Marshal(DeviceLog{
-		Connected: true,
-		Id:        id,
-		Time:      time.Now(),
-	})
-	if err != nil {
-		return err
-	}
-	return this.producer.ProduceWithKey(this.deviceLogTopic, string(b), id)
+func (this *Logger) LogDeviceConnect(id string, info EventInfo) error {
+	return this.logDevice(id, true, info)
+}
+
+func (this *Logger) LogHubConnect(id string, info EventInfo) error {
+	return this.logHub(id, true, info)
 }
 
-func (this *Logger) LogHubConnect(id string) error {
-	b, err := json.Marshal(HubLog{
-		Connected: true,
-		Id:        id,
-		Time:      time.Now(),
+func (this *Logger) LogHubDisconnect(id string, info EventInfo) error {
+	return this.logHub(id, false, info)
+}
+
+func (this *Logger) logDevice(id string, connected bool, info EventInfo) error {
+	return this.log(this.deviceLogTopic, CloudEventTypeDevice, id, connected, info)
+}
+
+func (this *Logger) logHub(id string, connected bool, info EventInfo) error {
+	return this.log(this.hubLogTopic, CloudEventTypeHub, id, connected, info)
+}
+
+//headers are only sent if the producer implements kafka.HeaderProducer
+func (this *Logger) log(topic string, eventType string, id string, connected bool, info EventInfo) error {
+	encoder := this.Encoder
+	if encoder == nil {
+		encoder = JsonEncoder{}
+	}
+	message, headers, err := encoder.Encode(Event{
+		Type:          eventType,
+		Connected:     connected,
+		Id:            id,
+		Time:          time.Now(),
+		EventMetadata: this.metadata(info),
 	})
 	if err != nil {
 		return err
 	}
-	return this.producer.ProduceWithKey(this.hubLogTopic, string(b), id)
+	if headerProducer, ok := this.producer.(kafka.HeaderProducer); ok && len(headers) > 0 {
+		return headerProducer.ProduceMessageWithHeaders(topic, string(message), id, headers, info.OnDelivery)
+	}
+	return this.producer.ProduceMessage(topic, string(message), id, info.OnDelivery)
 }
 
-func (this *Logger) LogHubDisconnect(id string) error {
-	b, err := json.Marshal(HubLog{
-		Connected: false,
-		Id:        id,
-		Time:      time.Now(),
-	})
-	if err != nil {
-		return err
+func (this *Logger) metadata(info EventInfo) EventMetadata {
+	if this.LegacyFormat {
+		return EventMetadata{Session: info.Session}
 	}
-	return this.producer.ProduceWithKey(this.hubLogTopic, string(b), id)
+	return EventMetadata{
+		Version: EventVersion,
+		Source:  EventSource,
+		Reason:  info.Reason,
+		RunId:   info.RunId,
+		Topics:  info.Topics,
+		Session: info.Session,
+		Initial: info.Initial,
+	}
+}
+
+//waits until all events are delivered
+func (this *Logger) Flush() error {
+	return this.producer.Flush()
 }
 
 func (this *Logger) Close() {
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"testing"
+)
+
+type producerMock struct {
+	Messages []string
+	Keys     []string
+	Topics   []string
+}
+
+func (this *producerMock) Produce(topic string, message string) (err error) {
+	return this.ProduceWithKey(topic, message, "")
+}
+
+func (this *producerMock) ProduceWithKey(topic string, message string, key string) (err error) {
+	this.Topics = append(this.Topics, topic)
+	this.Messages = append(this.Messages, message)
+	this.Keys = append(this.Keys, key)
+	return nil
+}
+
+func (this *producerMock) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	err = this.ProduceWithKey(topic, message, key)
+	if callback != nil {
+		callback(err)
+	}
+	return err
+}
+
+func (this *producerMock) Flush() error {
+	return nil
+}
+
+func (this *producerMock) Log(logger *log.Logger) {}
+
+func (this *producerMock) Close() {}
+
+func TestEventMetadata(t *testing.T) {
+	producer := &producerMock{}
+	logger := &Logger{producer: producer, deviceLogTopic: "device_log", hubLogTopic: "gateway_log"}
+
+	err := logger.LogDeviceDisconnect("d1", EventInfo{Reason: ReasonNoSubscription, RunId: "run1", Topics: []string{"command/d1/+"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = logger.LogHubConnect("h1", EventInfo{Reason: ReasonClientFound, RunId: "run1", Session: &Session{ClientId: "h1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.LegacyFormat = true
+	err = logger.LogDeviceConnect("d2", EventInfo{Reason: ReasonSubscriptionFound, RunId: "run1", Topics: []string{"command/d2/+"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(producer.Topics, []string{"device_log", "gateway_log", "device_log"}) || !reflect.DeepEqual(producer.Keys, []string{"d1", "h1", "d2"}) {
+		t.Error(producer.Topics, producer.Keys)
+	}
+
+	t.Run("device disconnect", testEventFields(producer.Messages[0], map[string]interface{}{
+		"id":        "d1",
+		"connected": false,
+		"version":   float64(EventVersion),
+		"source":    EventSource,
+		"reason":    ReasonNoSubscription,
+		"run_id":    "run1",
+		"topics":    []interface{}{"command/d1/+"},
+	}))
+	t.Run("hub connect", testEventFields(producer.Messages[1], map[string]interface{}{
+		"id":        "h1",
+		"connected": true,
+		"version":   float64(EventVersion),
+		"source":    EventSource,
+		"reason":    ReasonClientFound,
+		"run_id":    "run1",
+		"session":   map[string]interface{}{"client_id": "h1"},
+	}))
+	t.Run("legacy device connect", testEventFields(producer.Messages[2], map[string]interface{}{
+		"id":        "d2",
+		"connected": true,
+	}))
+}
+
+//compares all fields except time
+func testEventFields(message string, expected map[string]interface{}) func(t *testing.T) {
+	return func(t *testing.T) {
+		event := map[string]interface{}{}
+		err := json.Unmarshal([]byte(message), &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := event["time"]; !ok {
+			t.Error("missing time", message)
+		}
+		delete(event, "time")
+		if !reflect.DeepEqual(event, expected) {
+			t.Error(message)
+		}
+	}
+}
@@ -16,16 +16,49 @@
 
 package logger
 
-import "time"
+//current version of the event schema; events without version are legacy events with id, connected and time
+const EventVersion = 2
 
-type HubLog struct {
-	Id        string    `json:"id"`
-	Connected bool      `json:"connected"`
-	Time      time.Time `json:"time"`
+const EventSource = "connection-check"
+
+//reasons of connection events
+const (
+	ReasonSubscriptionFound = "subscription_found"    //a subscription of one of the device topics is online
+	ReasonNoSubscription    = "no_subscription_found" //no subscription of the device topics is online
+	ReasonProbeFailed       = "probe_failed"          //the device has an online subscription but did not respond to the probe
+	ReasonClientFound       = "client_found"          //the client of the hub is online
+	ReasonClientGone        = "client_gone"           //the client of the hub is not online
+)
+
+//all fields are omitted in the legacy format, except the optional session
+type EventMetadata struct {
+	Version int      `json:"version,omitempty"`
+	Source  string   `json:"source,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	RunId   string   `json:"run_id,omitempty"`
+	Topics  []string `json:"topics,omitempty"`
+	Session *Session `json:"session,omitempty"`
+	Initial bool     `json:"initial,omitempty"`
+}
+
+//context of a connection event
+type EventInfo struct {
+	Reason  string
+	RunId   string
+	Topics  []string //checked topics of a device
+	Session *Session //optional; session which caused a connect event
+	Initial bool     //true if the connection-log has no state of the device or hub yet
+
+	OnDelivery func(err error) //optional; called with the delivery result of the event
 }
 
-type DeviceLog struct {
-	Id        string    `json:"id"`
-	Connected bool      `json:"connected"`
-	Time      time.Time `json:"time"`
+//mqtt session which caused a connect event
+type Session struct {
+	ClientId        string `json:"client_id,omitempty"`
+	User            string `json:"user,omitempty"`
+	PeerHost        string `json:"peer_host,omitempty"`
+	PeerPort        int    `json:"peer_port,omitempty"`
+	ProtocolVersion int    `json:"protocol_version,omitempty"`
+	Mountpoint      string `json:"mountpoint,omitempty"`
+	Topic           string `json:"topic,omitempty"`
 }
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"connection-check/pkg/connectionlog/logger/connectionlogpb"
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+//connectionlogpb is generated from schemas/connection_log.proto
+//go:generate protoc --proto_path=../../../schemas --go_out=connectionlogpb --go_opt=paths=source_relative connection_log.proto
+
+//fields with default values are omitted like in proto3
+func marshalProtobuf(event Event) (result []byte, err error) {
+	message := &connectionlogpb.ConnectionLog{
+		Id:        event.Id,
+		Connected: event.Connected,
+		Version:   int32(event.Version),
+		Source:    event.Source,
+		Reason:    event.Reason,
+		RunId:     event.RunId,
+		Topics:    event.Topics,
+		Initial:   event.Initial,
+	}
+	if !event.Time.IsZero() {
+		message.Time = timestamppb.New(event.Time)
+	}
+	if event.Session != nil {
+		message.Session = &connectionlogpb.Session{
+			ClientId:        event.Session.ClientId,
+			User:            event.Session.User,
+			PeerHost:        event.Session.PeerHost,
+			PeerPort:        int32(event.Session.PeerPort),
+			ProtocolVersion: int32(event.Session.ProtocolVersion),
+			Mountpoint:      event.Session.Mountpoint,
+			Topic:           event.Session.Topic,
+		}
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
+
+func unmarshalProtobuf(buf []byte) (result Event, err error) {
+	message := &connectionlogpb.ConnectionLog{}
+	err = proto.Unmarshal(buf, message)
+	if err != nil {
+		return result, err
+	}
+	result.Id = message.Id
+	result.Connected = message.Connected
+	if message.Time != nil {
+		result.Time = time.Unix(message.Time.Seconds, int64(message.Time.Nanos))
+	}
+	result.Version = int(message.Version)
+	result.Source = message.Source
+	result.Reason = message.Reason
+	result.RunId = message.RunId
+	result.Topics = message.Topics
+	if message.Session != nil {
+		result.Session = &Session{
+			ClientId:        message.Session.ClientId,
+			User:            message.Session.User,
+			PeerHost:        message.Session.PeerHost,
+			PeerPort:        int(message.Session.PeerPort),
+			ProtocolVersion: int(message.Session.ProtocolVersion),
+			Mountpoint:      message.Session.Mountpoint,
+			Topic:           message.Session.Topic,
+		}
+	}
+	result.Initial = message.Initial
+	if result.Id == "" {
+		err = errors.New("missing id in protobuf event")
+	}
+	return result, err
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"connection-check/pkg/connectionlog/logger/connectionlogpb"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"testing"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	event := testEvent()
+	event.Type = ""
+	event.Session.Mountpoint = "tenant"
+	event.Version = -1
+	encoded, err := marshalProtobuf(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := &connectionlogpb.ConnectionLog{}
+	err = proto.Unmarshal(encoded, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.GetId() != event.Id || message.GetRunId() != event.RunId || message.GetSession().GetMountpoint() != "tenant" || !message.GetTime().AsTime().Equal(event.Time) {
+		t.Error(message)
+	}
+
+	decoded, err := unmarshalProtobuf(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded.Time = decoded.Time.UTC()
+	if !reflect.DeepEqual(decoded, event) {
+		t.Error(decoded, event)
+	}
+}
@@ -0,0 +1,377 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrFull = errors.New("outbox is full")
+
+type Config struct {
+	Dir           string
+	SegmentSize   int64         //size after which a new segment file is started
+	MaxBytes      int64         //max size of undelivered events; further events are rejected with ErrFull
+	MaxAge        time.Duration //undelivered events older than MaxAge are dropped; 0 = unlimited
+	RetryInterval time.Duration //wait time between replays while kafka is unavailable
+}
+
+var DefaultConfig = Config{
+	SegmentSize:   4 * 1024 * 1024,
+	MaxBytes:      100 * 1024 * 1024,
+	MaxAge:        24 * time.Hour,
+	RetryInterval: 10 * time.Second,
+}
+
+//durable outbox in front of a kafka producer
+//every event is appended to a segment file before it is produced; undelivered events are replayed in order
+//implements kafka.ProducerInterface
+type Outbox struct {
+	config        Config
+	producer      kafka.ProducerInterface
+	store         *store
+	mux           sync.Mutex
+	pending       []*record //undelivered events in order
+	pendingBytes  int64
+	callbacks     map[uint64]func(err error)
+	dropped       int
+	full          bool //true if the last event was rejected
+	signal        chan bool
+	flushRequests chan chan error
+	stop          chan bool
+	done          sync.WaitGroup
+}
+
+func NewFromConfig(config configuration.Config, producer kafka.ProducerInterface) (result *Outbox, err error) {
+	outboxConfig := DefaultConfig
+	outboxConfig.Dir = config.OutboxDir
+	if config.OutboxMaxBytes > 0 {
+		outboxConfig.MaxBytes = config.OutboxMaxBytes
+	}
+	durations := []struct {
+		value  string
+		target *time.Duration
+	}{
+		{config.OutboxMaxAge, &outboxConfig.MaxAge},
+		{config.OutboxRetryInterval, &outboxConfig.RetryInterval},
+	}
+	for _, duration := range durations {
+		if duration.value != "" && duration.value != "-" {
+			*duration.target, err = time.ParseDuration(duration.value)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	return New(outboxConfig, producer)
+}
+
+//loads undelivered events of the directory and starts the replay
+func New(config Config, producer kafka.ProducerInterface) (result *Outbox, err error) {
+	if config.Dir == "" {
+		return result, errors.New("missing outbox dir")
+	}
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = DefaultConfig.SegmentSize
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultConfig.RetryInterval
+	}
+	result = &Outbox{
+		config:        config,
+		producer:      producer,
+		callbacks:     map[uint64]func(err error){},
+		signal:        make(chan bool, 1),
+		flushRequests: make(chan chan error),
+		stop:          make(chan bool),
+	}
+	result.store, result.pending, err = openStore(config.Dir, config.SegmentSize)
+	if err != nil {
+		return result, err
+	}
+	for _, r := range result.pending {
+		result.pendingBytes += r.size()
+	}
+	if len(result.pending) > 0 {
+		log.Println("WARNING: outbox contains undelivered events; replay", len(result.pending))
+	}
+	result.done.Add(1)
+	go result.replayLoop()
+	result.notify()
+	return result, nil
+}
+
+func (this *Outbox) Produce(topic string, message string) (err error) {
+	return this.ProduceMessage(topic, message, "", nil)
+}
+
+func (this *Outbox) ProduceWithKey(topic string, message string, key string) (err error) {
+	return this.ProduceMessage(topic, message, key, nil)
+}
+
+//stores the event and returns; the callback is called on delivery, which may happen after a kafka outage
+func (this *Outbox) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	return this.ProduceMessageWithHeaders(topic, message, key, nil, callback)
+}
+
+//headers are stored with the event; they are dropped on replay if the producer does not implement kafka.HeaderProducer
+func (this *Outbox) ProduceMessageWithHeaders(topic string, message string, key string, headers []kafka.Header, callback func(err error)) (err error) {
+	this.mux.Lock()
+	r := &record{Time: time.Now(), Topic: topic, Key: key, Message: message, Headers: headers}
+	if this.config.MaxBytes > 0 && this.pendingBytes+r.size() > this.config.MaxBytes {
+		this.full = true
+		this.mux.Unlock()
+		log.Println("ERROR: outbox reached max bytes; event is rejected", topic, key)
+		return ErrFull
+	}
+	err = this.store.append(r)
+	if err != nil {
+		this.mux.Unlock()
+		log.Println("ERROR: unable to write event to outbox", err)
+		return err
+	}
+	this.full = false
+	this.pending = append(this.pending, r)
+	this.pendingBytes += r.size()
+	if callback != nil {
+		this.callbacks[r.Seq] = callback
+	}
+	this.mux.Unlock()
+	this.notify()
+	return nil
+}
+
+//replays pending events once and waits for their delivery
+//returns the first produce or delivery error of the replay; events that could not be delivered stay in the outbox
+func (this *Outbox) Flush() error {
+	done := make(chan error, 1)
+	select {
+	case this.flushRequests <- done:
+		return <-done
+	case <-this.stop:
+		return nil
+	}
+}
+
+func (this *Outbox) Log(logger *log.Logger) {
+	this.producer.Log(logger)
+}
+
+func (this *Outbox) Close() {
+	err := this.Flush()
+	if err != nil {
+		log.Println("WARNING: outbox contains undelivered events on close", err)
+	}
+	close(this.stop)
+	this.done.Wait()
+	this.mux.Lock()
+	err = this.store.close()
+	this.mux.Unlock()
+	if err != nil {
+		log.Println("ERROR: unable to close outbox", err)
+	}
+	this.producer.Close()
+}
+
+//count of undelivered events
+func (this *Outbox) Depth() int {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	return len(this.pending)
+}
+
+//not ok while new events are rejected; a replay of the pending events makes room again
+func (this *Outbox) Check() (ok bool, info interface{}) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	result := map[string]interface{}{
+		"depth":   len(this.pending),
+		"bytes":   this.pendingBytes,
+		"dropped": this.dropped,
+	}
+	if len(this.pending) > 0 {
+		result["oldest"] = this.pending[0].Time
+	}
+	return !this.full, result
+}
+
+func (this *Outbox) notify() {
+	select {
+	case this.signal <- true:
+	default:
+	}
+}
+
+func (this *Outbox) replayLoop() {
+	defer this.done.Done()
+	ticker := time.NewTicker(this.config.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.stop:
+			return
+		case done := <-this.flushRequests:
+			done <- this.replay()
+		case <-this.signal:
+			this.replay()
+		case <-ticker.C:
+			this.replay()
+		}
+	}
+}
+
+//produces all pending events in order; stops at the first produce or delivery error
+//events are only acknowledged if all preceding events of the replay are delivered,
+//so events after a failed event are replayed again after it and the order of the events is kept
+//returns the first produce or delivery error
+func (this *Outbox) replay() (err error) {
+	records := this.dropExpired()
+	if len(records) == 0 {
+		return nil
+	}
+	resultMux := sync.Mutex{}
+	delivered := make([]bool, len(records))
+	failedAt := len(records) //index of the first failed event
+	next := 0                //index of the first not acknowledged event
+	onResult := func(index int, e error) {
+		resultMux.Lock()
+		defer resultMux.Unlock()
+		if e != nil {
+			if index < failedAt {
+				failedAt = index
+				err = e
+			}
+			return
+		}
+		delivered[index] = true
+		for next < failedAt && delivered[next] {
+			this.ack(records[next].Seq)
+			next++
+		}
+	}
+	failed := func() bool {
+		resultMux.Lock()
+		defer resultMux.Unlock()
+		return failedAt < len(records)
+	}
+	produceFailed := false
+	for index, r := range records {
+		if failed() {
+			break
+		}
+		index := index
+		callback := func(err error) {
+			onResult(index, err)
+		}
+		var produceErr error
+		if headerProducer, ok := this.producer.(kafka.HeaderProducer); ok && len(r.Headers) > 0 {
+			produceErr = headerProducer.ProduceMessageWithHeaders(r.Topic, r.Message, r.Key, r.Headers, callback)
+		} else {
+			produceErr = this.producer.ProduceMessage(r.Topic, r.Message, r.Key, callback)
+		}
+		if produceErr != nil {
+			log.Println("WARNING: unable to produce outbox event; retry in", this.config.RetryInterval, produceErr)
+			onResult(index, produceErr)
+			produceFailed = true
+			break
+		}
+	}
+	flushErr := this.producer.Flush()
+	resultMux.Lock()
+	defer resultMux.Unlock()
+	if flushErr != nil {
+		log.Println("WARNING: unable to deliver outbox events; retry in", this.config.RetryInterval, flushErr)
+		if err == nil {
+			err = flushErr
+		}
+	} else if err != nil && !produceFailed {
+		log.Println("WARNING: unable to deliver outbox event; replay it and the following events in", this.config.RetryInterval, err)
+	}
+	return err
+}
+
+//removes expired events and returns the remaining undelivered events
+func (this *Outbox) dropExpired() (result []*record) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if this.config.MaxAge > 0 {
+		for _, r := range this.pending {
+			if !r.acked && time.Since(r.Time) > this.config.MaxAge {
+				r.acked = true
+				r.expired = true
+			}
+		}
+		this.advance()
+	}
+	for _, r := range this.pending {
+		if !r.acked {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func (this *Outbox) ack(seq uint64) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for _, r := range this.pending {
+		if r.Seq == seq {
+			r.acked = true
+			break
+		}
+	}
+	this.advance()
+}
+
+//removes the acknowledged events at the start of pending and persists the new position
+//must be called with locked mux
+func (this *Outbox) advance() {
+	count := 0
+	for count < len(this.pending) && this.pending[count].acked {
+		r := this.pending[count]
+		this.pendingBytes -= r.size()
+		if r.expired {
+			this.dropped++
+			log.Println("WARNING: drop outbox event older than "+this.config.MaxAge.String(), r.Topic, r.Key)
+		}
+		if callback, ok := this.callbacks[r.Seq]; ok {
+			delete(this.callbacks, r.Seq)
+			if r.expired {
+				callback(errors.New("outbox event expired"))
+			} else {
+				callback(nil)
+			}
+		}
+		count++
+	}
+	if count == 0 {
+		return
+	}
+	this.full = false
+	last := this.pending[count-1].Seq
+	this.pending = this.pending[count:]
+	err := this.store.commit(last)
+	if err != nil {
+		log.Println("ERROR: unable to commit outbox position "+strconv.FormatUint(last, 10), err)
+	}
+}
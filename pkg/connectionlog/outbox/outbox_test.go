@@ -0,0 +1,316 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type producerMock struct {
+	mux          sync.Mutex
+	fail         bool
+	failDelivery map[string]bool //the delivery of these messages fails after they are produced
+	Messages     []string
+	Headers      [][]kafka.Header
+}
+
+func (this *producerMock) SetFail(fail bool) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	this.fail = fail
+}
+
+func (this *producerMock) Produce(topic string, message string) (err error) {
+	return this.ProduceMessage(topic, message, "", nil)
+}
+
+func (this *producerMock) ProduceWithKey(topic string, message string, key string) (err error) {
+	return this.ProduceMessage(topic, message, key, nil)
+}
+
+func (this *producerMock) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	this.mux.Lock()
+	var deliveryErr error
+	if this.fail {
+		err = errors.New("kafka unavailable")
+		deliveryErr = err
+	} else if this.failDelivery[message] {
+		deliveryErr = errors.New("delivery failed")
+	} else {
+		this.Messages = append(this.Messages, message)
+	}
+	this.mux.Unlock()
+	if callback != nil {
+		callback(deliveryErr)
+	}
+	return err
+}
+
+func (this *producerMock) ProduceMessageWithHeaders(topic string, message string, key string, headers []kafka.Header, callback func(err error)) (err error) {
+	this.mux.Lock()
+	if !this.fail {
+		this.Headers = append(this.Headers, headers)
+	}
+	this.mux.Unlock()
+	return this.ProduceMessage(topic, message, key, callback)
+}
+
+func (this *producerMock) GetMessages() []string {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	return append([]string{}, this.Messages...)
+}
+
+func (this *producerMock) Flush() error {
+	return nil
+}
+
+func (this *producerMock) Log(logger *log.Logger) {}
+
+func (this *producerMock) Close() {}
+
+func testConfig(dir string) Config {
+	config := DefaultConfig
+	config.Dir = dir
+	config.SegmentSize = 100
+	config.RetryInterval = time.Hour
+	return config
+}
+
+func TestOutboxReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	producer := &producerMock{fail: true}
+	outbox, err := New(testConfig(dir), producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered := 0
+	callback := func(err error) {
+		if err == nil {
+			delivered++
+		}
+	}
+	for _, message := range []string{"msg1", "msg2", "msg3"} {
+		err = outbox.ProduceMessage("device_log", message, "key", callback)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = outbox.Flush()
+	if err == nil {
+		t.Error("flush should return the delivery error")
+	}
+	if outbox.Depth() != 3 || len(producer.GetMessages()) != 0 {
+		t.Error(outbox.Depth(), producer.GetMessages())
+	}
+	outbox.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) < 2 {
+		t.Error("expected rotated segments", segments)
+	}
+
+	//restart with available kafka
+	producer = &producerMock{}
+	outbox, err = New(testConfig(dir), producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = outbox.ProduceMessage("device_log", "msg4", "key", callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = outbox.Flush()
+	if err != nil {
+		t.Error(err)
+	}
+	if outbox.Depth() != 0 {
+		t.Error(outbox.Depth())
+	}
+	if !reflect.DeepEqual(producer.GetMessages(), []string{"msg1", "msg2", "msg3", "msg4"}) {
+		t.Error(producer.GetMessages())
+	}
+	if delivered != 1 {
+		t.Error("callbacks are only known for events of the current process", delivered)
+	}
+	outbox.Close()
+
+	//delivered events are not replayed again
+	producer = &producerMock{}
+	outbox, err = New(testConfig(dir), producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.Flush()
+	if outbox.Depth() != 0 || len(producer.GetMessages()) != 0 {
+		t.Error(outbox.Depth(), producer.GetMessages())
+	}
+	outbox.Close()
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) > 1 {
+		t.Error("delivered segments should be removed", segments)
+	}
+}
+
+func TestOutboxHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	headers := []kafka.Header{{Key: "ce_type", Value: "connection-check.device_log"}, {Key: "content-type", Value: "application/json"}}
+	binary := "\x0a\x02d1\x10\x01\xff"
+	producer := &producerMock{fail: true}
+	outbox, err := New(testConfig(dir), producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = outbox.ProduceMessageWithHeaders("device_log", `{"id":"d1"}`, "d1", headers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = outbox.ProduceMessage("device_log", binary, "d1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.Close()
+
+	//headers and binary messages survive the restart
+	producer = &producerMock{}
+	outbox, err = New(testConfig(dir), producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.Flush()
+	if !reflect.DeepEqual(producer.GetMessages(), []string{`{"id":"d1"}`, binary}) {
+		t.Errorf("%q", producer.GetMessages())
+	}
+	if !reflect.DeepEqual(producer.Headers, [][]kafka.Header{headers}) {
+		t.Error(producer.Headers)
+	}
+	outbox.Close()
+}
+
+func TestOutboxLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	producer := &producerMock{fail: true}
+	config := testConfig(dir)
+	config.MaxBytes = 30
+	outbox, err := New(config, producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+
+	err = outbox.ProduceWithKey("topic", "message1", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = outbox.ProduceWithKey("topic", "message2", "key")
+	if err != ErrFull {
+		t.Error(err)
+	}
+	ok, _ := outbox.Check()
+	if ok {
+		t.Error("full outbox should not be ok")
+	}
+
+	outbox.mux.Lock()
+	outbox.config.MaxAge = time.Minute
+	outbox.pending[0].Time = time.Now().Add(-time.Hour)
+	outbox.mux.Unlock()
+	outbox.Flush()
+	ok, info := outbox.Check()
+	if !ok || outbox.Depth() != 0 || info.(map[string]interface{})["dropped"] != 1 {
+		t.Error(ok, info)
+	}
+
+	producer.SetFail(false)
+	err = outbox.ProduceWithKey("topic", "message3", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.Flush()
+	if !reflect.DeepEqual(producer.GetMessages(), []string{"message3"}) {
+		t.Error(producer.GetMessages())
+	}
+}
+
+func TestOutboxReplayOrderAfterDeliveryError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	producer := &producerMock{fail: true}
+	outbox, err := New(testConfig(dir), producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+	for _, message := range []string{"connected", "disconnected", "connected again"} {
+		err = outbox.ProduceMessage("device_log", message, "d1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	producer.mux.Lock()
+	producer.fail = false
+	producer.failDelivery = map[string]bool{"disconnected": true}
+	producer.mux.Unlock()
+
+	err = outbox.Flush()
+	if err == nil {
+		t.Error("flush should return the delivery error")
+	}
+	//the event after the failed event is not acknowledged
+	if outbox.Depth() != 2 {
+		t.Error(outbox.Depth(), producer.GetMessages())
+	}
+	producer.mux.Lock()
+	producer.failDelivery = nil
+	producer.mux.Unlock()
+	err = outbox.Flush()
+	if err != nil {
+		t.Error(err)
+	}
+	messages := producer.GetMessages()
+	if outbox.Depth() != 0 || !reflect.DeepEqual(messages[len(messages)-2:], []string{"disconnected", "connected again"}) {
+		t.Error(outbox.Depth(), messages)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"bufio"
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const segmentSuffix = ".segment"
+const cursorFile = "cursor"
+
+type record struct {
+	Seq     uint64         `json:"seq"`
+	Time    time.Time      `json:"time"`
+	Topic   string         `json:"topic"`
+	Key     string         `json:"key"`
+	Message string         `json:"message"`
+	Headers []kafka.Header `json:"headers,omitempty"`
+	Binary  bool           `json:"binary,omitempty"` //true if Message is base64 encoded in the segment file (e.g. protobuf events)
+	acked   bool
+	expired bool
+}
+
+func (this *record) size() int64 {
+	result := len(this.Topic) + len(this.Key) + len(this.Message)
+	for _, header := range this.Headers {
+		result += len(header.Key) + len(header.Value)
+	}
+	return int64(result)
+}
+
+type segment struct {
+	path    string
+	lastSeq uint64
+}
+
+//append-only segment files with one json record per line and a cursor file with the seq of the last delivered record
+type store struct {
+	dir         string
+	segmentSize int64
+	nextSeq     uint64
+	committed   uint64
+	segments    []*segment
+	writer      *os.File
+	writerSize  int64
+}
+
+//returns the records after the cursor
+func openStore(dir string, segmentSize int64) (result *store, pending []*record, err error) {
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		debug.PrintStack()
+		return result, pending, err
+	}
+	result = &store{dir: dir, segmentSize: segmentSize}
+	cursor, err := ioutil.ReadFile(filepath.Join(dir, cursorFile))
+	if err != nil && !os.IsNotExist(err) {
+		debug.PrintStack()
+		return result, pending, err
+	}
+	if err == nil {
+		result.committed, err = strconv.ParseUint(strings.TrimSpace(string(cursor)), 10, 64)
+		if err != nil {
+			debug.PrintStack()
+			return result, pending, err
+		}
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		debug.PrintStack()
+		return result, pending, err
+	}
+	sort.Strings(paths)
+	result.nextSeq = result.committed + 1
+	for _, path := range paths {
+		records, err := readSegment(path)
+		if err != nil {
+			return result, pending, err
+		}
+		seg := &segment{path: path}
+		for _, r := range records {
+			if r.Seq > seg.lastSeq {
+				seg.lastSeq = r.Seq
+			}
+			if r.Seq >= result.nextSeq {
+				result.nextSeq = r.Seq + 1
+			}
+			if r.Seq > result.committed {
+				pending = append(pending, r)
+			}
+		}
+		result.segments = append(result.segments, seg)
+	}
+	result.removeDeliveredSegments()
+	return result, pending, nil
+}
+
+//an incomplete last line (e.g. after a crash while writing) is ignored
+func readSegment(path string) (result []*record, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		debug.PrintStack()
+		return result, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		r := &record{}
+		err = json.Unmarshal(scanner.Bytes(), r)
+		if err == nil && r.Binary {
+			var message []byte
+			message, err = base64.StdEncoding.DecodeString(r.Message)
+			r.Message, r.Binary = string(message), false
+		}
+		if err != nil {
+			log.Println("WARNING: skip invalid outbox record", path, err)
+			continue
+		}
+		result = append(result, r)
+	}
+	return result, scanner.Err()
+}
+
+//sets the seq of the record and writes it to the current segment
+func (this *store) append(r *record) (err error) {
+	if this.writer == nil || this.writerSize >= this.segmentSize {
+		err = this.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	r.Seq = this.nextSeq
+	stored := *r
+	//json would replace invalid utf8 sequences of binary messages
+	if !utf8.ValidString(r.Message) {
+		stored.Message = base64.StdEncoding.EncodeToString([]byte(r.Message))
+		stored.Binary = true
+	}
+	line, err := json.Marshal(stored)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	line = append(line, '\n')
+	_, err = this.writer.Write(line)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	err = this.writer.Sync()
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	this.nextSeq++
+	this.writerSize += int64(len(line))
+	this.segments[len(this.segments)-1].lastSeq = r.Seq
+	return nil
+}
+
+func (this *store) rotate() (err error) {
+	if this.writer != nil {
+		err = this.writer.Close()
+		if err != nil {
+			debug.PrintStack()
+			return err
+		}
+		this.writer = nil
+	}
+	path := filepath.Join(this.dir, fmt.Sprintf("%020d", this.nextSeq)+segmentSuffix)
+	this.writer, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	this.writerSize = 0
+	this.segments = append(this.segments, &segment{path: path, lastSeq: this.nextSeq - 1})
+	return nil
+}
+
+//persists seq as last delivered record and removes segments without undelivered records
+func (this *store) commit(seq uint64) (err error) {
+	temp := filepath.Join(this.dir, cursorFile+".tmp")
+	err = ioutil.WriteFile(temp, []byte(strconv.FormatUint(seq, 10)), 0644)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	err = os.Rename(temp, filepath.Join(this.dir, cursorFile))
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	this.committed = seq
+	this.removeDeliveredSegments()
+	return nil
+}
+
+//the segment of the writer is kept
+func (this *store) removeDeliveredSegments() {
+	remaining := []*segment{}
+	for i, seg := range this.segments {
+		isCurrent := this.writer != nil && i == len(this.segments)-1
+		if !isCurrent && seg.lastSeq <= this.committed {
+			err := os.Remove(seg.path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Println("WARNING: unable to remove delivered outbox segment", seg.path, err)
+				remaining = append(remaining, seg)
+			}
+			continue
+		}
+		remaining = append(remaining, seg)
+	}
+	this.segments = remaining
+}
+
+func (this *store) close() error {
+	if this.writer == nil {
+		return nil
+	}
+	err := this.writer.Close()
+	this.writer = nil
+	return err
+}
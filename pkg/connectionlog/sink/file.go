@@ -0,0 +1,122 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"encoding/json"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+//one line of the json-lines file
+//events that are no valid json (e.g. protobuf) are written base64 encoded as EventBase64
+type FileEntry struct {
+	Topic       string            `json:"topic"`
+	Key         string            `json:"key,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Event       json.RawMessage   `json:"event,omitempty"`
+	EventBase64 []byte            `json:"event_base64,omitempty"`
+}
+
+//appends each event as FileEntry line to a file
+//implements kafka.ProducerInterface and kafka.HeaderProducer
+type File struct {
+	file   *os.File
+	mux    sync.Mutex
+	logger *log.Logger
+}
+
+func NewFile(path string) (result *File, err error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		debug.PrintStack()
+		return result, err
+	}
+	return &File{file: file}, nil
+}
+
+func (this *File) Produce(topic string, message string) (err error) {
+	return this.ProduceMessage(topic, message, "", nil)
+}
+
+func (this *File) ProduceWithKey(topic string, message string, key string) (err error) {
+	return this.ProduceMessage(topic, message, key, nil)
+}
+
+func (this *File) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	return this.ProduceMessageWithHeaders(topic, message, key, nil, callback)
+}
+
+func (this *File) ProduceMessageWithHeaders(topic string, message string, key string, headers []kafka.Header, callback func(err error)) (err error) {
+	err = this.write(topic, message, key, headers)
+	if callback != nil {
+		callback(err)
+	}
+	return err
+}
+
+func (this *File) write(topic string, message string, key string, headers []kafka.Header) error {
+	if this.logger != nil {
+		this.logger.Println("DEBUG: write ", topic, message)
+	}
+	entry := FileEntry{Topic: topic, Key: key}
+	if json.Valid([]byte(message)) {
+		entry.Event = json.RawMessage(message)
+	} else {
+		entry.EventBase64 = []byte(message)
+	}
+	if len(headers) > 0 {
+		entry.Headers = map[string]string{}
+		for _, header := range headers {
+			entry.Headers[header.Key] = header.Value
+		}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	_, err = this.file.Write(append(line, '\n'))
+	if err != nil {
+		log.Println("ERROR: unable to write event to file", err)
+	}
+	return err
+}
+
+func (this *File) Flush() error {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	return this.file.Sync()
+}
+
+func (this *File) Log(logger *log.Logger) {
+	this.logger = logger
+}
+
+func (this *File) Close() {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	err := this.file.Close()
+	if err != nil {
+		log.Println("ERROR: unable to close event file", err)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"connection-check/pkg/tlsconfig"
+	"errors"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	uuid "github.com/satori/go.uuid"
+	"log"
+	"runtime/debug"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const DefaultMqttDeviceTopic = "status/devices/{{.Id}}"
+const DefaultMqttHubTopic = "status/hubs/{{.Id}}"
+const DefaultMqttTimeout = 10 * time.Second
+
+type MqttConfig struct {
+	BrokerUrl          string //e.g. tcp://host:1883 or ssl://host:8883
+	ClientId           string //if empty, a random client id is used
+	User               string
+	Password           string
+	Qos                byte
+	Timeout            time.Duration
+	CaFile             string //pem file with additional root certificates
+	CertFile           string //pem file with a client certificate
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+//values usable in the topic templates
+type MqttTopicData struct {
+	Id string
+}
+
+//publishes each event as retained message, so that new subscribers receive the current status
+//implements kafka.ProducerInterface; the topic is a template (see MqttTopicData) that is executed with the key as Id
+type Mqtt struct {
+	client    paho.Client
+	qos       byte
+	timeout   time.Duration
+	templates map[string]*template.Template
+	mux       sync.Mutex
+	logger    *log.Logger
+}
+
+func NewMqtt(config MqttConfig) (result *Mqtt, err error) {
+	result = &Mqtt{qos: config.Qos, timeout: config.Timeout, templates: map[string]*template.Template{}}
+	if result.timeout <= 0 {
+		result.timeout = DefaultMqttTimeout
+	}
+	if config.Qos > 2 {
+		return result, errors.New("invalid mqtt qos")
+	}
+	clientId := config.ClientId
+	if clientId == "" {
+		clientId = "connection-check-status-" + uuid.NewV4().String()
+	}
+	options := paho.NewClientOptions().
+		SetAutoReconnect(true).
+		SetCleanSession(true).
+		SetClientID(clientId).
+		SetUsername(config.User).
+		SetPassword(config.Password).
+		AddBroker(config.BrokerUrl)
+	if config.CaFile != "" || config.CertFile != "" || config.KeyFile != "" || config.InsecureSkipVerify {
+		tlsConfig, err := tlsconfig.New(config.CaFile, config.CertFile, config.KeyFile, config.InsecureSkipVerify)
+		if err != nil {
+			debug.PrintStack()
+			return result, err
+		}
+		options.SetTLSConfig(tlsConfig)
+	}
+	result.client = paho.NewClient(options)
+	err = result.wait(result.client.Connect())
+	if err != nil {
+		log.Println("ERROR: unable to connect status client to broker", err)
+		return result, err
+	}
+	return result, nil
+}
+
+func (this *Mqtt) Produce(topic string, message string) (err error) {
+	return this.ProduceMessage(topic, message, "", nil)
+}
+
+func (this *Mqtt) ProduceWithKey(topic string, message string, key string) (err error) {
+	return this.ProduceMessage(topic, message, key, nil)
+}
+
+func (this *Mqtt) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	err = this.publish(topic, message, key)
+	if callback != nil {
+		callback(err)
+	}
+	return err
+}
+
+func (this *Mqtt) publish(topicTemplate string, message string, key string) error {
+	topic, err := this.topic(topicTemplate, key)
+	if err != nil {
+		return err
+	}
+	if this.logger != nil {
+		this.logger.Println("DEBUG: publish ", topic, message)
+	}
+	err = this.wait(this.client.Publish(topic, this.qos, true, message))
+	if err != nil {
+		log.Println("ERROR: unable to publish status to mqtt", topic, err)
+	}
+	return err
+}
+
+func (this *Mqtt) topic(topicTemplate string, key string) (string, error) {
+	this.mux.Lock()
+	tmpl, ok := this.templates[topicTemplate]
+	if !ok {
+		var err error
+		tmpl, err = template.New("topic").Parse(topicTemplate)
+		if err != nil {
+			this.mux.Unlock()
+			debug.PrintStack()
+			return "", errors.New("invalid mqtt status topic template: " + err.Error())
+		}
+		this.templates[topicTemplate] = tmpl
+	}
+	this.mux.Unlock()
+	var temp bytes.Buffer
+	err := tmpl.Execute(&temp, MqttTopicData{Id: key})
+	if err != nil {
+		debug.PrintStack()
+		return "", err
+	}
+	return temp.String(), nil
+}
+
+func (this *Mqtt) wait(token paho.Token) error {
+	if !token.WaitTimeout(this.timeout) {
+		return errors.New("timeout on mqtt status broker request")
+	}
+	return token.Error()
+}
+
+//events are published on return of ProduceMessage
+func (this *Mqtt) Flush() error {
+	return nil
+}
+
+func (this *Mqtt) Log(logger *log.Logger) {
+	this.logger = logger
+}
+
+func (this *Mqtt) Close() {
+	this.client.Disconnect(250)
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"connection-check/pkg/test/docker"
+	"context"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ory/dockertest/v3"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestMqttTopic(t *testing.T) {
+	sink := &Mqtt{templates: map[string]*template.Template{}}
+	topic, err := sink.topic(DefaultMqttDeviceTopic, "urn:infai:ses:device:1")
+	if err != nil || topic != "status/devices/urn:infai:ses:device:1" {
+		t.Error(topic, err)
+	}
+	topic, err = sink.topic("tenant/{{.Id}}/status", "h1")
+	if err != nil || topic != "tenant/h1/status" {
+		t.Error(topic, err)
+	}
+	_, err = sink.topic("status/{{.Id", "h1")
+	if err == nil {
+		t.Error("expected template error")
+	}
+}
+
+func TestMqtt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer cancel()
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	brokerUrl, _, err := docker.VernemqWithManagementApi(pool, ctx, wg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sink, err := NewMqtt(MqttConfig{BrokerUrl: brokerUrl, Qos: 1, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer sink.Close()
+
+	err = sink.ProduceMessage(DefaultMqttDeviceTopic, `{"id":"d1","connected":true}`, "d1", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = sink.ProduceMessage(DefaultMqttDeviceTopic, `{"id":"d1","connected":false}`, "d1", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = sink.ProduceMessage(DefaultMqttHubTopic, `{"id":"h1","connected":true}`, "h1", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//a late subscriber receives the last status of each topic
+	mux := sync.Mutex{}
+	received := map[string]string{}
+	retained := true
+	client := paho.NewClient(paho.NewClientOptions().SetClientID("status-reader").AddBroker(brokerUrl))
+	token := client.Connect()
+	if token.Wait() && token.Error() != nil {
+		t.Error(token.Error())
+		return
+	}
+	defer client.Disconnect(250)
+	token = client.Subscribe("status/#", 1, func(client paho.Client, message paho.Message) {
+		mux.Lock()
+		defer mux.Unlock()
+		received[message.Topic()] = string(message.Payload())
+		retained = retained && message.Retained()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Error(token.Error())
+		return
+	}
+	time.Sleep(time.Second)
+
+	mux.Lock()
+	defer mux.Unlock()
+	expected := map[string]string{
+		"status/devices/d1": `{"id":"d1","connected":false}`,
+		"status/hubs/h1":    `{"id":"h1","connected":true}`,
+	}
+	if len(received) != len(expected) || !retained {
+		t.Error(received, retained)
+		return
+	}
+	for topic, payload := range expected {
+		if received[topic] != payload {
+			t.Error(topic, received[topic])
+		}
+	}
+}
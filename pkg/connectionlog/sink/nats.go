@@ -0,0 +1,81 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"github.com/nats-io/nats.go"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+const NatsFlushTimeout = 10 * time.Second
+
+//publishes each event to the nats subject given as topic
+//implements kafka.ProducerInterface; the key is not sent
+type Nats struct {
+	conn   *nats.Conn
+	logger *log.Logger
+}
+
+func NewNats(url string) (result *Nats, err error) {
+	conn, err := nats.Connect(url, nats.Name("connection-check"), nats.MaxReconnects(-1))
+	if err != nil {
+		debug.PrintStack()
+		return result, err
+	}
+	return &Nats{conn: conn}, nil
+}
+
+func (this *Nats) Produce(topic string, message string) (err error) {
+	return this.ProduceMessage(topic, message, "", nil)
+}
+
+func (this *Nats) ProduceWithKey(topic string, message string, key string) (err error) {
+	return this.ProduceMessage(topic, message, key, nil)
+}
+
+func (this *Nats) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	if this.logger != nil {
+		this.logger.Println("DEBUG: publish ", topic, message)
+	}
+	err = this.conn.Publish(topic, []byte(message))
+	if err != nil {
+		log.Println("ERROR: unable to publish event to nats", err)
+	}
+	if callback != nil {
+		callback(err)
+	}
+	return err
+}
+
+//waits until the server received all published events
+func (this *Nats) Flush() error {
+	return this.conn.FlushTimeout(NatsFlushTimeout)
+}
+
+func (this *Nats) Log(logger *log.Logger) {
+	this.logger = logger
+}
+
+func (this *Nats) Close() {
+	err := this.conn.Drain()
+	if err != nil {
+		log.Println("ERROR: unable to drain nats connection", err)
+		this.conn.Close()
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bufio"
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"connection-check/pkg/httpclient"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	mux := sync.Mutex{}
+	calls := 0
+	received := []*http.Request{}
+	bodies := []string{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		calls++
+		if calls == 1 {
+			http.Error(w, "unavailable", 503)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, string(body))
+		if r.Header.Get(WebhookTopicHeader) == "rejected" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+	}))
+	defer mock.Close()
+
+	client, err := httpclient.New(httpclient.Config{MaxRetries: 2, RetryBaseDelay: time.Millisecond, RetryMaxDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	webhook := NewWebhook(mock.URL, "secret", client)
+
+	t.Run("post is retried and signed", func(t *testing.T) {
+		var delivery error = nil
+		delivered := false
+		err := webhook.ProduceMessage("device_log", `{"id":"d1","connected":true}`, "d1", func(err error) {
+			delivered = true
+			delivery = err
+		})
+		if err != nil || !delivered || delivery != nil {
+			t.Error(err, delivered, delivery)
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		if calls != 2 || len(received) != 1 {
+			t.Error(calls, len(received))
+			return
+		}
+		if bodies[0] != `{"id":"d1","connected":true}` {
+			t.Error(bodies[0])
+		}
+		if received[0].Header.Get(WebhookTopicHeader) != "device_log" || received[0].Header.Get(WebhookKeyHeader) != "d1" {
+			t.Error(received[0].Header)
+		}
+		if received[0].Header.Get(WebhookSignatureHeader) != Signature("secret", []byte(bodies[0])) {
+			t.Error(received[0].Header.Get(WebhookSignatureHeader))
+		}
+	})
+
+	t.Run("cloudevents headers", func(t *testing.T) {
+		headers := []kafka.Header{{Key: "ce_type", Value: "connection-check.device_log"}, {Key: "content-type", Value: "application/cloudevents+json"}}
+		err := webhook.ProduceMessageWithHeaders("device_log", `{}`, "d1", headers, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		last := received[len(received)-1]
+		if last.Header.Get("ce-type") != "connection-check.device_log" || last.Header.Get("Content-Type") != "application/cloudevents+json" || last.Header.Get("ce_type") != "" {
+			t.Error(last.Header)
+		}
+	})
+
+	t.Run("signature", func(t *testing.T) {
+		//echo -n 'body' | openssl dgst -sha256 -hmac secret
+		expected := "sha256=dc46983557fea127b43af721467eb9b3fde2338fe3e14f51952aa8478c13d355"
+		if actual := Signature("secret", []byte("body")); actual != expected {
+			t.Error(actual)
+		}
+	})
+
+	t.Run("client error is returned", func(t *testing.T) {
+		var delivery error = nil
+		err := webhook.ProduceMessage("rejected", `{}`, "d1", func(err error) {
+			delivery = err
+		})
+		if err == nil || delivery == nil {
+			t.Error(err, delivery)
+		}
+	})
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+
+	file, err := NewFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = file.ProduceMessage("device_log", `{"id":"d1","connected":true}`, "d1", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = file.Produce("gateway_log", `{"id":"h1","connected":false}`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = file.Flush()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	file.Close()
+
+	//reopening appends
+	file, err = NewFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = file.ProduceWithKey("device_log", `{"id":"d2","connected":true}`, "d2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//binary event, e.g. protobuf
+	err = file.ProduceMessageWithHeaders("device_log", "\x0a\x02d3", "d3", []kafka.Header{{Key: "content-type", Value: "application/x-protobuf"}}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	file.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+	entries := []FileEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := FileEntry{}
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Error(err, scanner.Text())
+			return
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 4 {
+		t.Error(entries)
+		return
+	}
+	if entries[0].Topic != "device_log" || entries[0].Key != "d1" || string(entries[0].Event) != `{"id":"d1","connected":true}` {
+		t.Error(entries[0].Topic, entries[0].Key, string(entries[0].Event))
+	}
+	if entries[1].Topic != "gateway_log" || entries[1].Key != "" || string(entries[1].Event) != `{"id":"h1","connected":false}` {
+		t.Error(entries[1].Topic, entries[1].Key, string(entries[1].Event))
+	}
+	if entries[2].Key != "d2" {
+		t.Error(entries[2])
+	}
+	if entries[3].Event != nil || string(entries[3].EventBase64) != "\x0a\x02d3" || entries[3].Headers["content-type"] != "application/x-protobuf" {
+		t.Error(entries[3])
+	}
+}
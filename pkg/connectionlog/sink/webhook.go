@@ -0,0 +1,131 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sink
+
+import (
+	"bytes"
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"connection-check/pkg/httpclient"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+const WebhookTopicHeader = "X-Connection-Check-Topic"
+const WebhookKeyHeader = "X-Connection-Check-Key"
+const WebhookSignatureHeader = "X-Connection-Check-Signature"
+
+//posts each event as json body to Url
+//retries are handled by the httpclient; with Secret, the body is signed with HMAC-SHA256 in the WebhookSignatureHeader ("sha256=<hex>")
+//implements kafka.ProducerInterface; the topic is sent in the WebhookTopicHeader
+//implements kafka.HeaderProducer; cloudevents headers ("ce_*") are mapped to the http binding ("ce-*")
+type Webhook struct {
+	Url    string
+	Secret string
+	Client *httpclient.Client //if nil, httpclient.Default is used
+	logger *log.Logger
+}
+
+func NewWebhook(url string, secret string, client *httpclient.Client) *Webhook {
+	return &Webhook{Url: url, Secret: secret, Client: client}
+}
+
+func (this *Webhook) Produce(topic string, message string) (err error) {
+	return this.ProduceMessage(topic, message, "", nil)
+}
+
+func (this *Webhook) ProduceWithKey(topic string, message string, key string) (err error) {
+	return this.ProduceMessage(topic, message, key, nil)
+}
+
+func (this *Webhook) ProduceMessage(topic string, message string, key string, callback func(err error)) (err error) {
+	return this.ProduceMessageWithHeaders(topic, message, key, nil, callback)
+}
+
+func (this *Webhook) ProduceMessageWithHeaders(topic string, message string, key string, headers []kafka.Header, callback func(err error)) (err error) {
+	err = this.post(topic, message, key, headers)
+	if callback != nil {
+		callback(err)
+	}
+	return err
+}
+
+func (this *Webhook) post(topic string, message string, key string, headers []kafka.Header) (err error) {
+	if this.logger != nil {
+		this.logger.Println("DEBUG: post ", topic, message)
+	}
+	req, err := http.NewRequest("POST", this.Url, bytes.NewBufferString(message))
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range headers {
+		req.Header.Set(httpHeaderName(header.Key), header.Value)
+	}
+	req.Header.Set(WebhookTopicHeader, topic)
+	if key != "" {
+		req.Header.Set(WebhookKeyHeader, key)
+	}
+	if this.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, Signature(this.Secret, []byte(message)))
+	}
+	resp, err := this.Client.Do(httpclient.Idempotent(req))
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		err = errors.New(resp.Status + ":" + string(buf))
+		log.Println("ERROR: unable to post event to webhook", err)
+		return err
+	}
+	return nil
+}
+
+func httpHeaderName(kafkaHeader string) string {
+	if strings.HasPrefix(kafkaHeader, "ce_") {
+		return "ce-" + strings.TrimPrefix(kafkaHeader, "ce_")
+	}
+	return kafkaHeader
+}
+
+//returns "sha256=" + hex encoded HMAC-SHA256 of the body
+func Signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//events are delivered on return of ProduceMessage
+func (this *Webhook) Flush() error {
+	return nil
+}
+
+func (this *Webhook) Log(logger *log.Logger) {
+	this.logger = logger
+}
+
+func (this *Webhook) Close() {}
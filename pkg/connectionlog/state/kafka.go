@@ -0,0 +1,179 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+import (
+	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrNotReady = errors.New("connection-log state not caught up")
+
+const KafkaRestartDelay = 10 * time.Second
+
+//keeps the latest connection state of every device and hub by consuming the log topics from the beginning
+//answers like ConnectionLogState, but only after the topics are caught up (see Ready)
+type KafkaState struct {
+	devices   *stateTable
+	hubs      *stateTable
+	consumers []*kafka.ReplayConsumer
+	mux       sync.Mutex
+	lastErr   error
+}
+
+func NewKafkaState(cluster kafka.Cluster, deviceTopic string, hubTopic string) (result *KafkaState, err error) {
+	result = &KafkaState{devices: newStateTable(), hubs: newStateTable()}
+	err = cluster.InitTopic(deviceTopic, hubTopic)
+	if err != nil {
+		return result, err
+	}
+	for _, element := range []struct {
+		topic string
+		table *stateTable
+	}{{deviceTopic, result.devices}, {hubTopic, result.hubs}} {
+		consumer, err := kafka.NewReplayConsumer(cluster, element.topic, element.table.handle, result.handleError)
+		if err != nil {
+			result.Close()
+			return result, err
+		}
+		result.consumers = append(result.consumers, consumer)
+	}
+	return result, nil
+}
+
+//true if all messages that existed on start are consumed
+func (this *KafkaState) Ready() bool {
+	for _, consumer := range this.consumers {
+		if !consumer.CaughtUp() {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *KafkaState) GetDeviceLogStates(token string, deviceIds []string) (result map[string]LogState, err error) {
+	if !this.Ready() {
+		return result, ErrNotReady
+	}
+	return this.devices.get(deviceIds), nil
+}
+
+func (this *KafkaState) GetHubLogStates(token string, hubIds []string) (result map[string]LogState, err error) {
+	if !this.Ready() {
+		return result, ErrNotReady
+	}
+	return this.hubs.get(hubIds), nil
+}
+
+//health check; not ok until caught up
+func (this *KafkaState) Check() (ok bool, info interface{}) {
+	this.mux.Lock()
+	lastErr := ""
+	if this.lastErr != nil {
+		lastErr = this.lastErr.Error()
+	}
+	this.mux.Unlock()
+	ready := this.Ready()
+	return ready, map[string]interface{}{
+		"ready":      ready,
+		"devices":    this.devices.size(),
+		"hubs":       this.hubs.size(),
+		"last_error": lastErr,
+	}
+}
+
+//restarts the replay after a failed read or restart; the table is kept and updated by the replay
+//partitions that fail at the same time share one restart
+func (this *KafkaState) handleError(err error, consumer *kafka.ReplayConsumer) {
+	log.Println("ERROR: connection-log state consumer failed; restart in", KafkaRestartDelay, err)
+	this.mux.Lock()
+	this.lastErr = err
+	this.mux.Unlock()
+	consumer.RestartAfter(KafkaRestartDelay)
+}
+
+func (this *KafkaState) Close() {
+	for _, consumer := range this.consumers {
+		consumer.Stop()
+	}
+}
+
+type stateTable struct {
+	mux     sync.RWMutex
+	entries map[string]stateEntry
+}
+
+type stateEntry struct {
+	connected bool
+	time      time.Time
+}
+
+func newStateTable() *stateTable {
+	return &stateTable{entries: map[string]stateEntry{}}
+}
+
+//messages older than the known state of the id are ignored, so that the order of partitions does not matter
+//messages of all event encodings are accepted; a tombstone (empty message) deletes the id of its key
+func (this *stateTable) handle(topic string, key []byte, msg []byte, msgTime time.Time) error {
+	if len(msg) == 0 {
+		if len(key) == 0 {
+			return errors.New("missing key in " + topic + " tombstone")
+		}
+		this.mux.Lock()
+		defer this.mux.Unlock()
+		delete(this.entries, string(key))
+		return nil
+	}
+	message, err := logger.DecodeEvent(msg)
+	if err != nil {
+		return err
+	}
+	if message.Id == "" {
+		return errors.New("missing id in " + topic + " message")
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if known, ok := this.entries[message.Id]; ok && msgTime.Before(known.time) {
+		return nil
+	}
+	this.entries[message.Id] = stateEntry{connected: message.Connected, time: msgTime}
+	return nil
+}
+
+//ids without consumed message are Unknown; the time is the timestamp of the last message
+func (this *stateTable) get(ids []string) (result map[string]LogState) {
+	this.mux.RLock()
+	defer this.mux.RUnlock()
+	result = map[string]LogState{}
+	for _, id := range ids {
+		result[id] = LogState{State: Unknown}
+		if entry, ok := this.entries[id]; ok {
+			result[id] = LogState{State: StateOf(entry.connected), Time: entry.time}
+		}
+	}
+	return result
+}
+
+func (this *stateTable) size() int {
+	this.mux.RLock()
+	defer this.mux.RUnlock()
+	return len(this.entries)
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+import (
+	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateTable(t *testing.T) {
+	table := newStateTable()
+	start := time.Now()
+	protobuf, _, err := logger.ProtobufEncoder{}.Encode(logger.Event{Id: "d4", Connected: true, Time: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []struct {
+		msg  string
+		time time.Time
+	}{
+		{`{"id":"d1","connected":true,"time":"2020-06-25T10:00:00Z"}`, start},
+		{`{"id":"d2","connected":true}`, start},
+		{`{"id":"d1","connected":false,"reason":"no_subscription_found","version":2}`, start.Add(time.Second)},
+		{`{"id":"d2","connected":false}`, start.Add(-time.Second)}, //older event of another partition
+		{`{"specversion":"1.0","type":"connection-check.device_log","data":{"id":"d3","connected":true}}`, start},
+		{string(protobuf), start},
+	}
+	for _, message := range messages {
+		err := table.handle("device_log", nil, []byte(message.msg), message.time)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := table.handle("device_log", nil, []byte(`{"connected":true}`), start); err == nil {
+		t.Error("expected missing id error")
+	}
+	if err := table.handle("device_log", nil, []byte(`foo`), start); err == nil {
+		t.Error("expected decode error")
+	}
+	//tombstones delete the id of their key
+	if err := table.handle("device_log", []byte("d4"), nil, start.Add(time.Second)); err != nil {
+		t.Error(err)
+	}
+	if err := table.handle("device_log", nil, nil, start); err == nil {
+		t.Error("expected missing key error")
+	}
+	result := table.get([]string{"d1", "d2", "d3", "d4", "d5"})
+	expected := map[string]LogState{
+		"d1": {State: Disconnected, Time: start.Add(time.Second)},
+		"d2": {State: Connected, Time: start},
+		"d3": {State: Connected, Time: start},
+		"d4": {State: Unknown},
+		"d5": {State: Unknown},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Error(result)
+	}
+	if table.size() != 3 {
+		t.Error(table.size())
+	}
+}
+
+func TestKafkaStateNotReady(t *testing.T) {
+	state := &KafkaState{devices: newStateTable(), hubs: newStateTable(), consumers: []*kafka.ReplayConsumer{{}}}
+	_, err := state.GetDeviceLogStates("", []string{"d1"})
+	if err != ErrNotReady {
+		t.Error(err)
+	}
+	ok, _ := state.Check()
+	if ok {
+		t.Error("expected not ok")
+	}
+}
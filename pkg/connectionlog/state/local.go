@@ -0,0 +1,314 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+//kinds of the local store
+const (
+	KindDevice = "device"
+	KindHub    = "hub"
+)
+
+//the journal is rewritten if it contains more than LocalCompactFactor lines per entry (and at least LocalCompactMinLines)
+const LocalCompactFactor = 2
+const LocalCompactMinLines = 1000
+
+//file-backed store of the last known and emitted state of every device and hub
+//changes are appended as json lines to a journal file that is compacted on open and when it grows
+//the journal is not synced on every change; it is a cache that the next successful lookup of the LoggerState corrects
+//a nil *LocalStore ignores updates
+type LocalStore struct {
+	path    string
+	mux     sync.Mutex
+	states  map[string]map[string]LogState //kind -> id -> state
+	journal *os.File
+	lines   int
+}
+
+//line of the journal
+type localEntry struct {
+	Kind      string    `json:"kind"`
+	Id        string    `json:"id"`
+	Connected bool      `json:"connected"`
+	Time      time.Time `json:"time"`
+}
+
+//state of the export
+type ExportedState struct {
+	Connected bool      `json:"connected"`
+	Time      time.Time `json:"time"`
+}
+
+func NewLocalStore(path string) (result *LocalStore, err error) {
+	result = &LocalStore{path: path}
+	result.states, result.lines, err = readJournal(path)
+	if err != nil {
+		return result, err
+	}
+	err = result.compact()
+	return result, err
+}
+
+func readJournal(path string) (states map[string]map[string]LogState, lines int, err error) {
+	states = map[string]map[string]LogState{KindDevice: {}, KindHub: {}}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return states, lines, nil
+	}
+	if err != nil {
+		debug.PrintStack()
+		return states, lines, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := localEntry{}
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil || states[entry.Kind] == nil {
+			log.Println("WARNING: skip invalid local state entry", path, err)
+			continue
+		}
+		states[entry.Kind][entry.Id] = LogState{State: StateOf(entry.Connected), Time: entry.Time}
+		lines++
+	}
+	return states, lines, scanner.Err()
+}
+
+//writes the current states to a new journal, which replaces the old one
+//must be called with locked mux or before the store is used
+func (this *LocalStore) compact() (err error) {
+	if this.journal != nil {
+		this.journal.Close()
+		this.journal = nil
+	}
+	temp := this.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	lines := 0
+	for _, kind := range []string{KindDevice, KindHub} {
+		for id, state := range this.states[kind] {
+			err = encoder.Encode(localEntry{Kind: kind, Id: id, Connected: state.State == Connected, Time: state.Time})
+			if err != nil {
+				file.Close()
+				debug.PrintStack()
+				return err
+			}
+			lines++
+		}
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	err = os.Rename(temp, this.path)
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	this.lines = lines
+	this.journal, err = os.OpenFile(this.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		debug.PrintStack()
+	}
+	return err
+}
+
+//returns the stored states; ids without entry are Unknown
+func (this *LocalStore) Get(kind string, ids []string) (result map[string]LogState) {
+	result = map[string]LogState{}
+	for _, id := range ids {
+		result[id] = LogState{State: Unknown}
+	}
+	if this == nil {
+		return result
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for _, id := range ids {
+		if state, ok := this.states[kind][id]; ok {
+			result[id] = state
+		}
+	}
+	return result
+}
+
+//returns only the stored states; ids without entry are missing in the result
+func (this *LocalStore) Known(kind string, ids []string) (result map[string]LogState) {
+	result = map[string]LogState{}
+	if this == nil {
+		return result
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for _, id := range ids {
+		if state, ok := this.states[kind][id]; ok {
+			result[id] = state
+		}
+	}
+	return result
+}
+
+func (this *LocalStore) GetDeviceLogStates(token string, deviceIds []string) (result map[string]LogState, err error) {
+	return this.Get(KindDevice, deviceIds), nil
+}
+
+func (this *LocalStore) GetHubLogStates(token string, hubIds []string) (result map[string]LogState, err error) {
+	return this.Get(KindHub, hubIds), nil
+}
+
+//stores the known states that differ from the stored ones; Unknown states are ignored
+func (this *LocalStore) Update(kind string, states map[string]LogState) (err error) {
+	if this == nil {
+		return nil
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for id, state := range states {
+		if state.State == Unknown {
+			continue
+		}
+		if stored, ok := this.states[kind][id]; ok && stored.State == state.State && stored.Time.Equal(state.Time) {
+			continue
+		}
+		err = this.write(kind, id, state)
+		if err != nil {
+			return err
+		}
+	}
+	return this.compactIfNeeded()
+}
+
+//stores an emitted state with the current time
+func (this *LocalStore) Record(kind string, id string, connected bool) (err error) {
+	if this == nil {
+		return nil
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	err = this.write(kind, id, LogState{State: StateOf(connected), Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	return this.compactIfNeeded()
+}
+
+//must be called with locked mux
+func (this *LocalStore) write(kind string, id string, state LogState) error {
+	if this.states[kind] == nil {
+		return errors.New("unknown local state kind " + kind)
+	}
+	if this.journal == nil {
+		return errors.New("local state store is closed")
+	}
+	line, err := json.Marshal(localEntry{Kind: kind, Id: id, Connected: state.State == Connected, Time: state.Time})
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	_, err = this.journal.Write(append(line, '\n'))
+	if err != nil {
+		debug.PrintStack()
+		return err
+	}
+	this.states[kind][id] = state
+	this.lines++
+	return nil
+}
+
+//must be called with locked mux
+func (this *LocalStore) compactIfNeeded() error {
+	entries := len(this.states[KindDevice]) + len(this.states[KindHub])
+	if this.lines < LocalCompactMinLines || this.lines <= LocalCompactFactor*entries {
+		return nil
+	}
+	return this.compact()
+}
+
+//count of stored states per kind
+func (this *LocalStore) Size() (devices int, hubs int) {
+	if this == nil {
+		return 0, 0
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	return len(this.states[KindDevice]), len(this.states[KindHub])
+}
+
+//writes the stored states as json: {"device": {"<id>": {"connected": true, "time": "..."}}, "hub": {...}}
+func (this *LocalStore) Export(writer io.Writer) error {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	return exportStates(this.states, writer)
+}
+
+//exports the journal at path without modifying it, e.g. while the service is running
+func ExportLocalStore(path string, writer io.Writer) error {
+	states, _, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	return exportStates(states, writer)
+}
+
+func exportStates(states map[string]map[string]LogState, writer io.Writer) error {
+	result := map[string]map[string]ExportedState{}
+	for kind, entries := range states {
+		result[kind] = map[string]ExportedState{}
+		for id, state := range entries {
+			result[kind][id] = ExportedState{Connected: state.State == Connected, Time: state.Time}
+		}
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+func (this *LocalStore) Close() {
+	if this == nil {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if this.journal != nil {
+		err := this.journal.Close()
+		if err != nil {
+			log.Println("ERROR: unable to close local state store", err)
+		}
+		this.journal = nil
+	}
+}
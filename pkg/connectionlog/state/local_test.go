@@ -0,0 +1,157 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.jsonl")
+	changed := time.Date(2020, 6, 25, 10, 0, 0, 0, time.UTC)
+
+	store, err := NewLocalStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Update(KindDevice, map[string]LogState{
+		"d1": {State: Connected, Time: changed},
+		"d2": {State: Disconnected},
+		"d3": {State: Unknown},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Record(KindHub, "h1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Record(KindDevice, "d2", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Record("foo", "x", true); err == nil {
+		t.Error("expected unknown kind error")
+	}
+	store.Close()
+
+	//states survive the restart
+	store, err = NewLocalStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	devices, _ := store.GetDeviceLogStates("", []string{"d1", "d2", "d3"})
+	if !reflect.DeepEqual(devices["d1"], LogState{State: Connected, Time: changed}) || devices["d2"].State != Connected || devices["d2"].Time.IsZero() || devices["d3"].State != Unknown {
+		t.Error(devices)
+	}
+	hubs, _ := store.GetHubLogStates("", []string{"h1", "d1"})
+	if hubs["h1"].State != Connected || hubs["d1"].State != Unknown {
+		t.Error(hubs)
+	}
+	if d, h := store.Size(); d != 2 || h != 1 {
+		t.Error(d, h)
+	}
+
+	//unchanged states are not written again
+	err = store.Update(KindDevice, map[string]LogState{"d1": {State: Connected, Time: changed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.lines != 3 {
+		t.Error("journal should be compacted on open and not grow on unchanged states", store.lines)
+	}
+
+	buf := &bytes.Buffer{}
+	err = store.Export(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := map[string]map[string]ExportedState{}
+	err = json.Unmarshal(buf.Bytes(), &exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported[KindDevice]) != 2 || !exported[KindDevice]["d1"].Connected || !exported[KindDevice]["d1"].Time.Equal(changed) || !exported[KindHub]["h1"].Connected {
+		t.Error(buf.String())
+	}
+	exportedFile := &bytes.Buffer{}
+	err = ExportLocalStore(path, exportedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exportedFile.String() != buf.String() {
+		t.Error(exportedFile.String())
+	}
+}
+
+func TestLocalStoreCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.jsonl")
+	err = ioutil.WriteFile(path, []byte("invalid\n"+`{"kind":"device","id":"d1","connected":true}`+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewLocalStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 0; i < LocalCompactMinLines+10; i++ {
+		err = store.Record(KindDevice, "d2", i%2 == 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Count(string(content), "\n")
+	if lines >= LocalCompactMinLines || lines != store.lines {
+		t.Error(lines, store.lines)
+	}
+	if states := store.Get(KindDevice, []string{"d1", "d2"}); states["d1"].State != Connected || states["d2"].State != Disconnected {
+		t.Error(states)
+	}
+
+	var disabled *LocalStore
+	if err = disabled.Record(KindDevice, "d1", true); err != nil {
+		t.Error(err)
+	}
+	if states := disabled.Get(KindDevice, []string{"d1"}); states["d1"].State != Unknown {
+		t.Error(states)
+	}
+	disabled.Close()
+}
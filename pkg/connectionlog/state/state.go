@@ -18,78 +18,258 @@ package state
 
 import (
 	"bytes"
+	"compress/gzip"
+	"connection-check/pkg/httpclient"
 	"encoding/json"
 	"errors"
+	"io"
+	"log"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
 )
 
-func New(url string) *ConnectionLogState {
-	return &ConnectionLogState{url: url}
+//connection state of a device or hub as known by the connection-log
+type ConnectionState int
+
+const (
+	Unknown ConnectionState = iota //no event of the id is known
+	Disconnected
+	Connected
+)
+
+func StateOf(connected bool) ConnectionState {
+	if connected {
+		return Connected
+	}
+	return Disconnected
+}
+
+func (this ConnectionState) String() string {
+	switch this {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+//known state of a device or hub
+type LogState struct {
+	State ConnectionState
+	Time  time.Time //time of the last state change; zero if not known
+}
+
+//state of an id in the response of the connection-log service; newer versions answer with the time of the last change
+type logStateResponse struct {
+	Connected *bool     `json:"connected"`
+	Time      time.Time `json:"time"`
+}
+
+//values are a boolean, null or {"connected": <bool>, "time": <RFC 3339>}
+//ids with a null state or missing in the response are Unknown
+func toStates(ids []string, states map[string]json.RawMessage) (result map[string]LogState, err error) {
+	result = map[string]LogState{}
+	for _, id := range ids {
+		result[id] = LogState{State: Unknown}
+		value, ok := states[id]
+		if !ok {
+			continue
+		}
+		if trimmed := bytes.TrimSpace(value); len(trimmed) > 0 && trimmed[0] == '{' {
+			response := logStateResponse{}
+			err = json.Unmarshal(value, &response)
+			if err != nil {
+				return result, err
+			}
+			if response.Connected != nil {
+				result[id] = LogState{State: StateOf(*response.Connected), Time: response.Time}
+			}
+			continue
+		}
+		var connected *bool
+		err = json.Unmarshal(value, &connected)
+		if err != nil {
+			return result, err
+		}
+		if connected != nil {
+			result[id] = LogState{State: StateOf(*connected)}
+		}
+	}
+	return result, nil
+}
+
+func New(url string, client *httpclient.Client) *ConnectionLogState {
+	return &ConnectionLogState{url: url, client: client, Parallelism: DefaultParallelism}
 }
 
+const DefaultParallelism = 4
+
 type ConnectionLogState struct {
-	url string
+	url         string
+	client      *httpclient.Client
+	ChunkSize   int  //max ids per request; 0 sends all ids of a batch in one request
+	Parallelism int  //max concurrent chunk requests of one lookup
+	Gzip        bool //gzip request bodies and ask for gzip responses
 }
 
-func (this *ConnectionLogState) GetDeviceLogStates(token string, deviceIds []string) (result map[string]bool, err error) {
-	b := new(bytes.Buffer)
-	err = json.NewEncoder(b).Encode(deviceIds)
-	if err != nil {
-		return result, err
+//a lookup where some but not all chunk requests failed
+//returned together with the merged states of the successful chunks; ids of failed chunks are missing in the result
+type ChunkError struct {
+	Chunks int //count of chunks of the lookup
+	Failed []ChunkFailure
+}
+
+type ChunkFailure struct {
+	Index int
+	Ids   []string
+	Err   error
+}
+
+func (this *ChunkError) Error() string {
+	msg := strconv.Itoa(len(this.Failed)) + " of " + strconv.Itoa(this.Chunks) + " state lookup chunks failed"
+	for _, failure := range this.Failed {
+		msg = msg + "; chunk " + strconv.Itoa(failure.Index) + " (" + strconv.Itoa(len(failure.Ids)) + " ids): " + failure.Err.Error()
 	}
-	req, err := http.NewRequest("POST", this.url+"/intern/state/device/check", b)
-	if err != nil {
-		debug.PrintStack()
-		return result, err
+	return msg
+}
+
+//ids of all failed chunks
+func (this *ChunkError) Ids() (result []string) {
+	for _, failure := range this.Failed {
+		result = append(result, failure.Ids...)
 	}
-	req.Header.Set("Authorization", token)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		debug.PrintStack()
-		return result, err
+	return result
+}
+
+func (this *ConnectionLogState) GetDeviceLogStates(token string, deviceIds []string) (result map[string]LogState, err error) {
+	return this.lookup(token, "/intern/state/device/check", deviceIds)
+}
+
+func (this *ConnectionLogState) GetHubLogStates(token string, hubIds []string) (result map[string]LogState, err error) {
+	return this.lookup(token, "/intern/state/gateway/check", hubIds)
+}
+
+//requests the chunks of ids in parallel and merges the results
+//if all chunks fail, the error of the first chunk is returned; if some fail, a *ChunkError
+func (this *ConnectionLogState) lookup(token string, path string, ids []string) (result map[string]LogState, err error) {
+	chunks := chunk(ids, this.ChunkSize)
+	if len(chunks) == 1 {
+		return this.request(token, path, chunks[0])
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(resp.Body)
-		return result, errors.New(buf.String())
+	parallelism := this.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
 	}
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		debug.PrintStack()
-		return result, err
+	results := make([]map[string]LogState, len(chunks))
+	errs := make([]error, len(chunks))
+	limit := make(chan bool, parallelism)
+	wg := sync.WaitGroup{}
+	for i, ids := range chunks {
+		wg.Add(1)
+		limit <- true
+		go func(i int, ids []string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			results[i], errs[i] = this.request(token, path, ids)
+		}(i, ids)
+	}
+	wg.Wait()
+	result = map[string]LogState{}
+	chunkErr := &ChunkError{Chunks: len(chunks)}
+	for i, ids := range chunks {
+		if errs[i] != nil {
+			log.Println("ERROR: state lookup chunk", i, "of", len(chunks), "failed", path, len(ids), errs[i])
+			chunkErr.Failed = append(chunkErr.Failed, ChunkFailure{Index: i, Ids: ids, Err: errs[i]})
+			continue
+		}
+		for id, state := range results[i] {
+			result[id] = state
+		}
+	}
+	if len(chunkErr.Failed) == len(chunks) {
+		return nil, errs[0]
+	}
+	if len(chunkErr.Failed) > 0 {
+		return result, chunkErr
 	}
 	return result, nil
 }
 
-func (this *ConnectionLogState) GetHubLogStates(token string, hubIds []string) (result map[string]bool, err error) {
-	b := new(bytes.Buffer)
-	err = json.NewEncoder(b).Encode(hubIds)
+//splits ids in chunks of at most size ids; size <= 0 returns all ids as one chunk
+func chunk(ids []string, size int) (result [][]string) {
+	if size <= 0 || len(ids) <= size {
+		return [][]string{ids}
+	}
+	for len(ids) > size {
+		result = append(result, ids[:size])
+		ids = ids[size:]
+	}
+	return append(result, ids)
+}
+
+func (this *ConnectionLogState) request(token string, path string, ids []string) (result map[string]LogState, err error) {
+	body, err := this.encodeBody(ids)
 	if err != nil {
+		debug.PrintStack()
 		return result, err
 	}
-	req, err := http.NewRequest("POST", this.url+"/intern/state/gateway/check", b)
+	req, err := http.NewRequest("POST", this.url+path, bytes.NewReader(body))
 	if err != nil {
 		debug.PrintStack()
 		return result, err
 	}
 	req.Header.Set("Authorization", token)
-	resp, err := http.DefaultClient.Do(req)
+	if this.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	resp, err := this.client.Do(httpclient.Idempotent(req))
 	if err != nil {
 		debug.PrintStack()
 		return result, err
 	}
 	defer resp.Body.Close()
+	var respBody io.Reader = resp.Body
+	//the transport only decompresses transparently if it set Accept-Encoding itself
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			debug.PrintStack()
+			return result, err
+		}
+		defer reader.Close()
+		respBody = reader
+	}
 	if resp.StatusCode >= 300 {
 		buf := new(bytes.Buffer)
-		buf.ReadFrom(resp.Body)
+		buf.ReadFrom(respBody)
 		return result, errors.New(buf.String())
 	}
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	states := map[string]json.RawMessage{}
+	err = json.NewDecoder(respBody).Decode(&states)
 	if err != nil {
 		debug.PrintStack()
 		return result, err
 	}
-	return result, nil
+	return toStates(ids, states)
+}
+
+func (this *ConnectionLogState) encodeBody(ids []string) ([]byte, error) {
+	b := new(bytes.Buffer)
+	if !this.Gzip {
+		err := json.NewEncoder(b).Encode(ids)
+		return b.Bytes(), err
+	}
+	writer := gzip.NewWriter(b)
+	err := json.NewEncoder(writer).Encode(ids)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	return b.Bytes(), err
 }
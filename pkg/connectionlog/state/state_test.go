@@ -0,0 +1,172 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnectionLogState(t *testing.T) {
+	requests := []string{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.URL.Path+" "+string(body))
+		w.Write([]byte(`{"a":true,"b":false,"c":null,"e":{"connected":true,"time":"2020-06-25T10:00:00Z"},"f":{"connected":null}}`))
+	}))
+	defer mock.Close()
+
+	state := New(mock.URL, nil)
+	ids := []string{"a", "b", "c", "d", "e", "f"}
+	expected := map[string]LogState{
+		"a": {State: Connected},
+		"b": {State: Disconnected},
+		"c": {State: Unknown},
+		"d": {State: Unknown},
+		"e": {State: Connected, Time: time.Date(2020, 6, 25, 10, 0, 0, 0, time.UTC)},
+		"f": {State: Unknown},
+	}
+	devices, err := state.GetDeviceLogStates("token", ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Error(devices)
+	}
+	hubs, err := state.GetHubLogStates("token", ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hubs, expected) {
+		t.Error(hubs)
+	}
+	body := `["a","b","c","d","e","f"]` + "\n"
+	if !reflect.DeepEqual(requests, []string{"/intern/state/device/check " + body, "/intern/state/gateway/check " + body}) {
+		t.Errorf("%q", requests)
+	}
+	if Unknown.String() != "unknown" || StateOf(true).String() != "connected" {
+		t.Error(Unknown, StateOf(true))
+	}
+}
+
+func TestConnectionLogStateChunks(t *testing.T) {
+	mux := sync.Mutex{}
+	requests := [][]string{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" || r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Error(r.Header)
+		}
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ids := []string{}
+		err = json.NewDecoder(reader).Decode(&ids)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mux.Lock()
+		requests = append(requests, ids)
+		mux.Unlock()
+		if ids[0] == "fail" {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("too large"))
+			return
+		}
+		result := map[string]bool{}
+		for _, id := range ids {
+			result[id] = id != "b"
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(w)
+		json.NewEncoder(writer).Encode(result)
+		writer.Close()
+	}))
+	defer mock.Close()
+
+	state := New(mock.URL, nil)
+	state.ChunkSize = 2
+	state.Gzip = true
+
+	result, err := state.GetDeviceLogStates("token", []string{"a", "b", "c", "d", "e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]LogState{"a": {State: Connected}, "b": {State: Disconnected}, "c": {State: Connected}, "d": {State: Connected}, "e": {State: Connected}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Error(result)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i][0] < requests[j][0] })
+	if !reflect.DeepEqual(requests, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}) {
+		t.Error(requests)
+	}
+
+	t.Run("partial failure", func(t *testing.T) {
+		result, err := state.GetHubLogStates("token", []string{"a", "b", "fail", "c", "d"})
+		chunkErr, ok := err.(*ChunkError)
+		if !ok {
+			t.Fatal(err)
+		}
+		if chunkErr.Chunks != 3 || len(chunkErr.Failed) != 1 || chunkErr.Failed[0].Index != 1 || !reflect.DeepEqual(chunkErr.Ids(), []string{"fail", "c"}) {
+			t.Error(chunkErr)
+		}
+		if !strings.Contains(chunkErr.Error(), "too large") {
+			t.Error(chunkErr.Error())
+		}
+		expected := map[string]LogState{"a": {State: Connected}, "b": {State: Disconnected}, "d": {State: Connected}}
+		if !reflect.DeepEqual(result, expected) {
+			t.Error(result)
+		}
+	})
+
+	t.Run("all chunks fail", func(t *testing.T) {
+		result, err := state.GetHubLogStates("token", []string{"fail", "a", "fail", "b"})
+		if _, ok := err.(*ChunkError); ok || err == nil || err.Error() != "too large" {
+			t.Error(err)
+		}
+		if len(result) != 0 {
+			t.Error(result)
+		}
+	})
+}
+
+func TestChunk(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	if result := chunk(ids, 0); !reflect.DeepEqual(result, [][]string{ids}) {
+		t.Error(result)
+	}
+	if result := chunk(ids, 3); !reflect.DeepEqual(result, [][]string{ids}) {
+		t.Error(result)
+	}
+	if result := chunk(ids, 1); !reflect.DeepEqual(result, [][]string{{"a"}, {"b"}, {"c"}}) {
+		t.Error(result)
+	}
+	if result := chunk([]string{}, 2); !reflect.DeepEqual(result, [][]string{{}}) {
+		t.Error(result)
+	}
+}
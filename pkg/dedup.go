@@ -0,0 +1,117 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/connectionlog/state"
+	"log"
+	"sync"
+	"time"
+)
+
+//same kinds as in the local state store
+const DedupDevice = state.KindDevice
+const DedupHub = state.KindHub
+
+//remembers the last emitted state per device and hub
+//an identical transition within Window is suppressed, e.g. if the connection-log service did not yet apply the last event
+//a nil *Dedup never suppresses
+type Dedup struct {
+	Window  time.Duration
+	mux     sync.Mutex
+	entries map[string]dedupEntry
+}
+
+type dedupEntry struct {
+	connected bool
+	time      time.Time
+}
+
+func NewDedup(window time.Duration) *Dedup {
+	return &Dedup{Window: window, entries: map[string]dedupEntry{}}
+}
+
+//true if the same state was emitted for the id within the window
+func (this *Dedup) Suppress(kind string, id string, connected bool) bool {
+	if this == nil {
+		return false
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	entry, ok := this.entries[kind+":"+id]
+	return ok && entry.connected == connected && time.Since(entry.time) < this.Window
+}
+
+func (this *Dedup) Record(kind string, id string, connected bool) {
+	if this == nil {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	this.entries[kind+":"+id] = dedupEntry{connected: connected, time: time.Now()}
+}
+
+//removes expired entries
+func (this *Dedup) Prune() {
+	if this == nil {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for key, entry := range this.entries {
+		if time.Since(entry.time) >= this.Window {
+			delete(this.entries, key)
+		}
+	}
+}
+
+func (this *Dedup) Size() int {
+	if this == nil {
+		return 0
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	return len(this.entries)
+}
+
+//produces the event unless an identical transition was emitted within the dedup window
+//produce has to pass onDelivery as EventInfo.OnDelivery; the transition is recorded in Dedup and LocalState on successful delivery,
+//not when the event is queued (async producer, outbox), so that an event that fails later is emitted again by the next run
+//emitted is false for suppressed events, which are counted in the statistics
+func (this *ConnectionCheck) logTransition(kind string, id string, connected bool, statistics *Statistics, produce func(onDelivery func(err error)) error) (emitted bool, err error) {
+	if this.Dedup.Suppress(kind, id, connected) {
+		statistics.AddDeduplicated(1)
+		if this.Debug {
+			log.Println("DEBUG: suppress duplicate event", kind, id, connected)
+		}
+		return false, nil
+	}
+	countDelivery := statistics.DeliveryHandler()
+	err = produce(func(deliveryErr error) {
+		if countDelivery != nil {
+			countDelivery(deliveryErr)
+		}
+		if deliveryErr != nil {
+			return
+		}
+		this.Dedup.Record(kind, id, connected)
+		if localErr := this.LocalState.Record(kind, id, connected); localErr != nil {
+			log.Println("ERROR: unable to store emitted state in local state store", localErr)
+		}
+	})
+	return true, err
+}
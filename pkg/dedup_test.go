@@ -0,0 +1,126 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/test/mocks"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	dedup := NewDedup(50 * time.Millisecond)
+	if dedup.Suppress(DedupDevice, "d1", true) {
+		t.Error("unknown id should not be suppressed")
+	}
+	dedup.Record(DedupDevice, "d1", true)
+	if !dedup.Suppress(DedupDevice, "d1", true) {
+		t.Error("identical transition should be suppressed")
+	}
+	if dedup.Suppress(DedupDevice, "d1", false) {
+		t.Error("opposite transition should not be suppressed")
+	}
+	if dedup.Suppress(DedupHub, "d1", true) {
+		t.Error("devices and hubs should be separated")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if dedup.Suppress(DedupDevice, "d1", true) {
+		t.Error("expired transition should not be suppressed")
+	}
+	dedup.Record(DedupHub, "h1", false)
+	dedup.Prune()
+	if dedup.Size() != 1 {
+		t.Error(dedup.Size())
+	}
+
+	var disabled *Dedup
+	disabled.Record(DedupDevice, "d1", true)
+	if disabled.Suppress(DedupDevice, "d1", true) {
+		t.Error("nil dedup should not suppress")
+	}
+}
+
+func TestLogTransition(t *testing.T) {
+	eventLogger := mocks.Logger()
+	check := &ConnectionCheck{Logger: eventLogger, Dedup: NewDedup(time.Minute)}
+	statistics := &Statistics{}
+	produce := func(onDelivery func(err error)) error {
+		return check.Logger.LogDeviceConnect("d1", logger.EventInfo{OnDelivery: onDelivery})
+	}
+
+	emitted, err := check.logTransition(DedupDevice, "d1", true, statistics, produce)
+	if !emitted || err != nil {
+		t.Error(emitted, err)
+	}
+	emitted, err = check.logTransition(DedupDevice, "d1", true, statistics, produce)
+	if emitted || err != nil {
+		t.Error(emitted, err)
+	}
+	if len(eventLogger.Events) != 1 || statistics.Deduplicated != 1 {
+		t.Error(eventLogger.Events, statistics.Deduplicated)
+	}
+
+	//failed events are not recorded
+	emitted, err = check.logTransition(DedupHub, "h1", false, statistics, func(onDelivery func(err error)) error { return errors.New("unavailable") })
+	if !emitted || err == nil {
+		t.Error(emitted, err)
+	}
+	if check.Dedup.Suppress(DedupHub, "h1", false) {
+		t.Error("failed event should not be recorded")
+	}
+}
+
+func TestLogTransitionDeliveryFailsAfterEnqueue(t *testing.T) {
+	check := &ConnectionCheck{Logger: mocks.Logger(), Dedup: NewDedup(time.Minute)}
+	statistics := &Statistics{}
+	var pending func(err error)
+	//like the async producer or the outbox: the event is queued and delivered later
+	enqueue := func(onDelivery func(err error)) error {
+		pending = onDelivery
+		return nil
+	}
+
+	emitted, err := check.logTransition(DedupDevice, "d1", true, statistics, enqueue)
+	if !emitted || err != nil {
+		t.Error(emitted, err)
+	}
+	if check.Dedup.Suppress(DedupDevice, "d1", true) {
+		t.Error("queued event should not be recorded before delivery")
+	}
+	pending(errors.New("delivery failed"))
+	if check.Dedup.Suppress(DedupDevice, "d1", true) {
+		t.Error("failed delivery should not be recorded")
+	}
+	if statistics.DeliveryFailed() != 1 {
+		t.Error(statistics.DeliveryFailed())
+	}
+
+	//the next run emits the transition again
+	emitted, err = check.logTransition(DedupDevice, "d1", true, statistics, enqueue)
+	if !emitted || err != nil {
+		t.Error(emitted, err)
+	}
+	pending(nil)
+	if !check.Dedup.Suppress(DedupDevice, "d1", true) {
+		t.Error("delivered event should be recorded")
+	}
+	if statistics.Delivered() != 1 {
+		t.Error(statistics.Delivered())
+	}
+}
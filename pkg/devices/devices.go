@@ -19,14 +19,16 @@ package devices
 import (
 	"connection-check/pkg/configuration"
 	"connection-check/pkg/devices/cache"
+	"connection-check/pkg/httpclient"
 )
 
 type Devices struct {
 	config configuration.Config
 	cache  *cache.Cache
+	client *httpclient.Client
 }
 
-func New(config configuration.Config) *Devices {
+func New(config configuration.Config, client *httpclient.Client) *Devices {
 	cache := cache.New(&cache.CacheConfig{
 		L1Expiration:   config.CacheL1Expiration,
 		L2Expiration:   config.CacheL2Expiration,
@@ -35,5 +37,6 @@ func New(config configuration.Config) *Devices {
 	return &Devices{
 		config: config,
 		cache:  cache,
+		client: client,
 	}
 }
@@ -18,6 +18,7 @@ package devices
 
 import (
 	"connection-check/pkg/configuration"
+	"connection-check/pkg/httpclient"
 	"connection-check/pkg/model"
 	"encoding/json"
 	"net/http"
@@ -51,7 +52,7 @@ func TestCachedGetDeviceType(t *testing.T) {
 	defer mock.Close()
 	config.DeviceManagerUrl = mock.URL
 
-	iot := New(config)
+	iot := New(config, httpclient.Default)
 
 	t.Run("unknown device-type read 1", testGetDeviceTypeExpectError(iot, "unknown1"))
 	t.Run("first device-type read", testGetDeviceType(iot, "dt1", "dt1name"))
@@ -40,7 +40,7 @@ func (this *Devices) getDeviceByLocalId(token string, localId string) (result mo
 		return result, err
 	}
 	req.Header.Set("Authorization", token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := this.client.Do(req)
 	if err != nil {
 		debug.PrintStack()
 		return result, err
@@ -34,7 +34,7 @@ func (this *Devices) ListDevices(token string, limit int, offset int) (result []
 		return result, err
 	}
 	req.Header.Set("Authorization", token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := this.client.Do(req)
 	if err != nil {
 		debug.PrintStack()
 		return result, err
@@ -62,7 +62,7 @@ func (this *Devices) ListHubs(token string, limit int, offset int) (result []mod
 		return result, err
 	}
 	req.Header.Set("Authorization", token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := this.client.Do(req)
 	if err != nil {
 		debug.PrintStack()
 		return result, err
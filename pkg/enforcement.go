@@ -0,0 +1,306 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/model"
+	"connection-check/pkg/vernemq"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EnforcementVerne interface {
+	GetOnlineSubscriptions(mountpoint string) (result []vernemq.Subscription, truncated bool, err error)
+	DisconnectClient(mountpoint string, clientId string, cleanup bool) error
+}
+
+//disconnects online sessions that belong to no existing device or hub
+//a session belongs to a hub if its client id is a hub id and to a device if one of its subscriptions matches a topic generated for the device
+//sessions of listed devices of other protocols are recognized by the device id or local id as client id or topic level
+//sessions without subscriptions can not be assigned and are never disconnected
+//a session is only disconnected if it was orphaned in two consecutive runs, so devices created during a run are not affected
+type Enforcer struct {
+	Verne          EnforcementVerne
+	Mountpoints    []string
+	DryRun         bool             //only audit the disconnects
+	Cleanup        bool             //removes the session state on disconnect
+	Allowlist      []*regexp.Regexp //sessions with a matching client id or user are never disconnected
+	MaxDisconnects int              //if more sessions would be disconnected, the run is aborted; 0 = unlimited
+	Audit          io.Writer
+	auditMux       sync.Mutex
+	mux            sync.Mutex
+	knownClients   map[string]bool
+	knownTopics    map[string]bool
+
+	knownDevices map[string]bool //ids and local ids of all listed devices, independent of the handled protocols
+
+	ownClients map[string]bool //sessions of this service; never disconnected
+	suspects   map[string]bool //orphaned sessions of the last run
+}
+
+type EnforcementAudit struct {
+	Time       time.Time `json:"time"`
+	ClientId   string    `json:"client_id"`
+	User       string    `json:"user"`
+	Mountpoint string    `json:"mountpoint"`
+	Topics     []string  `json:"topics"`
+	DryRun     bool      `json:"dry_run"`
+	Error      string    `json:"error,omitempty"`
+}
+
+//an empty auditLog writes the audit to stdout
+//without dry run the allowlist is required, because connectors and other services hold sessions that belong to no device or hub
+func NewEnforcer(verne EnforcementVerne, mountpoints []string, dryRun bool, cleanup bool, allowlist []string, maxDisconnects int, auditLog string) (result *Enforcer, err error) {
+	result = &Enforcer{
+		Verne:          verne,
+		Mountpoints:    mountpoints,
+		DryRun:         dryRun,
+		Cleanup:        cleanup,
+		MaxDisconnects: maxDisconnects,
+		Audit:          os.Stdout,
+	}
+	for _, expr := range allowlist {
+		if expr == "" {
+			continue
+		}
+		allowed, err := regexp.Compile("^(" + expr + ")$")
+		if err != nil {
+			debug.PrintStack()
+			return result, errors.New("invalid enforcement allowlist entry " + expr + ": " + err.Error())
+		}
+		result.Allowlist = append(result.Allowlist, allowed)
+	}
+	if !dryRun && len(result.Allowlist) == 0 {
+		debug.PrintStack()
+		return result, errors.New("missing enforce_allowlist: enforcement without enforce_dry_run needs the client ids or users of connectors and other services")
+	}
+	if auditLog != "" {
+		result.Audit, err = os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			debug.PrintStack()
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+//forgets the known devices and hubs of the last run
+func (this *Enforcer) Reset() {
+	if this == nil {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	this.knownClients = map[string]bool{}
+	this.knownTopics = map[string]bool{}
+	this.knownDevices = map[string]bool{}
+}
+
+//registers a client id of this service (e.g. probe or status sink); survives Reset()
+func (this *Enforcer) AddOwnClient(clientId string) {
+	if this == nil || clientId == "" || clientId == "-" {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if this.ownClients == nil {
+		this.ownClients = map[string]bool{}
+	}
+	this.ownClients[clientId] = true
+}
+
+func (this *Enforcer) AddKnownClient(clientId string) {
+	if this == nil {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if this.knownClients == nil {
+		this.knownClients = map[string]bool{}
+	}
+	this.knownClients[clientId] = true
+}
+
+//registers a listed device before the protocol and service selection filtering,
+//so that sessions of devices that are handled by other connectors are not orphaned
+func (this *Enforcer) AddKnownDevice(device model.Device) {
+	if this == nil {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if this.knownDevices == nil {
+		this.knownDevices = map[string]bool{}
+	}
+	for _, id := range []string{device.Id, device.LocalId} {
+		if id != "" {
+			this.knownDevices[id] = true
+		}
+	}
+}
+
+func (this *Enforcer) AddKnownTopics(topics []string) {
+	if this == nil {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if this.knownTopics == nil {
+		this.knownTopics = map[string]bool{}
+	}
+	for _, topic := range topics {
+		if _, sharedTopic, isShared := vernemq.ParseSharedTopic(topic); isShared {
+			topic = sharedTopic
+		}
+		this.knownTopics[topic] = true
+	}
+}
+
+//must only be called after a complete run of devices and hubs
+//returns the count of disconnected (or in dry run: audited) sessions
+func (this *Enforcer) Run() (count int, err error) {
+	if this == nil {
+		return 0, nil
+	}
+	orphans := []EnforcementAudit{}
+	for _, mountpoint := range this.Mountpoints {
+		subscriptions, truncated, err := this.Verne.GetOnlineSubscriptions(mountpoint)
+		if err != nil {
+			return count, err
+		}
+		if truncated {
+			return count, errors.New("online subscriptions of mountpoint '" + mountpoint + "' are truncated; enforcement skipped")
+		}
+		orphans = append(orphans, this.findOrphans(subscriptions)...)
+	}
+	orphans = this.confirm(orphans)
+	if this.MaxDisconnects > 0 && len(orphans) > this.MaxDisconnects {
+		return count, errors.New(strconv.Itoa(len(orphans)) + " sessions exceed enforce_max_disconnects (" + strconv.Itoa(this.MaxDisconnects) + "); enforcement skipped")
+	}
+	for _, orphan := range orphans {
+		orphan.Time = time.Now()
+		orphan.DryRun = this.DryRun
+		if !this.DryRun {
+			err = this.Verne.DisconnectClient(orphan.Mountpoint, orphan.ClientId, this.Cleanup)
+			if err != nil {
+				orphan.Error = err.Error()
+			}
+		}
+		this.audit(orphan)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+//groups the subscriptions by session and returns sessions without known client id or topic
+func (this *Enforcer) findOrphans(subscriptions []vernemq.Subscription) (result []EnforcementAudit) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	sessions := map[string]*EnforcementAudit{}
+	known := map[string]bool{}
+	keys := []string{}
+	for _, subscription := range subscriptions {
+		key := subscription.Mountpoint + "/" + subscription.ClientId
+		session, ok := sessions[key]
+		if !ok {
+			session = &EnforcementAudit{ClientId: subscription.ClientId, User: subscription.User, Mountpoint: subscription.Mountpoint}
+			sessions[key] = session
+			keys = append(keys, key)
+		}
+		topic := subscription.Topic
+		if subscription.ShareGroup != "" {
+			topic = vernemq.SharedTopic(subscription.ShareGroup, subscription.Topic)
+		}
+		session.Topics = append(session.Topics, topic)
+		if this.knownClients[subscription.ClientId] || this.knownTopics[subscription.Topic] || this.isKnownDevice(subscription) {
+			known[key] = true
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		session := sessions[key]
+		if !known[key] && !this.ownClients[session.ClientId] && !this.isAllowed(session.ClientId, session.User) {
+			result = append(result, *session)
+		}
+	}
+	return result
+}
+
+//must be called with locked mux
+func (this *Enforcer) isKnownDevice(subscription vernemq.Subscription) bool {
+	if this.knownDevices[subscription.ClientId] {
+		return true
+	}
+	for _, level := range strings.Split(subscription.Topic, "/") {
+		if this.knownDevices[level] {
+			return true
+		}
+	}
+	return false
+}
+
+//returns the orphans of the last run that are still orphaned and remembers the current orphans for the next run
+func (this *Enforcer) confirm(orphans []EnforcementAudit) (result []EnforcementAudit) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	suspects := map[string]bool{}
+	for _, orphan := range orphans {
+		key := orphan.Mountpoint + "/" + orphan.ClientId
+		suspects[key] = true
+		if this.suspects[key] {
+			result = append(result, orphan)
+		}
+	}
+	this.suspects = suspects
+	return result
+}
+
+func (this *Enforcer) isAllowed(clientId string, user string) bool {
+	for _, allowed := range this.Allowlist {
+		if allowed.MatchString(clientId) || (user != "" && allowed.MatchString(user)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *Enforcer) audit(entry EnforcementAudit) {
+	this.auditMux.Lock()
+	defer this.auditMux.Unlock()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("ERROR: unable to marshal enforcement audit", err)
+		return
+	}
+	_, err = this.Audit.Write(append(line, '\n'))
+	if err != nil {
+		log.Println("ERROR: unable to write enforcement audit", err, string(line))
+	}
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"bytes"
+	"connection-check/pkg/model"
+	"connection-check/pkg/test/mocks"
+	"connection-check/pkg/topicgenerator/common"
+	"connection-check/pkg/vernemq"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type enforcementVerneMock struct {
+	Subscriptions map[string][]vernemq.Subscription
+	Truncated     bool
+	Disconnected  []string
+}
+
+func (this *enforcementVerneMock) GetOnlineSubscriptions(mountpoint string) (result []vernemq.Subscription, truncated bool, err error) {
+	return this.Subscriptions[mountpoint], this.Truncated, nil
+}
+
+func (this *enforcementVerneMock) DisconnectClient(mountpoint string, clientId string, cleanup bool) error {
+	this.Disconnected = append(this.Disconnected, mountpoint+"/"+clientId)
+	return nil
+}
+
+func TestEnforcer(t *testing.T) {
+	newVerne := func() *enforcementVerneMock {
+		return &enforcementVerneMock{Subscriptions: map[string][]vernemq.Subscription{
+			"tenant": {
+				{ClientId: "hub1", User: "u1", Topic: "command/unknown/+", Mountpoint: "tenant"},
+				{ClientId: "device-client", User: "u1", Topic: "command/d1/+", Mountpoint: "tenant"},
+				{ClientId: "device-client", User: "u1", Topic: "command/unknown/+", Mountpoint: "tenant"},
+				{ClientId: "shared-client", User: "u1", Topic: "command/d2/+", ShareGroup: "workers", Mountpoint: "tenant"},
+				{ClientId: "deleted", User: "u1", Topic: "command/deleted/+", Mountpoint: "tenant"},
+				{ClientId: "connector-1", User: "u1", Topic: "other/#", Mountpoint: "tenant"},
+				{ClientId: "connection-check-probe-1", User: "u1", Topic: "response/#", Mountpoint: "tenant"},
+			},
+			"tenant2": {
+				{ClientId: "service", User: "system", Topic: "foo", Mountpoint: "tenant2"},
+				{ClientId: "deleted-hub", User: "u2", Topic: "command/deleted2/+", Mountpoint: "tenant2"},
+			},
+		}}
+	}
+	prepare := func(verne *enforcementVerneMock, dryRun bool, max int) (enforcer *Enforcer, audit *bytes.Buffer) {
+		enforcer, err := NewEnforcer(verne, []string{"tenant", "tenant2"}, dryRun, false, []string{"connector-.*", "system"}, max, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		audit = &bytes.Buffer{}
+		enforcer.Audit = audit
+		enforcer.Reset()
+		enforcer.AddKnownClient("hub1")
+		enforcer.AddKnownTopics([]string{"command/d1/+"})
+		enforcer.AddKnownTopics([]string{"$share/workers/command/d2/+"})
+		enforcer.AddOwnClient("connection-check-probe-1")
+		return enforcer, audit
+	}
+	//the first run only remembers the orphans
+	firstRun := func(enforcer *Enforcer, verne *enforcementVerneMock, audit *bytes.Buffer) {
+		count, err := enforcer.Run()
+		if err != nil || count != 0 || len(verne.Disconnected) != 0 || audit.Len() != 0 {
+			t.Fatal(count, err, verne.Disconnected, audit.String())
+		}
+	}
+
+	t.Run("enforce", func(t *testing.T) {
+		verne := newVerne()
+		enforcer, audit := prepare(verne, false, 10)
+		firstRun(enforcer, verne, audit)
+		count, err := enforcer.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"tenant/deleted", "tenant2/deleted-hub"}
+		if count != 2 || !reflect.DeepEqual(verne.Disconnected, expected) {
+			t.Error(count, verne.Disconnected)
+		}
+		lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatal(audit.String())
+		}
+		entry := EnforcementAudit{}
+		err = json.Unmarshal([]byte(lines[1]), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.ClientId != "deleted-hub" || entry.User != "u2" || entry.Mountpoint != "tenant2" || entry.DryRun || !reflect.DeepEqual(entry.Topics, []string{"command/deleted2/+"}) {
+			t.Error(entry)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		verne := newVerne()
+		enforcer, audit := prepare(verne, true, 10)
+		firstRun(enforcer, verne, audit)
+		count, err := enforcer.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 || len(verne.Disconnected) != 0 || strings.Count(audit.String(), `"dry_run":true`) != 2 {
+			t.Error(count, verne.Disconnected, audit.String())
+		}
+	})
+
+	t.Run("max disconnects", func(t *testing.T) {
+		verne := newVerne()
+		enforcer, audit := prepare(verne, false, 1)
+		firstRun(enforcer, verne, audit)
+		_, err := enforcer.Run()
+		if err == nil || len(verne.Disconnected) != 0 || audit.Len() != 0 {
+			t.Error(err, verne.Disconnected, audit.String())
+		}
+	})
+
+	t.Run("new sessions", func(t *testing.T) {
+		verne := newVerne()
+		enforcer, audit := prepare(verne, false, 10)
+		firstRun(enforcer, verne, audit)
+		verne.Subscriptions["tenant"] = append(verne.Subscriptions["tenant"], vernemq.Subscription{ClientId: "created", User: "u1", Topic: "command/created/+", Mountpoint: "tenant"})
+		verne.Subscriptions["tenant2"] = verne.Subscriptions["tenant2"][:1]
+		count, err := enforcer.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 || !reflect.DeepEqual(verne.Disconnected, []string{"tenant/deleted"}) {
+			t.Error(count, verne.Disconnected)
+		}
+	})
+
+	t.Run("allowlist required", func(t *testing.T) {
+		_, err := NewEnforcer(newVerne(), []string{"tenant"}, false, false, []string{""}, 10, "")
+		if err == nil {
+			t.Error("expected error")
+		}
+		_, err = NewEnforcer(newVerne(), []string{"tenant"}, true, false, nil, 10, "")
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		verne := newVerne()
+		verne.Truncated = true
+		enforcer, _ := prepare(verne, false, 10)
+		_, err := enforcer.Run()
+		if err == nil || len(verne.Disconnected) != 0 {
+			t.Error(err, verne.Disconnected)
+		}
+	})
+
+	t.Run("nil enforcer", func(t *testing.T) {
+		var enforcer *Enforcer
+		enforcer.Reset()
+		enforcer.AddKnownClient("foo")
+		count, err := enforcer.Run()
+		if count != 0 || err != nil {
+			t.Error(count, err)
+		}
+	})
+}
+
+func TestEnforcerUnhandledProtocol(t *testing.T) {
+	devices := mocks.Devices()
+	devices.DeviceTypes = append(devices.DeviceTypes,
+		model.DeviceType{Id: "handled", Services: []model.Service{{Id: "s1", ProtocolId: "p1"}}},
+		model.DeviceType{Id: "unhandled", Services: []model.Service{{Id: "s2", ProtocolId: "other"}}},
+	)
+	devices.Devices = append(devices.Devices,
+		model.Device{Id: "d1", LocalId: "l1", DeviceTypeId: "handled"},
+		model.Device{Id: "d2", LocalId: "l2", DeviceTypeId: "unhandled"},
+		model.Device{Id: "d3", LocalId: "l3", DeviceTypeId: "unhandled"},
+	)
+	topics := func(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (topicCandidates []string, err error) {
+		if deviceType.Id != "handled" {
+			return nil, common.NoSubscriptionExpected
+		}
+		return []string{"command/" + device.Id + "/+"}, nil
+	}
+	verne := &enforcementVerneMock{Subscriptions: map[string][]vernemq.Subscription{
+		"": {
+			{ClientId: "c1", User: "u1", Topic: "command/d1/+", Mountpoint: ""},
+			{ClientId: "d2", User: "u1", Topic: "other-connector/cmd", Mountpoint: ""},
+			{ClientId: "c3", User: "u1", Topic: "other-connector/l3/cmd", Mountpoint: ""},
+			{ClientId: "deleted", User: "u1", Topic: "other-connector/deleted/cmd", Mountpoint: ""},
+		},
+	}}
+	enforcer, err := NewEnforcer(verne, []string{""}, false, false, []string{"system"}, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enforcer.Audit = &bytes.Buffer{}
+	check := &ConnectionCheck{
+		Logger:                     mocks.Logger(),
+		LoggerState:                mocks.State(),
+		Verne:                      onlineTopicsMock{},
+		Enforcer:                   enforcer,
+		Devices:                    devices,
+		TokenGen:                   mocks.TokenGen,
+		SubscriptionTopicGenerator: topics,
+		HandledProtocols:           map[string]bool{"p1": true},
+	}
+	//the second run disconnects the confirmed orphans
+	for i := 0; i < 2; i++ {
+		enforcer.Reset()
+		_, err = check.RunDeviceBatch(10, 0, &Statistics{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = enforcer.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(verne.Disconnected, []string{"/deleted"}) {
+		t.Error(verne.Disconnected)
+	}
+}
@@ -17,6 +17,7 @@
 package connectioncheck
 
 import (
+	"connection-check/pkg/health"
 	"sync"
 	"time"
 )
@@ -37,6 +38,22 @@ type HealthChecker struct {
 	deviceErrCount        int
 	mux                   sync.Mutex
 	errorLimit            int
+	checks                []namedCheck
+}
+
+type namedCheck struct {
+	name  string
+	check health.Checkable
+}
+
+//adds a check that has to be ok for the service to be healthy; its info is reported under the given name
+func (this *HealthChecker) AddCheck(name string, check health.Checkable) {
+	if this == nil {
+		return
+	}
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	this.checks = append(this.checks, namedCheck{name: name, check: check})
 }
 
 func (this *HealthChecker) Check() (ok bool, info interface{}) {
@@ -45,9 +62,16 @@ func (this *HealthChecker) Check() (ok bool, info interface{}) {
 	}
 	this.mux.Lock()
 	defer this.mux.Unlock()
-	info = map[string]interface{}{"hubErrCount": this.hubErrCount, "deviceErrCount": this.deviceErrCount, "lastIntervalStart": this.lastIntervalStart}
+	infoMap := map[string]interface{}{"hubErrCount": this.hubErrCount, "deviceErrCount": this.deviceErrCount, "lastIntervalStart": this.lastIntervalStart}
+	checksOk := true
+	for _, check := range this.checks {
+		checkOk, checkInfo := check.check.Check()
+		infoMap[check.name] = checkInfo
+		checksOk = checksOk && checkOk
+	}
+	info = infoMap
 	age := time.Since(this.lastIntervalStart)
-	if this.hubErrCount > this.errorLimit || this.deviceErrCount > this.errorLimit || (this.expectedCheckInterval > 0 && age > this.expectedCheckInterval) {
+	if !checksOk || this.hubErrCount > this.errorLimit || this.deviceErrCount > this.errorLimit || (this.expectedCheckInterval > 0 && age > this.expectedCheckInterval) {
 		return false, info
 	} else {
 		return true, info
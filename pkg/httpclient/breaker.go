@@ -0,0 +1,59 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpclient
+
+import (
+	"sync"
+	"time"
+)
+
+//opens after threshold consecutive failures; after cooldown a single trial request decides if it closes again
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+	mux       sync.Mutex
+}
+
+func (this *breaker) allow() bool {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if this.threshold <= 0 || this.failures < this.threshold {
+		return true
+	}
+	if time.Now().Before(this.openUntil) || this.trial {
+		return false
+	}
+	this.trial = true
+	return true
+}
+
+func (this *breaker) record(success bool) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	this.trial = false
+	if success {
+		this.failures = 0
+		return
+	}
+	this.failures = this.failures + 1
+	if this.threshold > 0 && this.failures >= this.threshold {
+		this.openUntil = time.Now().Add(this.cooldown)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpclient
+
+import (
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/tlsconfig"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Timeout            time.Duration //timeout of a single attempt
+	MaxRetries         int           //retries of idempotent requests after transport errors or 5xx responses
+	RetryBaseDelay     time.Duration
+	RetryMaxDelay      time.Duration
+	BreakerThreshold   int //consecutive failures of an upstream that open its circuit breaker; 0 disables the breaker
+	BreakerCooldown    time.Duration
+	CaFile             string //additional root ca (pem)
+	CertFile           string //client certificate (pem)
+	KeyFile            string //client key (pem)
+	InsecureSkipVerify bool
+	ProxyUrl           string //if empty, the proxy environment variables (HTTP_PROXY, HTTPS_PROXY, NO_PROXY) are used
+}
+
+var DefaultConfig = Config{
+	Timeout:          30 * time.Second,
+	MaxRetries:       2,
+	RetryBaseDelay:   200 * time.Millisecond,
+	RetryMaxDelay:    5 * time.Second,
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
+}
+
+//used by components without explicitly configured client
+var Default, _ = New(DefaultConfig)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type Client struct {
+	config   Config
+	http     *http.Client
+	breakers map[string]*breaker
+	mux      sync.Mutex
+}
+
+func New(config Config) (client *Client, err error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig, err = tlsconfig.New(config.CaFile, config.CertFile, config.KeyFile, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	if config.ProxyUrl != "" {
+		proxy, err := url.Parse(config.ProxyUrl)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &Client{
+		config:   config,
+		http:     &http.Client{Timeout: config.Timeout, Transport: transport},
+		breakers: map[string]*breaker{},
+	}, nil
+}
+
+//unset (0) values use DefaultConfig; negative http_max_retries or http_circuit_breaker_threshold disable retries or the circuit breaker
+func NewFromConfig(config configuration.Config) (client *Client, err error) {
+	result := DefaultConfig
+	if config.HttpMaxRetries != 0 {
+		result.MaxRetries = config.HttpMaxRetries
+	}
+	if config.HttpCircuitBreakerThreshold != 0 {
+		result.BreakerThreshold = config.HttpCircuitBreakerThreshold
+	}
+	result.CaFile = config.HttpCaFile
+	result.CertFile = config.HttpClientCertFile
+	result.KeyFile = config.HttpClientKeyFile
+	result.InsecureSkipVerify = config.HttpInsecureSkipVerify
+	result.ProxyUrl = config.HttpProxyUrl
+	durations := []struct {
+		value  string
+		target *time.Duration
+	}{
+		{config.HttpTimeout, &result.Timeout},
+		{config.HttpRetryBaseDelay, &result.RetryBaseDelay},
+		{config.HttpRetryMaxDelay, &result.RetryMaxDelay},
+		{config.HttpCircuitBreakerCooldown, &result.BreakerCooldown},
+	}
+	for _, duration := range durations {
+		if duration.value != "" && duration.value != "-" {
+			*duration.target, err = time.ParseDuration(duration.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return New(result)
+}
+
+type idempotentKey struct{}
+
+//marks a request with a non idempotent method (e.g. a POST that only reads data) as safe to retry
+func Idempotent(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), idempotentKey{}, true))
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
+
+//sends the request; idempotent requests are retried with exponential backoff
+//requests to an upstream with open circuit breaker fail with ErrCircuitOpen
+func (this *Client) Do(req *http.Request) (resp *http.Response, err error) {
+	if this == nil {
+		return Default.Do(req)
+	}
+	breaker := this.getBreaker(req.URL.Host)
+	retries := 0
+	if isIdempotent(req) {
+		retries = this.config.MaxRetries
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !breaker.allow() {
+			return nil, errors.New(ErrCircuitOpen.Error() + " for " + req.URL.Host)
+		}
+		resp, err = this.http.Do(req)
+		failed := err != nil || resp.StatusCode >= 500
+		breaker.record(!failed)
+		//a body that can not be rewound can not be retried; the caller gets the last response
+		if !failed || attempt >= retries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			log.Println("WARNING: retry request", req.Method, req.URL.Host+req.URL.Path, resp.Status)
+		} else {
+			log.Println("WARNING: retry request", req.Method, req.URL.Host+req.URL.Path, err)
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(this.backoff(attempt)):
+		}
+	}
+}
+
+//exponential backoff with jitter in [delay/2, delay]
+func (this *Client) backoff(attempt int) time.Duration {
+	delay := this.config.RetryBaseDelay
+	for i := 0; i < attempt && delay < this.config.RetryMaxDelay; i++ {
+		delay = delay * 2
+	}
+	if this.config.RetryMaxDelay > 0 && delay > this.config.RetryMaxDelay {
+		delay = this.config.RetryMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (this *Client) getBreaker(upstream string) *breaker {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	result, ok := this.breakers[upstream]
+	if !ok {
+		result = &breaker{threshold: this.config.BreakerThreshold, cooldown: this.config.BreakerCooldown}
+		this.breakers[upstream] = result
+	}
+	return result
+}
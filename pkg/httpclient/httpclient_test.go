@@ -0,0 +1,201 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpclient
+
+import (
+	"connection-check/pkg/configuration"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	mux := sync.Mutex{}
+	calls := 0
+	bodies := []string{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if calls%3 != 0 {
+			http.Error(w, "unavailable", 503)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer mock.Close()
+
+	client, err := New(Config{MaxRetries: 2, RetryBaseDelay: time.Millisecond, RetryMaxDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	t.Run("get is retried", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", mock.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 || calls != 3 {
+			t.Error(resp.StatusCode, calls)
+		}
+	})
+
+	t.Run("post is not retried", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", mock.URL, strings.NewReader("body"))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 503 || calls != 4 {
+			t.Error(resp.StatusCode, calls)
+		}
+	})
+
+	t.Run("idempotent post is retried with body", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", mock.URL, strings.NewReader("body"))
+		resp, err := client.Do(Idempotent(req))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 || calls != 6 {
+			t.Error(resp.StatusCode, calls)
+		}
+		if bodies[4] != "body" || bodies[5] != "body" {
+			t.Error(bodies)
+		}
+	})
+
+	t.Run("body without GetBody returns the last response", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", mock.URL, strings.NewReader("body"))
+		req.GetBody = nil
+		resp, err := client.Do(Idempotent(req))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 503 || strings.TrimSpace(string(body)) != "unavailable" || calls != 7 {
+			t.Error(resp.StatusCode, string(body), calls)
+		}
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	mux := sync.Mutex{}
+	calls := 0
+	healthy := false
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		calls++
+		if !healthy {
+			http.Error(w, "unavailable", 500)
+		}
+	}))
+	defer mock.Close()
+
+	client, err := New(Config{BreakerThreshold: 2, BreakerCooldown: 100 * time.Millisecond})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	get := func() error {
+		req, _ := http.NewRequest("GET", mock.URL, nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := get(); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := get(); err == nil || !strings.HasPrefix(err.Error(), ErrCircuitOpen.Error()) {
+		t.Error("expected open circuit", err)
+	}
+	if calls != 2 {
+		t.Error(calls)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	mux.Lock()
+	healthy = true
+	mux.Unlock()
+	for i := 0; i < 3; i++ {
+		if err := get(); err != nil {
+			t.Error(err)
+		}
+	}
+	if calls != 5 {
+		t.Error(calls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer mock.Close()
+
+	client, err := New(Config{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	req, _ := http.NewRequest("GET", mock.URL, nil)
+	_, err = client.Do(req)
+	if err == nil {
+		t.Error("expected timeout")
+	}
+}
+
+func TestNewFromConfigDefaults(t *testing.T) {
+	client, err := NewFromConfig(&configuration.ConfigStruct{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if client.config.MaxRetries != DefaultConfig.MaxRetries || client.config.BreakerThreshold != DefaultConfig.BreakerThreshold {
+		t.Error(client.config)
+	}
+	client, err = NewFromConfig(&configuration.ConfigStruct{HttpMaxRetries: -1, HttpCircuitBreakerThreshold: -1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if client.config.MaxRetries >= 0 || client.config.BreakerThreshold >= 0 {
+		t.Error(client.config)
+	}
+}
@@ -16,18 +16,32 @@
 
 package connectioncheck
 
-import "connection-check/pkg/model"
+import (
+	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/connectionlog/state"
+	"connection-check/pkg/model"
+	"connection-check/pkg/vernemq"
+)
 
 type Logger interface {
-	LogDeviceDisconnect(deviceId string) error
-	LogDeviceConnect(deviceId string) error
-	LogHubConnect(clientId string) error
-	LogHubDisconnect(clientId string) error
+	LogDeviceDisconnect(deviceId string, info logger.EventInfo) error
+	LogDeviceConnect(deviceId string, info logger.EventInfo) error
+	LogHubConnect(clientId string, info logger.EventInfo) error
+	LogHubDisconnect(clientId string, info logger.EventInfo) error
+	Flush() error //waits until all events are delivered
+	Close()
 }
 
+//ids that were never logged are state.Unknown; the time of the last change is optional
 type LoggerState interface {
-	GetHubLogStates(token string, hubIds []string) (result map[string]bool, err error)
-	GetDeviceLogStates(token string, deviceIds []string) (result map[string]bool, err error)
+	GetHubLogStates(token string, hubIds []string) (result map[string]state.LogState, err error)
+	GetDeviceLogStates(token string, deviceIds []string) (result map[string]state.LogState, err error)
+}
+
+//optionally implemented by a LoggerState that has to be loaded first; checks are skipped until Ready
+type ReadyLoggerState interface {
+	LoggerState
+	Ready() bool
 }
 
 type TokenGenerator interface {
@@ -41,10 +55,17 @@ type Devices interface {
 	ListDevices(token string, limit int, offset int) (result []model.Device, err error)
 }
 
+//an empty mountpoint matches sessions of all mountpoints
+//a nil result means that no matching session is online
 type Verne interface {
-	CheckOnlineSubscription(topic string) (onlineSubscriptionExists bool, err error)
-	CheckOnlineSubscriptions(topics []string) (onlineSubscriptionExists bool, err error)
-	CheckOnlineClient(clientId string) (onlineClientExists bool, err error)
+	CheckOnlineSubscription(mountpoint string, topic string) (subscription *vernemq.Subscription, err error)
+	CheckOnlineSubscriptions(mountpoint string, topics []string) (subscription *vernemq.Subscription, err error)
+	CheckOnlineClient(mountpoint string, clientId string) (client *vernemq.Client, err error)
+}
+
+//applicable is false if the device can not be probed; alive is true if the device responded
+type Prober interface {
+	Probe(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (alive bool, applicable bool, err error)
 }
 
 type TopicGenerator = func(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (topicCandidates []string, err error)
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/connectionlog/state"
+	"log"
+	"sync"
+	"time"
+)
+
+//answers from the local store while the Remote LoggerState fails; successful lookups update the local store
+//a Remote that was never ready (e.g. the kafka state on startup) is passed through (see Ready), so the check waits until it is caught up;
+//if it is not ready again after being ready (e.g. a restarted kafka state), the local store answers
+//of partially failed lookups (see state.ChunkError) only the ids of the failed chunks are answered from the local store
+//ids that are not in the local store are missing in the result (instead of Unknown), so that the check skips them until the next run
+type FallbackLoggerState struct {
+	Remote       LoggerState
+	Local        *state.LocalStore
+	mux          sync.Mutex
+	fallbacks    int
+	lastErr      error
+	lastErrTime  time.Time
+	lastRemoteOk time.Time
+
+	remoteReady bool //the Remote was ready at least once
+}
+
+//implements ReadyLoggerState; not ready until the Remote was ready once
+func (this *FallbackLoggerState) Ready() bool {
+	remote, ok := this.Remote.(ReadyLoggerState)
+	if !ok {
+		return true
+	}
+	ready := remote.Ready()
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if ready {
+		this.remoteReady = true
+	}
+	return this.remoteReady
+}
+
+func (this *FallbackLoggerState) GetHubLogStates(token string, hubIds []string) (result map[string]state.LogState, err error) {
+	result, err = this.Remote.GetHubLogStates(token, hubIds)
+	if err == state.ErrNotReady && !this.Ready() {
+		return result, err
+	}
+	return this.handle(state.KindHub, hubIds, result, err), nil
+}
+
+func (this *FallbackLoggerState) GetDeviceLogStates(token string, deviceIds []string) (result map[string]state.LogState, err error) {
+	result, err = this.Remote.GetDeviceLogStates(token, deviceIds)
+	if err == state.ErrNotReady && !this.Ready() {
+		return result, err
+	}
+	return this.handle(state.KindDevice, deviceIds, result, err), nil
+}
+
+func (this *FallbackLoggerState) handle(kind string, ids []string, remote map[string]state.LogState, remoteErr error) map[string]state.LogState {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if chunkErr, ok := remoteErr.(*state.ChunkError); ok {
+		//answer the failed chunks from the local store
+		this.fallbacks++
+		this.lastErr = chunkErr
+		this.lastErrTime = time.Now()
+		err := this.Local.Update(kind, remote)
+		if err != nil {
+			log.Println("ERROR: unable to update local state store", err)
+		}
+		for id, known := range this.Local.Known(kind, chunkErr.Ids()) {
+			remote[id] = known
+		}
+		return remote
+	}
+	if remoteErr != nil {
+		if this.lastErr == nil {
+			log.Println("WARNING: connection-log state unavailable; use local state store", remoteErr)
+		}
+		this.fallbacks++
+		this.lastErr = remoteErr
+		this.lastErrTime = time.Now()
+		return this.Local.Known(kind, ids)
+	}
+	if this.lastErr != nil {
+		log.Println("connection-log state available again")
+	}
+	this.lastErr = nil
+	this.lastRemoteOk = time.Now()
+	err := this.Local.Update(kind, remote)
+	if err != nil {
+		log.Println("ERROR: unable to update local state store", err)
+	}
+	return remote
+}
+
+//health check; the fallback keeps the check running, so it is always ok
+func (this *FallbackLoggerState) Check() (ok bool, info interface{}) {
+	devices, hubs := this.Local.Size()
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	lastErr := ""
+	if this.lastErr != nil {
+		lastErr = this.lastErr.Error()
+	}
+	return true, map[string]interface{}{
+		"fallback_active": this.lastErr != nil,
+		"fallbacks":       this.fallbacks,
+		"last_error":      lastErr,
+		"last_error_time": this.lastErrTime,
+		"last_remote_ok":  this.lastRemoteOk,
+		"devices":         devices,
+		"hubs":            hubs,
+	}
+}
+
+func (this *FallbackLoggerState) Close() {
+	if closer, ok := this.Remote.(interface{ Close() }); ok {
+		closer.Close()
+	}
+	this.Local.Close()
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/connectionlog/state"
+	"connection-check/pkg/model"
+	"connection-check/pkg/test/mocks"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type failingLoggerState struct {
+	*mocks.LoggerStateMock
+	fail        bool
+	failedChunk []string //ids of a failed chunk of a partially failed lookup
+}
+
+func (this *failingLoggerState) GetDeviceLogStates(token string, deviceIds []string) (result map[string]state.LogState, err error) {
+	if this.fail {
+		return result, errors.New("connection-log unavailable")
+	}
+	result, err = this.LoggerStateMock.GetDeviceLogStates(token, deviceIds)
+	if err != nil || len(this.failedChunk) == 0 {
+		return result, err
+	}
+	for _, id := range this.failedChunk {
+		delete(result, id)
+	}
+	return result, &state.ChunkError{Chunks: 2, Failed: []state.ChunkFailure{{Index: 1, Ids: this.failedChunk, Err: errors.New("chunk unavailable")}}}
+}
+
+func TestFallbackLoggerState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local, err := state.NewLocalStore(filepath.Join(dir, "state.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &failingLoggerState{LoggerStateMock: mocks.State()}
+	remote.DeviceStates["d1"] = true
+	fallback := &FallbackLoggerState{Remote: remote, Local: local}
+	defer fallback.Close()
+
+	//successful lookups update the local store
+	states, err := fallback.GetDeviceLogStates("", []string{"d1", "d2"})
+	if err != nil || states["d1"].State != state.Connected || states["d2"].State != state.Unknown {
+		t.Error(states, err)
+	}
+
+	//emitted states are stored too
+	check := &ConnectionCheck{Logger: mocks.Logger(), LocalState: local}
+	_, err = check.logTransition(DedupDevice, "d2", false, nil, func(onDelivery func(err error)) error {
+		return check.Logger.LogDeviceDisconnect("d2", logger.EventInfo{OnDelivery: onDelivery})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = check.logTransition(DedupDevice, "d3", true, nil, func(onDelivery func(err error)) error {
+		return errors.New("unavailable")
+	})
+	if err == nil {
+		t.Error("expected error")
+	}
+
+	remote.fail = true
+	states, err = fallback.GetDeviceLogStates("", []string{"d1", "d2", "d3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states["d1"].State != state.Connected || states["d2"].State != state.Disconnected || states["d3"].State != state.Unknown {
+		t.Error(states)
+	}
+	ok, info := fallback.Check()
+	if !ok || !info.(map[string]interface{})["fallback_active"].(bool) || info.(map[string]interface{})["fallbacks"].(int) != 1 {
+		t.Error(ok, info)
+	}
+
+	remote.fail = false
+	_, err = fallback.GetDeviceLogStates("", []string{"d1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, info = fallback.Check(); info.(map[string]interface{})["fallback_active"].(bool) {
+		t.Error(info)
+	}
+
+	//failed chunks of a partially failed lookup are answered from the local store
+	remote.DeviceStates["d1"] = false
+	remote.failedChunk = []string{"d2", "d3"}
+	states, err = fallback.GetDeviceLogStates("", []string{"d1", "d2", "d3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states["d1"].State != state.Disconnected || states["d2"].State != state.Disconnected || states["d3"].State != state.Unknown {
+		t.Error(states)
+	}
+	if _, info = fallback.Check(); info.(map[string]interface{})["fallbacks"].(int) != 2 {
+		t.Error(info)
+	}
+	if local.Get(state.KindDevice, []string{"d1"})["d1"].State != state.Disconnected {
+		t.Error("successful chunk not stored")
+	}
+}
+
+func TestFallbackLoggerStateEmptyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local, err := state.NewLocalStore(filepath.Join(dir, "state.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &failingLoggerState{LoggerStateMock: mocks.State(), fail: true}
+	fallback := &FallbackLoggerState{Remote: remote, Local: local}
+	defer fallback.Close()
+
+	states, err := fallback.GetDeviceLogStates("", []string{"d1", "d2"})
+	if err != nil || len(states) != 0 {
+		t.Error(states, err)
+	}
+
+	//unanswered ids are skipped instead of being published as initial events
+	devices := mocks.Devices()
+	devices.DeviceTypes = append(devices.DeviceTypes, model.DeviceType{Id: "dt1", Services: []model.Service{{Id: "s1", ProtocolId: "p1"}}})
+	devices.Devices = append(devices.Devices, model.Device{Id: "d1", LocalId: "d1", DeviceTypeId: "dt1"})
+	eventLogger := mocks.Logger()
+	check := &ConnectionCheck{
+		Logger:              eventLogger,
+		LoggerState:         fallback,
+		LocalState:          local,
+		Verne:               onlineTopicsMock{},
+		Devices:             devices,
+		TokenGen:            mocks.TokenGen,
+		HandledProtocols:    map[string]bool{"p1": true},
+		InitialDisconnected: true,
+		SubscriptionTopicGenerator: func(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (topicCandidates []string, err error) {
+			return []string{device.Id}, nil
+		},
+	}
+	statistics := &Statistics{}
+	_, err = check.RunDeviceBatch(10, 0, statistics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eventLogger.Events) != 0 || statistics.StateLookupFailed != 1 || statistics.Unknown != 0 {
+		t.Error(eventLogger.Events, statistics.StateLookupFailed, statistics.Unknown)
+	}
+}
+
+type notReadyLoggerState struct {
+	*mocks.LoggerStateMock
+	ready bool
+}
+
+func (this *notReadyLoggerState) Ready() bool {
+	return this.ready
+}
+
+func (this *notReadyLoggerState) GetDeviceLogStates(token string, deviceIds []string) (result map[string]state.LogState, err error) {
+	if !this.ready {
+		return result, state.ErrNotReady
+	}
+	return this.LoggerStateMock.GetDeviceLogStates(token, deviceIds)
+}
+
+func TestFallbackLoggerStateReady(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local, err := state.NewLocalStore(filepath.Join(dir, "state.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &notReadyLoggerState{LoggerStateMock: mocks.State()}
+	remote.DeviceStates["d1"] = true
+	fallback := &FallbackLoggerState{Remote: remote, Local: local}
+	defer fallback.Close()
+
+	//a remote that was never ready gates the check
+	var loggerState LoggerState = fallback
+	if readyState, ok := loggerState.(ReadyLoggerState); !ok || readyState.Ready() {
+		t.Error("expected not ready")
+	}
+	_, err = fallback.GetDeviceLogStates("", []string{"d1"})
+	if err != state.ErrNotReady {
+		t.Error(err)
+	}
+
+	remote.ready = true
+	states, err := fallback.GetDeviceLogStates("", []string{"d1"})
+	if err != nil || states["d1"].State != state.Connected || !fallback.Ready() {
+		t.Error(states, err)
+	}
+
+	//after being ready, the local store answers while the remote restarts
+	remote.ready = false
+	states, err = fallback.GetDeviceLogStates("", []string{"d1"})
+	if err != nil || states["d1"].State != state.Connected || !fallback.Ready() {
+		t.Error(states, err)
+	}
+}
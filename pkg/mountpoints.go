@@ -0,0 +1,81 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/model"
+	"connection-check/pkg/vernemq"
+	"sort"
+)
+
+//vernemq mountpoints the sessions of devices and hubs are expected in
+//vernemq.AllMountpoints ("*") matches sessions of all mountpoints; the empty mountpoint is the default mountpoint of vernemq
+type Mountpoints struct {
+	Default   string
+	Protocols map[string]string //protocol-id -> mountpoint; overwrites Default
+}
+
+func (this Mountpoints) ForProtocol(protocolId string) string {
+	if mountpoint, ok := this.Protocols[protocolId]; ok {
+		return mountpoint
+	}
+	return this.Default
+}
+
+//returns the distinct configured mountpoints
+//if one of them is vernemq.AllMountpoints, only vernemq.AllMountpoints is returned, because it already contains all mountpoints
+func (this Mountpoints) All() (result []string) {
+	result = []string{this.Default}
+	if this.Default == vernemq.AllMountpoints {
+		return result
+	}
+	known := map[string]bool{this.Default: true}
+	protocols := []string{}
+	for protocol := range this.Protocols {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	for _, protocol := range protocols {
+		mountpoint := this.Protocols[protocol]
+		if mountpoint == vernemq.AllMountpoints {
+			return []string{vernemq.AllMountpoints}
+		}
+		if !known[mountpoint] {
+			known[mountpoint] = true
+			result = append(result, mountpoint)
+		}
+	}
+	return result
+}
+
+//returns the distinct mountpoints of the handled protocols used by the device-type
+func (this Mountpoints) ForDeviceType(dt model.DeviceType, handledProtocols map[string]bool) (result []string) {
+	known := map[string]bool{}
+	for _, service := range dt.Services {
+		if handledProtocols[service.ProtocolId] {
+			mountpoint := this.ForProtocol(service.ProtocolId)
+			if !known[mountpoint] {
+				known[mountpoint] = true
+				result = append(result, mountpoint)
+			}
+		}
+	}
+	if len(result) == 0 {
+		result = []string{this.Default}
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/model"
+	"connection-check/pkg/vernemq"
+	"reflect"
+	"testing"
+)
+
+func TestMountpointsForDeviceType(t *testing.T) {
+	mountpoints := Mountpoints{
+		Default:   "tenant",
+		Protocols: map[string]string{"p2": "tenant2", "p3": "tenant3"},
+	}
+	handledProtocols := map[string]bool{"p1": true, "p2": true}
+
+	t.Run("default", testMountpointsForDeviceType(mountpoints, handledProtocols, []string{"p1", "p1"}, []string{"tenant"}))
+	t.Run("protocol", testMountpointsForDeviceType(mountpoints, handledProtocols, []string{"p2", "p3"}, []string{"tenant2"}))
+	t.Run("mixed", testMountpointsForDeviceType(mountpoints, handledProtocols, []string{"p1", "p2", "p1"}, []string{"tenant", "tenant2"}))
+	t.Run("no handled protocol", testMountpointsForDeviceType(mountpoints, handledProtocols, []string{"p3"}, []string{"tenant"}))
+	t.Run("no mountpoints", testMountpointsForDeviceType(Mountpoints{}, handledProtocols, []string{"p1"}, []string{""}))
+}
+
+func TestMountpointsAll(t *testing.T) {
+	result := Mountpoints{Default: vernemq.AllMountpoints, Protocols: map[string]string{"p2": "tenant2"}}.All()
+	if !reflect.DeepEqual(result, []string{vernemq.AllMountpoints}) {
+		t.Error(result)
+	}
+	//the empty mountpoint is the default mountpoint of vernemq
+	result = Mountpoints{Default: "", Protocols: map[string]string{"p2": "tenant2"}}.All()
+	if !reflect.DeepEqual(result, []string{"", "tenant2"}) {
+		t.Error(result)
+	}
+	result = Mountpoints{Default: "tenant", Protocols: map[string]string{"p2": vernemq.AllMountpoints}}.All()
+	if !reflect.DeepEqual(result, []string{vernemq.AllMountpoints}) {
+		t.Error(result)
+	}
+	result = Mountpoints{Default: "tenant", Protocols: map[string]string{"p3": "tenant3", "p2": "tenant2", "p1": "tenant", "p4": "tenant2"}}.All()
+	if !reflect.DeepEqual(result, []string{"tenant", "tenant2", "tenant3"}) {
+		t.Error(result)
+	}
+}
+
+func testMountpointsForDeviceType(mountpoints Mountpoints, handledProtocols map[string]bool, protocols []string, expected []string) func(t *testing.T) {
+	return func(t *testing.T) {
+		dt := model.DeviceType{}
+		for _, protocol := range protocols {
+			dt.Services = append(dt.Services, model.Service{ProtocolId: protocol})
+		}
+		result := mountpoints.ForDeviceType(dt, handledProtocols)
+		if !reflect.DeepEqual(result, expected) {
+			t.Error(result, expected)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package probe
+
+import (
+	"bytes"
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/model"
+	"connection-check/pkg/topicgenerator/common"
+	"connection-check/pkg/topicgenerator/mqtt/shortid"
+	"errors"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	uuid "github.com/satori/go.uuid"
+	"log"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const DefaultRequestTopic = "command/{{.LocalDeviceId}}/{{.LocalServiceId}}"
+const DefaultResponseTopic = "response/{{.LocalDeviceId}}/{{.LocalServiceId}}"
+const DefaultPayload = `{"correlation_id":"{{.CorrelationId}}","payload":{},"timestamp":{{.Timestamp}}}`
+const DefaultTimeout = 5 * time.Second
+
+type Config struct {
+	BrokerUrl     string
+	ClientId      string
+	User          string
+	Password      string
+	RequestTopic  string //template; see TemplateData
+	ResponseTopic string //template; see TemplateData
+	Payload       string //template; see TemplateData
+	Service       string //required; regular expression of local service ids that may be used to probe, e.g. a side-effect-free status service
+	Timeout       time.Duration
+	Qos           byte
+}
+
+//values usable in the topic and payload templates
+type TemplateData struct {
+	DeviceId       string
+	LocalDeviceId  string
+	ShortDeviceId  string
+	ServiceId      string
+	LocalServiceId string
+	CorrelationId  string
+	Timestamp      int64
+}
+
+//sends a command to a request service of the device and waits for a response that contains the correlation id
+type Prober struct {
+	client        paho.Client
+	requestTopic  *template.Template
+	responseTopic *template.Template
+	payload       *template.Template
+	service       *regexp.Regexp
+	timeout       time.Duration
+	qos           byte
+}
+
+func NewFromConfig(config configuration.Config) (result *Prober, err error) {
+	timeout := DefaultTimeout
+	if config.ProbeTimeout != "" && config.ProbeTimeout != "-" {
+		timeout, err = time.ParseDuration(config.ProbeTimeout)
+		if err != nil {
+			return result, errors.New("invalid probe_timeout: " + err.Error())
+		}
+	}
+	if config.ProbeQos < 0 || config.ProbeQos > 2 {
+		return result, errors.New("invalid probe_qos: " + strconv.Itoa(config.ProbeQos))
+	}
+	return New(Config{
+		BrokerUrl:     config.ProbeBrokerUrl,
+		ClientId:      config.ProbeClientId,
+		User:          config.ProbeUser,
+		Password:      config.ProbePassword,
+		RequestTopic:  config.ProbeRequestTopic,
+		ResponseTopic: config.ProbeResponseTopic,
+		Payload:       config.ProbePayload,
+		Service:       config.ProbeService,
+		Timeout:       timeout,
+		Qos:           byte(config.ProbeQos),
+	})
+}
+
+func New(config Config) (result *Prober, err error) {
+	result = &Prober{timeout: config.Timeout, qos: config.Qos}
+	if result.timeout <= 0 {
+		result.timeout = DefaultTimeout
+	}
+	result.requestTopic, err = parseTemplate("request topic", config.RequestTopic, DefaultRequestTopic)
+	if err != nil {
+		return result, err
+	}
+	result.responseTopic, err = parseTemplate("response topic", config.ResponseTopic, DefaultResponseTopic)
+	if err != nil {
+		return result, err
+	}
+	result.payload, err = parseTemplate("payload", config.Payload, DefaultPayload)
+	if err != nil {
+		return result, err
+	}
+	//every probe sends a real command; an arbitrary request service may control the device
+	if config.Service == "" || config.Service == "-" {
+		return result, errors.New("missing probe_service: the probe needs an expression of services that may be called without side effects")
+	}
+	result.service, err = regexp.Compile("^(" + config.Service + ")$")
+	if err != nil {
+		debug.PrintStack()
+		return result, errors.New("invalid probe service expression: " + err.Error())
+	}
+	clientId := config.ClientId
+	if clientId == "" {
+		clientId = "connection-check-probe-" + uuid.NewV4().String()
+	}
+	options := paho.NewClientOptions().
+		SetAutoReconnect(true).
+		SetCleanSession(true).
+		SetClientID(clientId).
+		SetUsername(config.User).
+		SetPassword(config.Password).
+		AddBroker(config.BrokerUrl)
+	result.client = paho.NewClient(options)
+	token := result.client.Connect()
+	if !token.WaitTimeout(result.timeout) {
+		return result, errors.New("timeout on probe broker connect")
+	}
+	if token.Error() != nil {
+		log.Println("ERROR: unable to connect probe client to broker", token.Error())
+		return result, token.Error()
+	}
+	return result, nil
+}
+
+func parseTemplate(name string, value string, defaultValue string) (*template.Template, error) {
+	if value == "" || value == "-" {
+		value = defaultValue
+	}
+	result, err := template.New(name).Parse(value)
+	if err != nil {
+		debug.PrintStack()
+		return nil, errors.New("invalid probe " + name + " template: " + err.Error())
+	}
+	return result, nil
+}
+
+//applicable is false if the device has no handled request service that may be probed
+//alive is true if the device responded within the timeout
+func (this *Prober) Probe(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (alive bool, applicable bool, err error) {
+	service, applicable := this.selectService(deviceType, handledProtocols)
+	if !applicable {
+		return false, false, nil
+	}
+	shortDeviceId, err := shortid.ShortId(device.Id)
+	if err != nil {
+		return false, true, err
+	}
+	data := TemplateData{
+		DeviceId:       device.Id,
+		LocalDeviceId:  device.LocalId,
+		ShortDeviceId:  shortDeviceId,
+		ServiceId:      service.Id,
+		LocalServiceId: service.LocalId,
+		CorrelationId:  uuid.NewV4().String(),
+		Timestamp:      time.Now().Unix(),
+	}
+	requestTopic, err := execute(this.requestTopic, data)
+	if err != nil {
+		return false, true, err
+	}
+	responseTopic, err := execute(this.responseTopic, data)
+	if err != nil {
+		return false, true, err
+	}
+	payload, err := execute(this.payload, data)
+	if err != nil {
+		return false, true, err
+	}
+
+	response := make(chan bool, 1)
+	err = this.wait(this.client.Subscribe(responseTopic, this.qos, func(client paho.Client, message paho.Message) {
+		if strings.Contains(string(message.Payload()), data.CorrelationId) {
+			select {
+			case response <- true:
+			default:
+			}
+		}
+	}))
+	if err != nil {
+		return false, true, err
+	}
+	defer func() {
+		unsubscribeErr := this.wait(this.client.Unsubscribe(responseTopic))
+		if unsubscribeErr != nil {
+			log.Println("WARNING: unable to unsubscribe probe response topic", responseTopic, unsubscribeErr)
+		}
+	}()
+
+	start := time.Now()
+	err = this.wait(this.client.Publish(requestTopic, this.qos, false, payload))
+	if err != nil {
+		return false, true, err
+	}
+	timer := time.NewTimer(this.timeout - time.Since(start))
+	defer timer.Stop()
+	select {
+	case <-response:
+		return true, true, nil
+	case <-timer.C:
+		return false, true, nil
+	}
+}
+
+func (this *Prober) selectService(deviceType model.DeviceType, handledProtocols map[string]bool) (result model.Service, ok bool) {
+	for _, service := range common.GetHandledServices(deviceType.Services, handledProtocols) {
+		if service.Interaction != model.REQUEST && service.Interaction != model.EVENT_AND_REQUEST {
+			continue
+		}
+		if this.service != nil && !this.service.MatchString(service.LocalId) {
+			continue
+		}
+		return service, true
+	}
+	return result, false
+}
+
+func (this *Prober) wait(token paho.Token) error {
+	if !token.WaitTimeout(this.timeout) {
+		return errors.New("timeout on probe broker request")
+	}
+	return token.Error()
+}
+
+func execute(tmpl *template.Template, data TemplateData) (string, error) {
+	var temp bytes.Buffer
+	err := tmpl.Execute(&temp, data)
+	if err != nil {
+		debug.PrintStack()
+		return "", err
+	}
+	return temp.String(), nil
+}
+
+func (this *Prober) Close() {
+	this.client.Disconnect(250)
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package probe
+
+import (
+	"connection-check/pkg/model"
+	"connection-check/pkg/test/docker"
+	"context"
+	"encoding/json"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ory/dockertest/v3"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var handledProtocols = map[string]bool{"mqtt": true}
+
+var requestDeviceType = model.DeviceType{
+	Id: "dt1",
+	Services: []model.Service{
+		{Id: "s1", LocalId: "sensor", ProtocolId: "mqtt", Interaction: model.EVENT},
+		{Id: "s2", LocalId: "other", ProtocolId: "other", Interaction: model.REQUEST},
+		{Id: "s3", LocalId: "get", ProtocolId: "mqtt", Interaction: model.EVENT_AND_REQUEST},
+		{Id: "s4", LocalId: "set", ProtocolId: "mqtt", Interaction: model.REQUEST},
+	},
+}
+
+var eventDeviceType = model.DeviceType{
+	Id: "dt2",
+	Services: []model.Service{
+		{Id: "s1", LocalId: "sensor", ProtocolId: "mqtt", Interaction: model.EVENT},
+	},
+}
+
+func TestSelectService(t *testing.T) {
+	prober := &Prober{}
+	service, ok := prober.selectService(requestDeviceType, handledProtocols)
+	if !ok || service.LocalId != "get" {
+		t.Error(service, ok)
+	}
+	prober.service = regexp.MustCompile("^(set)$")
+	service, ok = prober.selectService(requestDeviceType, handledProtocols)
+	if !ok || service.LocalId != "set" {
+		t.Error(service, ok)
+	}
+	_, ok = prober.selectService(eventDeviceType, handledProtocols)
+	if ok {
+		t.Error("event device type should not be probed")
+	}
+}
+
+func TestProbeServiceRequired(t *testing.T) {
+	_, err := New(Config{BrokerUrl: "tcp://localhost:1883"})
+	if err == nil || !strings.Contains(err.Error(), "probe_service") {
+		t.Error(err)
+	}
+	_, err = New(Config{BrokerUrl: "tcp://localhost:1883", Service: "("})
+	if err == nil || !strings.Contains(err.Error(), "invalid probe service") {
+		t.Error(err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer cancel()
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	brokerUrl, _, err := docker.VernemqWithManagementApi(pool, ctx, wg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	t.Run("start responding device", testStartDevice(ctx, wg, brokerUrl, "responding", true))
+	t.Run("start zombie device", testStartDevice(ctx, wg, brokerUrl, "zombie", false))
+
+	prober, err := New(Config{BrokerUrl: brokerUrl, Service: "get", Timeout: 2 * time.Second})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer prober.Close()
+
+	t.Run("responding device", testProbe(prober, model.Device{Id: "urn:infai:ses:device:1", LocalId: "responding"}, requestDeviceType, true, true))
+	t.Run("zombie device", testProbe(prober, model.Device{Id: "urn:infai:ses:device:2", LocalId: "zombie"}, requestDeviceType, false, true))
+	t.Run("event device", testProbe(prober, model.Device{Id: "urn:infai:ses:device:3", LocalId: "responding"}, eventDeviceType, false, false))
+}
+
+func testProbe(prober *Prober, device model.Device, dt model.DeviceType, expectedAlive bool, expectedApplicable bool) func(t *testing.T) {
+	return func(t *testing.T) {
+		alive, applicable, err := prober.Probe(device, dt, handledProtocols)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if alive != expectedAlive || applicable != expectedApplicable {
+			t.Error(alive, applicable, expectedAlive, expectedApplicable)
+		}
+	}
+}
+
+//subscribes the commands of the device and answers with the correlation id if respond is true
+func testStartDevice(ctx context.Context, wg *sync.WaitGroup, broker string, localDeviceId string, respond bool) func(t *testing.T) {
+	return func(t *testing.T) {
+		options := mqtt.NewClientOptions().
+			SetCleanSession(true).
+			SetClientID(localDeviceId).
+			SetAutoReconnect(true).
+			AddBroker(broker)
+
+		client := mqtt.NewClient(options)
+		if token := client.Connect(); token.Wait() && token.Error() != nil {
+			t.Error(token.Error())
+			return
+		}
+		wg.Add(1)
+		go func() {
+			<-ctx.Done()
+			client.Disconnect(0)
+			wg.Done()
+		}()
+		token := client.Subscribe("command/"+localDeviceId+"/+", 0, func(client mqtt.Client, message mqtt.Message) {
+			if !respond {
+				return
+			}
+			command := map[string]interface{}{}
+			err := json.Unmarshal(message.Payload(), &command)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			response, _ := json.Marshal(map[string]interface{}{"correlation_id": command["correlation_id"], "payload": map[string]interface{}{}})
+			client.Publish(strings.Replace(message.Topic(), "command/", "response/", 1), 0, false, response)
+		})
+		if token.Wait() && token.Error() != nil {
+			t.Error(token.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/vernemq"
+	"reflect"
+	"testing"
+)
+
+func TestSubscriptionSession(t *testing.T) {
+	subscription := &vernemq.Subscription{
+		ClientId:        "gateway",
+		User:            "user",
+		Topic:           "command/d1/+",
+		ShareGroup:      "workers",
+		PeerHost:        "10.0.0.1",
+		PeerPort:        1234,
+		ProtocolVersion: 5,
+	}
+
+	check := &ConnectionCheck{}
+	if session := check.subscriptionSession(subscription); session != nil {
+		t.Error("expect no session if EventSessionDetails is not set", session)
+	}
+
+	check.EventSessionDetails = true
+	if session := check.subscriptionSession(nil); session != nil {
+		t.Error(session)
+	}
+	session := check.subscriptionSession(subscription)
+	expected := &logger.Session{
+		ClientId:        "gateway",
+		User:            "user",
+		PeerHost:        "10.0.0.1",
+		PeerPort:        1234,
+		ProtocolVersion: 5,
+		Topic:           "$share/workers/command/d1/+",
+	}
+	if !reflect.DeepEqual(session, expected) {
+		t.Error(session, expected)
+	}
+}
+
+func TestClientSession(t *testing.T) {
+	check := &ConnectionCheck{EventSessionDetails: true}
+	session := check.clientSession(&vernemq.Client{Id: "hub", User: "user", PeerHost: "10.0.0.2", PeerPort: 4321, ProtocolVersion: 4, Mountpoint: "tenant"})
+	expected := &logger.Session{
+		ClientId:        "hub",
+		User:            "user",
+		PeerHost:        "10.0.0.2",
+		PeerPort:        4321,
+		ProtocolVersion: 4,
+		Mountpoint:      "tenant",
+	}
+	if !reflect.DeepEqual(session, expected) {
+		t.Error(session, expected)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	security "connection-check/pkg/auth"
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/connectionlog/logger/kafka"
+	"connection-check/pkg/connectionlog/outbox"
+	"connection-check/pkg/connectionlog/sink"
+	"connection-check/pkg/httpclient"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const SinkKafka = "kafka"
+const SinkWebhook = "webhook"
+const SinkNats = "nats"
+const SinkFile = "file"
+const SinkMqtt = "mqtt"
+
+//creates a logger for every configured event sink; more than one sink is combined to a MultiLogger
+//the returned outbox is nil if the kafka sink is not used or outbox_dir is not set
+func NewEventLogger(config configuration.Config, client *httpclient.Client) (result Logger, eventOutbox *outbox.Outbox, err error) {
+	sinks := config.EventSinks
+	if len(sinks) == 0 {
+		sinks = []string{SinkKafka}
+	}
+	encoder, err := logger.NewEncoder(config.EventEncoding)
+	if err != nil {
+		return result, eventOutbox, err
+	}
+	loggers := &MultiLogger{}
+	for _, name := range sinks {
+		name = strings.TrimSpace(name)
+		var producer kafka.ProducerInterface
+		deviceTopic, hubTopic := config.DeviceLogTopic, config.HubLogTopic
+		switch name {
+		case SinkKafka:
+			producer, eventOutbox, err = newKafkaProducer(config, client)
+		case SinkWebhook:
+			if config.WebhookUrl == "" {
+				err = errors.New("missing webhook_url for event sink webhook")
+				break
+			}
+			producer = sink.NewWebhook(config.WebhookUrl, config.WebhookSecret, client)
+		case SinkNats:
+			producer, err = sink.NewNats(config.NatsUrl)
+			deviceTopic, hubTopic = config.NatsDeviceSubject, config.NatsHubSubject
+		case SinkFile:
+			producer, err = sink.NewFile(config.EventFile)
+		case SinkMqtt:
+			producer, err = newMqttProducer(config)
+			deviceTopic, hubTopic = topicOrDefault(config.MqttStatusDeviceTopic, sink.DefaultMqttDeviceTopic), topicOrDefault(config.MqttStatusHubTopic, sink.DefaultMqttHubTopic)
+		default:
+			err = errors.New("unknown event sink " + name)
+		}
+		if _, ok := producer.(kafka.HeaderProducer); err == nil && encoder.RequiresHeaders() && !ok {
+			producer.Close()
+			err = errors.New("event sink " + name + " does not support event_encoding " + config.EventEncoding)
+		}
+		if err != nil {
+			loggers.Close()
+			return result, eventOutbox, err
+		}
+		eventLogger := logger.NewWithProducer(producer, deviceTopic, hubTopic)
+		eventLogger.LegacyFormat = config.EventLegacyFormat
+		eventLogger.Encoder = encoder
+		loggers.Add(name, eventLogger)
+		log.Println("use event sink", name)
+	}
+	if len(loggers.sinks) == 1 {
+		return loggers.sinks[0].logger, eventOutbox, nil
+	}
+	return loggers, eventOutbox, nil
+}
+
+//OAUTHBEARER tokens are requested from auth_endpoint with auth_client_id and auth_client_secret
+func newKafkaCluster(config configuration.Config, client *httpclient.Client) (kafka.Cluster, error) {
+	return kafka.NewClusterFromConfig(config, security.New(config.AuthEndpoint, config.AuthClientId, config.AuthClientSecret, 2, client))
+}
+
+func newKafkaProducer(config configuration.Config, client *httpclient.Client) (producer kafka.ProducerInterface, eventOutbox *outbox.Outbox, err error) {
+	producerConfig, err := kafka.NewProducerConfig(config)
+	if err != nil {
+		return producer, eventOutbox, err
+	}
+	cluster, err := newKafkaCluster(config, client)
+	if err != nil {
+		return producer, eventOutbox, err
+	}
+	//fails early on missing topics or mismatching topic settings
+	err = cluster.InitTopic(config.DeviceLogTopic, config.HubLogTopic)
+	if err != nil {
+		return producer, eventOutbox, err
+	}
+	producer, err = kafka.PrepareProducerWithConfig(cluster, producerConfig)
+	if err != nil {
+		return producer, eventOutbox, err
+	}
+	if config.OutboxDir != "" {
+		eventOutbox, err = outbox.NewFromConfig(config, producer)
+		if err != nil {
+			producer.Close()
+			return producer, eventOutbox, err
+		}
+		producer = eventOutbox
+	}
+	return producer, eventOutbox, nil
+}
+
+func newMqttProducer(config configuration.Config) (producer kafka.ProducerInterface, err error) {
+	if config.MqttStatusBrokerUrl == "" {
+		return producer, errors.New("missing mqtt_status_broker_url for event sink mqtt")
+	}
+	if config.MqttStatusQos < 0 || config.MqttStatusQos > 2 {
+		return producer, errors.New("invalid mqtt_status_qos: " + strconv.Itoa(config.MqttStatusQos))
+	}
+	return sink.NewMqtt(sink.MqttConfig{
+		BrokerUrl:          config.MqttStatusBrokerUrl,
+		ClientId:           config.MqttStatusClientId,
+		User:               config.MqttStatusUser,
+		Password:           config.MqttStatusPassword,
+		Qos:                byte(config.MqttStatusQos),
+		CaFile:             config.MqttStatusCaFile,
+		CertFile:           config.MqttStatusClientCertFile,
+		KeyFile:            config.MqttStatusClientKeyFile,
+		InsecureSkipVerify: config.MqttStatusInsecureSkipVerify,
+	})
+}
+
+func topicOrDefault(topic string, defaultTopic string) string {
+	if topic == "" || topic == "-" {
+		return defaultTopic
+	}
+	return topic
+}
+
+//delivers every event to all sinks
+//the kafka sink (or the first sink without kafka) is the primary sink: its result is the result of the event and only its delivery is passed to OnDelivery;
+//the other sinks are best-effort: a failing sink is logged and counted (see Check) but neither prevents the delivery to the others nor fails the event
+type MultiLogger struct {
+	mux     sync.Mutex
+	sinks   []*namedSink
+	primary int //index of the primary sink
+}
+
+type namedSink struct {
+	name    string
+	logger  Logger
+	failed  int
+	lastErr error //error of the last delivery; nil after a successful delivery
+}
+
+func (this *MultiLogger) Add(name string, l Logger) {
+	if name == SinkKafka {
+		this.primary = len(this.sinks)
+	}
+	this.sinks = append(this.sinks, &namedSink{name: name, logger: l})
+}
+
+func (this *MultiLogger) LogDeviceDisconnect(deviceId string, info logger.EventInfo) error {
+	return this.each(info, func(l Logger, info logger.EventInfo) error { return l.LogDeviceDisconnect(deviceId, info) })
+}
+
+func (this *MultiLogger) LogDeviceConnect(deviceId string, info logger.EventInfo) error {
+	return this.each(info, func(l Logger, info logger.EventInfo) error { return l.LogDeviceConnect(deviceId, info) })
+}
+
+func (this *MultiLogger) LogHubConnect(clientId string, info logger.EventInfo) error {
+	return this.each(info, func(l Logger, info logger.EventInfo) error { return l.LogHubConnect(clientId, info) })
+}
+
+func (this *MultiLogger) LogHubDisconnect(clientId string, info logger.EventInfo) error {
+	return this.each(info, func(l Logger, info logger.EventInfo) error { return l.LogHubDisconnect(clientId, info) })
+}
+
+//returns the first error; pending deliveries of all sinks are awaited
+func (this *MultiLogger) Flush() (err error) {
+	for _, s := range this.sinks {
+		temp := s.logger.Flush()
+		if temp != nil {
+			log.Println("ERROR: unable to flush event sink", s.name, temp)
+			if err == nil {
+				err = temp
+			}
+		}
+	}
+	return err
+}
+
+func (this *MultiLogger) Close() {
+	for _, s := range this.sinks {
+		s.logger.Close()
+	}
+}
+
+//health check; not ok while the last delivery of a sink failed
+func (this *MultiLogger) Check() (ok bool, info interface{}) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	ok = true
+	result := map[string]interface{}{}
+	for _, s := range this.sinks {
+		lastErr := ""
+		if s.lastErr != nil {
+			lastErr = s.lastErr.Error()
+			ok = false
+		}
+		result[s.name] = map[string]interface{}{"failed": s.failed, "last_error": lastErr}
+	}
+	return ok, result
+}
+
+//returns the error of the primary sink
+func (this *MultiLogger) each(info logger.EventInfo, f func(l Logger, info logger.EventInfo) error) (err error) {
+	for index, s := range this.sinks {
+		primary := index == this.primary
+		sinkInfo := info
+		sinkInfo.OnDelivery = this.delivery(s, primary, info.OnDelivery)
+		temp := f(s.logger, sinkInfo)
+		if temp != nil {
+			//events that fail before they are produced have no delivery
+			sinkInfo.OnDelivery(temp)
+			if primary {
+				err = temp
+			}
+		}
+	}
+	return err
+}
+
+//returns the delivery callback of the sink; only the first call counts
+//onDelivery is only called for the primary sink
+func (this *MultiLogger) delivery(s *namedSink, primary bool, onDelivery func(err error)) func(err error) {
+	once := sync.Once{}
+	return func(err error) {
+		once.Do(func() {
+			this.report(s, err)
+			if primary && onDelivery != nil {
+				onDelivery(err)
+			}
+		})
+	}
+}
+
+func (this *MultiLogger) report(s *namedSink, err error) {
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	if err != nil {
+		log.Println("ERROR: event sink failed", s.name, err)
+		s.failed++
+	}
+	s.lastErr = err
+}
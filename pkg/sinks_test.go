@@ -0,0 +1,260 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/connectionlog/logger"
+	"connection-check/pkg/connectionlog/sink"
+	"connection-check/pkg/test/mocks"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type failingLogger struct {
+	*mocks.LoggerMock
+}
+
+func (this failingLogger) LogDeviceConnect(deviceId string, info logger.EventInfo) error {
+	return errors.New("sink unavailable")
+}
+
+func TestMultiLogger(t *testing.T) {
+	first := mocks.Logger()
+	second := mocks.Logger()
+	deliveries := []error{}
+	onDelivery := func(err error) { deliveries = append(deliveries, err) }
+	loggers := &MultiLogger{}
+	loggers.Add("first", first)
+	loggers.Add("failing", failingLogger{mocks.Logger()})
+	loggers.Add("second", second)
+
+	//a failing secondary sink neither fails the event nor prevents the delivery to the others
+	err := loggers.LogDeviceConnect("d1", logger.EventInfo{Reason: logger.ReasonSubscriptionFound, OnDelivery: onDelivery})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(deliveries) != 1 || deliveries[0] != nil {
+		t.Error("expected one successful delivery per event", deliveries)
+	}
+	ok, info := loggers.Check()
+	sinkInfo := info.(map[string]interface{})["failing"].(map[string]interface{})
+	if ok || sinkInfo["failed"].(int) != 1 || sinkInfo["last_error"].(string) != "sink unavailable" {
+		t.Error(ok, info)
+	}
+
+	err = loggers.LogHubDisconnect("h1", logger.EventInfo{Reason: logger.ReasonClientGone, OnDelivery: onDelivery})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(deliveries) != 2 || deliveries[1] != nil {
+		t.Error(deliveries)
+	}
+	for _, mock := range []*mocks.LoggerMock{first, second} {
+		if len(mock.Events) != 2 || mock.Events[0].Id != "d1" || mock.Events[1].Id != "h1" || mock.Events[1].Reason != logger.ReasonClientGone {
+			t.Error(mock.Events)
+		}
+	}
+	if ok, info = loggers.Check(); !ok {
+		t.Error(info)
+	}
+	if err = loggers.Flush(); err != nil {
+		t.Error(err)
+	}
+	loggers.Close()
+
+	//the event fails if the primary sink fails, even if another sink delivered it
+	failing := &MultiLogger{}
+	failing.Add(SinkFile, mocks.Logger())
+	failing.Add(SinkKafka, failingLogger{mocks.Logger()})
+	err = failing.LogDeviceConnect("d1", logger.EventInfo{OnDelivery: onDelivery})
+	if err == nil || err.Error() != "sink unavailable" {
+		t.Error(err)
+	}
+	if len(deliveries) != 3 || deliveries[2] == nil {
+		t.Error(deliveries)
+	}
+
+	//a failing secondary sink does not fail the delivery of the primary sink
+	secondaryFailing := &MultiLogger{}
+	secondaryFailing.Add(SinkWebhook, failingLogger{mocks.Logger()})
+	secondaryFailing.Add(SinkKafka, mocks.Logger())
+	err = secondaryFailing.LogDeviceConnect("d1", logger.EventInfo{OnDelivery: onDelivery})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(deliveries) != 4 || deliveries[3] != nil {
+		t.Error(deliveries)
+	}
+}
+
+func TestNewEventLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sinks")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	mux := sync.Mutex{}
+	posted := []string{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		posted = append(posted, r.Header.Get(sink.WebhookTopicHeader)+" "+string(body))
+	}))
+	defer mock.Close()
+
+	config := &configuration.ConfigStruct{
+		EventSinks:     []string{"webhook", "file"},
+		WebhookUrl:     mock.URL,
+		EventFile:      filepath.Join(dir, "events.jsonl"),
+		DeviceLogTopic: "device_log",
+		HubLogTopic:    "gateway_log",
+	}
+
+	t.Run("unknown sink", func(t *testing.T) {
+		_, _, err := NewEventLogger(&configuration.ConfigStruct{EventSinks: []string{"file", "foo"}, EventFile: filepath.Join(dir, "unknown.jsonl")}, nil)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("missing webhook url", func(t *testing.T) {
+		_, _, err := NewEventLogger(&configuration.ConfigStruct{EventSinks: []string{"webhook"}}, nil)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("missing mqtt status broker", func(t *testing.T) {
+		_, _, err := NewEventLogger(&configuration.ConfigStruct{EventSinks: []string{"mqtt"}}, nil)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("unknown encoding", func(t *testing.T) {
+		_, _, err := NewEventLogger(&configuration.ConfigStruct{EventSinks: []string{"file"}, EventFile: filepath.Join(dir, "encoding.jsonl"), EventEncoding: "xml"}, nil)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("cloudevents binary", func(t *testing.T) {
+		path := filepath.Join(dir, "cloudevents.jsonl")
+		eventLogger, _, err := NewEventLogger(&configuration.ConfigStruct{EventSinks: []string{"file"}, EventFile: path, EventEncoding: logger.EncodingCloudEventsBinary, DeviceLogTopic: "device_log"}, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = eventLogger.LogDeviceConnect("d1", logger.EventInfo{Reason: logger.ReasonSubscriptionFound})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		eventLogger.Close()
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		entry := sink.FileEntry{}
+		err = json.Unmarshal(content, &entry)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		event, err := logger.DecodeEvent(entry.Event)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if entry.Headers["ce_type"] != logger.CloudEventTypeDevice || entry.Headers["ce_subject"] != "d1" || event.Id != "d1" || !event.Connected {
+			t.Error(entry.Headers, event)
+		}
+	})
+
+	t.Run("fan out", func(t *testing.T) {
+		eventLogger, eventOutbox, err := NewEventLogger(config, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if eventOutbox != nil {
+			t.Error("unexpected outbox")
+		}
+		if _, ok := eventLogger.(*MultiLogger); !ok {
+			t.Errorf("%T", eventLogger)
+		}
+		err = eventLogger.LogDeviceConnect("d1", logger.EventInfo{Reason: logger.ReasonSubscriptionFound})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = eventLogger.LogHubDisconnect("h1", logger.EventInfo{Reason: logger.ReasonNoSubscription})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err = eventLogger.Flush(); err != nil {
+			t.Error(err)
+		}
+		eventLogger.Close()
+
+		mux.Lock()
+		defer mux.Unlock()
+		if len(posted) != 2 || !strings.HasPrefix(posted[0], "device_log {") || !strings.HasPrefix(posted[1], "gateway_log {") {
+			t.Error(posted)
+		}
+
+		content, err := ioutil.ReadFile(config.EventFile)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != 2 {
+			t.Error(lines)
+			return
+		}
+		entry := sink.FileEntry{}
+		err = json.Unmarshal([]byte(lines[1]), &entry)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		event := logger.Event{}
+		err = json.Unmarshal(entry.Event, &event)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if entry.Topic != "gateway_log" || entry.Key != "h1" || event.Id != "h1" || event.Connected || event.Reason != logger.ReasonNoSubscription {
+			t.Error(entry.Topic, entry.Key, event)
+		}
+	})
+}
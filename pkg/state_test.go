@@ -0,0 +1,240 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connectioncheck
+
+import (
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/connectionlog/state"
+	"connection-check/pkg/model"
+	"connection-check/pkg/test/mocks"
+	"connection-check/pkg/topicgenerator/common"
+	"connection-check/pkg/vernemq"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type loggerStateMock struct {
+	ready bool
+	calls int
+}
+
+func (this *loggerStateMock) GetHubLogStates(token string, hubIds []string) (result map[string]state.LogState, err error) {
+	this.calls++
+	return map[string]state.LogState{}, nil
+}
+
+func (this *loggerStateMock) GetDeviceLogStates(token string, deviceIds []string) (result map[string]state.LogState, err error) {
+	this.calls++
+	return map[string]state.LogState{}, nil
+}
+
+func (this *loggerStateMock) Ready() bool {
+	return this.ready
+}
+
+func TestRunSkippedUntilStateReady(t *testing.T) {
+	loggerState := &loggerStateMock{ready: false}
+	//devices, hubs and verne are nil; run would panic if it did not skip
+	check := &ConnectionCheck{LoggerState: loggerState}
+	health := NewHealthChecker(0, 0)
+	check.run(health)
+	if loggerState.calls != 0 {
+		t.Error(loggerState.calls)
+	}
+}
+
+func TestNewLoggerState(t *testing.T) {
+	loggerState, err := newLoggerState(&configuration.ConfigStruct{ConnectionLogStateUrl: "http://connection-log:8080"}, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := loggerState.(ReadyLoggerState); ok {
+		t.Error("http state should always be ready")
+	}
+	_, err = newLoggerState(&configuration.ConfigStruct{ConnectionLogStateSource: "foo"}, nil)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+type onlineTopicsMock map[string]bool
+
+func (this onlineTopicsMock) CheckOnlineSubscription(mountpoint string, topic string) (subscription *vernemq.Subscription, err error) {
+	return this.CheckOnlineSubscriptions(mountpoint, []string{topic})
+}
+
+func (this onlineTopicsMock) CheckOnlineSubscriptions(mountpoint string, topics []string) (subscription *vernemq.Subscription, err error) {
+	for _, topic := range topics {
+		if this[topic] {
+			return &vernemq.Subscription{Topic: topic}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (this onlineTopicsMock) CheckOnlineClient(mountpoint string, clientId string) (client *vernemq.Client, err error) {
+	if this[clientId] {
+		return &vernemq.Client{Id: clientId}, nil
+	}
+	return nil, nil
+}
+
+func TestUnknownState(t *testing.T) {
+	devices := mocks.Devices()
+	devices.DeviceTypes = append(devices.DeviceTypes, model.DeviceType{Id: "dt1"})
+	loggerState := mocks.State()
+	for _, device := range []struct {
+		id     string
+		state  state.ConnectionState
+		online bool
+	}{
+		{"unknown_online", state.Unknown, true},
+		{"unknown_offline", state.Unknown, false},
+		{"connected_offline", state.Connected, false},
+		{"disconnected_online", state.Disconnected, true},
+		{"disconnected_offline", state.Disconnected, false},
+	} {
+		devices.Devices = append(devices.Devices, model.Device{Id: device.id, LocalId: device.id, DeviceTypeId: "dt1"})
+		if device.state != state.Unknown {
+			loggerState.DeviceStates[device.id] = device.state == state.Connected
+		}
+	}
+	verne := onlineTopicsMock{"unknown_online": true, "disconnected_online": true}
+	topics := func(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (topicCandidates []string, err error) {
+		return []string{device.Id}, nil
+	}
+
+	for _, initialDisconnected := range []bool{false, true} {
+		eventLogger := mocks.Logger()
+		check := &ConnectionCheck{
+			Logger:                     eventLogger,
+			LoggerState:                loggerState,
+			Verne:                      verne,
+			Devices:                    devices,
+			TokenGen:                   mocks.TokenGen,
+			SubscriptionTopicGenerator: topics,
+			InitialDisconnected:        initialDisconnected,
+		}
+		statistics := &Statistics{}
+		_, err := check.RunDeviceBatch(10, 0, statistics)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []mocks.LogEvent{{Id: "unknown_online", Kind: "device", Connected: true, Reason: "subscription_found", Initial: true}}
+		if initialDisconnected {
+			expected = append(expected, mocks.LogEvent{Id: "unknown_offline", Kind: "device", Connected: false, Reason: "no_subscription_found", Initial: true})
+		}
+		expected = append(expected,
+			mocks.LogEvent{Id: "connected_offline", Kind: "device", Connected: false, Reason: "no_subscription_found"},
+			mocks.LogEvent{Id: "disconnected_online", Kind: "device", Connected: true, Reason: "subscription_found"},
+		)
+		if !reflect.DeepEqual(eventLogger.Events, expected) {
+			t.Error(initialDisconnected, eventLogger.Events)
+		}
+		if statistics.Unknown != 2 {
+			t.Error(statistics.Unknown)
+		}
+	}
+}
+
+func TestStateChangedDuringCheck(t *testing.T) {
+	devices := mocks.Devices()
+	devices.DeviceTypes = append(devices.DeviceTypes, model.DeviceType{Id: "dt1", Services: []model.Service{{Id: "s1", ProtocolId: "p1"}}})
+	loggerState := mocks.State()
+	future := time.Now().Add(time.Hour)
+	for _, id := range []string{"d_old", "d_new", "h_old", "h_new"} {
+		devices.Devices = append(devices.Devices, model.Device{Id: id, LocalId: id, DeviceTypeId: "dt1"})
+		loggerState.DeviceStates[id] = true
+		loggerState.HubStates[id] = true
+		loggerState.ChangeTimes[id] = time.Now().Add(-time.Hour)
+	}
+	//another logger recorded the connect after the start of the vernemq check
+	loggerState.ChangeTimes["d_new"] = future
+	loggerState.ChangeTimes["h_new"] = future
+	devices.Hubs = append(devices.Hubs, model.Hub{Id: "h_old", DeviceLocalIds: []string{"d_old"}}, model.Hub{Id: "h_new", DeviceLocalIds: []string{"d_old"}})
+
+	eventLogger := mocks.Logger()
+	check := &ConnectionCheck{
+		Logger:           eventLogger,
+		LoggerState:      loggerState,
+		Verne:            onlineTopicsMock{},
+		Devices:          devices,
+		TokenGen:         mocks.TokenGen,
+		HandledProtocols: map[string]bool{"p1": true},
+		SubscriptionTopicGenerator: func(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (topicCandidates []string, err error) {
+			if device.Id == "d_old" || device.Id == "d_new" {
+				return []string{device.Id}, nil
+			}
+			return nil, common.NoSubscriptionExpected
+		},
+	}
+	statistics := &Statistics{}
+	_, err := check.RunDeviceBatch(10, 0, statistics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = check.RunHubBatch(10, 0, statistics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []mocks.LogEvent{
+		{Id: "d_old", Kind: "device", Connected: false, Reason: "no_subscription_found"},
+		{Id: "h_old", Kind: "hub", Connected: false, Reason: "client_gone"},
+	}
+	if !reflect.DeepEqual(eventLogger.Events, expected) {
+		t.Error(eventLogger.Events)
+	}
+	if statistics.SkippedNewerState != 2 || statistics.Checked != 4 {
+		t.Error(statistics.SkippedNewerState, statistics.Checked)
+	}
+}
+
+func TestPartialStateLookup(t *testing.T) {
+	devices := mocks.Devices()
+	devices.DeviceTypes = append(devices.DeviceTypes, model.DeviceType{Id: "dt1", Services: []model.Service{{Id: "s1", ProtocolId: "p1"}}})
+	loggerState := &failingLoggerState{LoggerStateMock: mocks.State(), failedChunk: []string{"d2"}}
+	for _, id := range []string{"d1", "d2"} {
+		devices.Devices = append(devices.Devices, model.Device{Id: id, LocalId: id, DeviceTypeId: "dt1"})
+		loggerState.DeviceStates[id] = true
+	}
+	eventLogger := mocks.Logger()
+	check := &ConnectionCheck{
+		Logger:           eventLogger,
+		LoggerState:      loggerState,
+		Verne:            onlineTopicsMock{},
+		Devices:          devices,
+		TokenGen:         mocks.TokenGen,
+		HandledProtocols: map[string]bool{"p1": true},
+		SubscriptionTopicGenerator: func(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (topicCandidates []string, err error) {
+			return []string{device.Id}, nil
+		},
+	}
+	statistics := &Statistics{}
+	_, err := check.RunDeviceBatch(10, 0, statistics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//d2 is skipped until the next run
+	expected := []mocks.LogEvent{{Id: "d1", Kind: "device", Connected: false, Reason: "no_subscription_found"}}
+	if !reflect.DeepEqual(eventLogger.Events, expected) {
+		t.Error(eventLogger.Events)
+	}
+	if statistics.StateLookupFailed != 1 || statistics.Checked != 2 {
+		t.Error(statistics.StateLookupFailed, statistics.Checked)
+	}
+}
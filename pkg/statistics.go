@@ -18,23 +18,42 @@ package connectioncheck
 
 import (
 	"encoding/json"
+	"sync/atomic"
 	"time"
 )
 
 type Statistics struct {
-	Checked                int `json:"checked"`
-	Connected              int `json:"connected"`
-	UpdateConnected        int `json:"update_connected"`
-	UpdateDisconnected     int `json:"update_disconnected"`
+	Checked                int            `json:"checked"`
+	Connected              int            `json:"connected"`
+	UpdateConnected        int            `json:"update_connected"`
+	UpdateDisconnected     int            `json:"update_disconnected"`
+	SuppressedDisconnects  int            `json:"suppressed_disconnects"`
+	Deduplicated           int            `json:"deduplicated,omitempty"`
+	Unknown                int            `json:"unknown,omitempty"`             //checked devices and hubs without known state
+	SkippedNewerState      int            `json:"skipped_newer_state,omitempty"` //transitions skipped because the known state changed during the check
+	StateLookupFailed      int            `json:"state_lookup_failed,omitempty"` //ids skipped because their state could not be looked up
+	Probed                 int            `json:"probed,omitempty"`
+	ProbeFailed            int            `json:"probe_failed,omitempty"`
+	Mountpoints            map[string]int `json:"mountpoints,omitempty"`
 	timeVerneRequests      time.Duration
 	timeListRequests       time.Duration
 	timeRequestDeviceTypes time.Duration
 	timeRequestLocalDevice time.Duration
 	timeRequestLogState    time.Duration
+
+	deliveries *deliveryCounts //updated by delivery callbacks, which may run after the run (async producer, outbox)
+}
+
+//delivery results of the events of a run; use atomic
+type deliveryCounts struct {
+	delivered int64
+	failed    int64
 }
 
 type PrintStatistics struct {
 	Statistics
+	Delivered              int64  `json:"delivered"`
+	DeliveryFailed         int64  `json:"delivery_failed"`
 	TimeVerneRequests      string `json:"time_verne_requests,omitempty"`
 	TimeListRequests       string `json:"time_list_requests,omitempty"`
 	TimeRequestDeviceTypes string `json:"time_request_device_types,omitempty"`
@@ -66,6 +85,89 @@ func (this *Statistics) AddUpdateDisconnected(count int) {
 	}
 }
 
+func (this *Statistics) AddSuppressedDisconnects(count int) {
+	if this != nil {
+		this.SuppressedDisconnects += count
+	}
+}
+
+func (this *Statistics) AddDeduplicated(count int) {
+	if this != nil {
+		this.Deduplicated += count
+	}
+}
+
+func (this *Statistics) AddUnknown(count int) {
+	if this != nil {
+		this.Unknown += count
+	}
+}
+
+func (this *Statistics) AddSkippedNewerState(count int) {
+	if this != nil {
+		this.SkippedNewerState += count
+	}
+}
+
+func (this *Statistics) AddStateLookupFailed(count int) {
+	if this != nil {
+		this.StateLookupFailed += count
+	}
+}
+
+func (this *Statistics) AddProbed(count int) {
+	if this != nil {
+		this.Probed += count
+	}
+}
+
+func (this *Statistics) AddProbeFailed(count int) {
+	if this != nil {
+		this.ProbeFailed += count
+	}
+}
+
+//returns a callback that counts event deliveries; nil if statistics are disabled
+func (this *Statistics) DeliveryHandler() func(err error) {
+	if this == nil {
+		return nil
+	}
+	if this.deliveries == nil {
+		this.deliveries = &deliveryCounts{}
+	}
+	counts := this.deliveries
+	return func(err error) {
+		if err != nil {
+			atomic.AddInt64(&counts.failed, 1)
+		} else {
+			atomic.AddInt64(&counts.delivered, 1)
+		}
+	}
+}
+
+func (this *Statistics) Delivered() int64 {
+	if this == nil || this.deliveries == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&this.deliveries.delivered)
+}
+
+func (this *Statistics) DeliveryFailed() int64 {
+	if this == nil || this.deliveries == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&this.deliveries.failed)
+}
+
+func (this *Statistics) AddCheckedMountpoint(mountpoint string) {
+	if this != nil && mountpoint != "" {
+		if this.Mountpoints == nil {
+			this.Mountpoints = map[string]int{}
+		}
+		this.Mountpoints[mountpoint] += 1
+	}
+}
+
 func (this *Statistics) AddTimeVerneRequests(dur time.Duration) {
 	if this != nil {
 		this.timeVerneRequests += dur
@@ -120,6 +222,8 @@ func (this *Statistics) String() string {
 		}
 		temp, _ := json.Marshal(PrintStatistics{
 			Statistics:             *this,
+			Delivered:              this.Delivered(),
+			DeliveryFailed:         this.DeliveryFailed(),
 			TimeVerneRequests:      timeVerneRequests,
 			TimeListRequests:       timeListRequests,
 			TimeRequestDeviceTypes: timeRequestDeviceTypes,
@@ -16,7 +16,10 @@
 
 package mocks
 
-import "sync"
+import (
+	"connection-check/pkg/connectionlog/logger"
+	"sync"
+)
 
 func Logger() *LoggerMock {
 	return &LoggerMock{
@@ -29,6 +32,9 @@ type LogEvent struct {
 	Id        string
 	Kind      string
 	Connected bool
+	Reason    string
+	Session   *logger.Session
+	Initial   bool
 }
 
 type LoggerMock struct {
@@ -36,46 +42,74 @@ type LoggerMock struct {
 	Events []LogEvent
 }
 
-func (this *LoggerMock) LogDeviceDisconnect(deviceId string) error {
+func (this *LoggerMock) LogDeviceDisconnect(deviceId string, info logger.EventInfo) error {
 	this.Mux.Lock()
 	defer this.Mux.Unlock()
 	this.Events = append(this.Events, LogEvent{
 		Id:        deviceId,
 		Kind:      "device",
 		Connected: false,
+		Reason:    info.Reason,
+		Initial:   info.Initial,
 	})
+	if info.OnDelivery != nil {
+		info.OnDelivery(nil)
+	}
 	return nil
 }
 
-func (this *LoggerMock) LogDeviceConnect(deviceId string) error {
+func (this *LoggerMock) LogDeviceConnect(deviceId string, info logger.EventInfo) error {
 	this.Mux.Lock()
 	defer this.Mux.Unlock()
 	this.Events = append(this.Events, LogEvent{
 		Id:        deviceId,
 		Kind:      "device",
 		Connected: true,
+		Reason:    info.Reason,
+		Initial:   info.Initial,
+		Session:   info.Session,
 	})
+	if info.OnDelivery != nil {
+		info.OnDelivery(nil)
+	}
 	return nil
 }
 
-func (this *LoggerMock) LogHubConnect(clientId string) error {
+func (this *LoggerMock) LogHubConnect(clientId string, info logger.EventInfo) error {
 	this.Mux.Lock()
 	defer this.Mux.Unlock()
 	this.Events = append(this.Events, LogEvent{
 		Id:        clientId,
 		Kind:      "hub",
 		Connected: true,
+		Reason:    info.Reason,
+		Initial:   info.Initial,
+		Session:   info.Session,
 	})
+	if info.OnDelivery != nil {
+		info.OnDelivery(nil)
+	}
 	return nil
 }
 
-func (this *LoggerMock) LogHubDisconnect(clientId string) error {
+func (this *LoggerMock) LogHubDisconnect(clientId string, info logger.EventInfo) error {
 	this.Mux.Lock()
 	defer this.Mux.Unlock()
 	this.Events = append(this.Events, LogEvent{
 		Id:        clientId,
 		Kind:      "hub",
 		Connected: false,
+		Reason:    info.Reason,
+		Initial:   info.Initial,
 	})
+	if info.OnDelivery != nil {
+		info.OnDelivery(nil)
+	}
 	return nil
 }
+
+func (this *LoggerMock) Flush() error {
+	return nil
+}
+
+func (this *LoggerMock) Close() {}
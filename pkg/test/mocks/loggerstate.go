@@ -16,38 +16,48 @@
 
 package mocks
 
-import "sync"
+import (
+	"connection-check/pkg/connectionlog/state"
+	"sync"
+	"time"
+)
 
 func State() *LoggerStateMock {
 	return &LoggerStateMock{
 		HubStates:    map[string]bool{},
 		DeviceStates: map[string]bool{},
+		ChangeTimes:  map[string]time.Time{},
 		Mux:          &sync.Mutex{},
 	}
 }
 
+//ids without entry in HubStates or DeviceStates are state.Unknown
 type LoggerStateMock struct {
 	Mux          *sync.Mutex
 	HubStates    map[string]bool
 	DeviceStates map[string]bool
+	ChangeTimes  map[string]time.Time //optional time of the last change of a device or hub
 }
 
-func (this *LoggerStateMock) GetHubLogStates(token string, hubIds []string) (result map[string]bool, err error) {
+func (this *LoggerStateMock) GetHubLogStates(token string, hubIds []string) (result map[string]state.LogState, err error) {
 	this.Mux.Lock()
 	defer this.Mux.Unlock()
-	result = map[string]bool{}
-	for _, id := range hubIds {
-		result[id] = this.HubStates[id]
-	}
-	return result, nil
+	return this.getStates(this.HubStates, hubIds), nil
 }
 
-func (this *LoggerStateMock) GetDeviceLogStates(token string, deviceIds []string) (result map[string]bool, err error) {
+func (this *LoggerStateMock) GetDeviceLogStates(token string, deviceIds []string) (result map[string]state.LogState, err error) {
 	this.Mux.Lock()
 	defer this.Mux.Unlock()
-	result = map[string]bool{}
-	for _, id := range deviceIds {
-		result[id] = this.DeviceStates[id]
+	return this.getStates(this.DeviceStates, deviceIds), nil
+}
+
+func (this *LoggerStateMock) getStates(states map[string]bool, ids []string) (result map[string]state.LogState) {
+	result = map[string]state.LogState{}
+	for _, id := range ids {
+		result[id] = state.LogState{State: state.Unknown}
+		if connected, ok := states[id]; ok {
+			result[id] = state.LogState{State: state.StateOf(connected), Time: this.ChangeTimes[id]}
+		}
 	}
-	return result, nil
+	return result
 }
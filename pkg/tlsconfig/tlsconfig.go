@@ -0,0 +1,52 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+//tls config of the http client, the kafka connections and the mqtt status sink
+//caFile (pem) is added to the system root cas; certFile and keyFile (pem) are the client certificate
+func New(caFile string, certFile string, keyFile string, insecureSkipVerify bool) (result *tls.Config, err error) {
+	result = &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.New("unable to read ca file " + caFile + ": " + err.Error())
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + caFile)
+		}
+		result.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.New("unable to load client certificate " + certFile + ": " + err.Error())
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	return result, nil
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tlsconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "invalid.pem")
+	err = ioutil.WriteFile(invalid, []byte("no pem"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := New("", "", "", true)
+	if err != nil || !config.InsecureSkipVerify || config.RootCAs != nil || len(config.Certificates) != 0 {
+		t.Error(config, err)
+	}
+	_, err = New(filepath.Join(dir, "missing.pem"), "", "", false)
+	if err == nil || !strings.HasPrefix(err.Error(), "unable to read ca file") {
+		t.Error(err)
+	}
+	_, err = New(invalid, "", "", false)
+	if err == nil || err.Error() != "no certificate found in "+invalid {
+		t.Error(err)
+	}
+	_, err = New("", invalid, invalid, false)
+	if err == nil || !strings.HasPrefix(err.Error(), "unable to load client certificate") {
+		t.Error(err)
+	}
+}
@@ -21,9 +21,11 @@ import (
 	"strings"
 )
 
+//returns services that use a handled protocol and are selected by the rules set with SetServiceSelection()
 func GetHandledServices(services []model.Service, handledProtocols map[string]bool) (result []model.Service) {
+	selection := GetServiceSelection()
 	for _, service := range services {
-		if handledProtocols[service.ProtocolId] && (UsesControllingFunction(service) || service.Interaction == model.REQUEST || service.Interaction == model.EVENT_AND_REQUEST) {
+		if handledProtocols[service.ProtocolId] && selection.Selects(service) {
 			result = append(result, service)
 		}
 	}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"connection-check/pkg/model"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//rules to decide which services of a device-type are expected to result in a subscription
+//a service is selected if its local id matches no exclude pattern and
+//it matches an include pattern, one of the interactions, function-id prefixes or aspect-ids
+type ServiceSelection struct {
+	Interactions     []model.Interaction
+	FunctionPrefixes []string
+	AspectIds        []string
+	IncludeLocalIds  []*regexp.Regexp
+	ExcludeLocalIds  []*regexp.Regexp
+}
+
+var DefaultServiceSelection = ServiceSelection{
+	Interactions:     []model.Interaction{model.REQUEST, model.EVENT_AND_REQUEST},
+	FunctionPrefixes: []string{model.CONTROLLING_FUNCTION_PREFIX},
+}
+
+var serviceSelection = DefaultServiceSelection
+var serviceSelectionMux sync.RWMutex
+
+//local id patterns are regular expressions that have to match the complete local id
+//if interactions, functionPrefixes and aspectIds are empty, the rules of DefaultServiceSelection are used; the local id patterns apply on top
+func NewServiceSelection(interactions []string, functionPrefixes []string, aspectIds []string, includeLocalIds []string, excludeLocalIds []string) (result ServiceSelection, err error) {
+	if len(interactions) == 0 && len(functionPrefixes) == 0 && len(aspectIds) == 0 {
+		result.Interactions = DefaultServiceSelection.Interactions
+		result.FunctionPrefixes = DefaultServiceSelection.FunctionPrefixes
+		result.AspectIds = DefaultServiceSelection.AspectIds
+	} else {
+		for _, interaction := range interactions {
+			result.Interactions = append(result.Interactions, model.Interaction(strings.TrimSpace(interaction)))
+		}
+		result.FunctionPrefixes = functionPrefixes
+		result.AspectIds = aspectIds
+	}
+	result.IncludeLocalIds, err = compileLocalIdPatterns(includeLocalIds)
+	if err != nil {
+		return result, err
+	}
+	result.ExcludeLocalIds, err = compileLocalIdPatterns(excludeLocalIds)
+	return result, err
+}
+
+func compileLocalIdPatterns(patterns []string) (result []*regexp.Regexp, err error) {
+	for _, pattern := range patterns {
+		exp, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return result, err
+		}
+		result = append(result, exp)
+	}
+	return result, nil
+}
+
+//sets the rules used by GetHandledServices
+func SetServiceSelection(selection ServiceSelection) {
+	serviceSelectionMux.Lock()
+	defer serviceSelectionMux.Unlock()
+	serviceSelection = selection
+}
+
+func GetServiceSelection() ServiceSelection {
+	serviceSelectionMux.RLock()
+	defer serviceSelectionMux.RUnlock()
+	return serviceSelection
+}
+
+func (this ServiceSelection) Selects(service model.Service) bool {
+	for _, exp := range this.ExcludeLocalIds {
+		if exp.MatchString(service.LocalId) {
+			return false
+		}
+	}
+	for _, exp := range this.IncludeLocalIds {
+		if exp.MatchString(service.LocalId) {
+			return true
+		}
+	}
+	for _, interaction := range this.Interactions {
+		if service.Interaction == interaction {
+			return true
+		}
+	}
+	for _, function := range service.FunctionIds {
+		for _, prefix := range this.FunctionPrefixes {
+			if strings.HasPrefix(function, prefix) {
+				return true
+			}
+		}
+	}
+	for _, aspect := range service.AspectIds {
+		for _, expected := range this.AspectIds {
+			if aspect == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
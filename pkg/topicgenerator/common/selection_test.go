@@ -0,0 +1,110 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"connection-check/pkg/model"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultServiceSelection(t *testing.T) {
+	selection, err := NewServiceSelection(nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Run(testSelects(selection, "controlling", model.Service{LocalId: "s", Interaction: model.EVENT, FunctionIds: []string{model.CONTROLLING_FUNCTION_PREFIX + "f"}}, true))
+	t.Run(testSelects(selection, "request", model.Service{LocalId: "s", Interaction: model.REQUEST}, true))
+	t.Run(testSelects(selection, "event+request", model.Service{LocalId: "s", Interaction: model.EVENT_AND_REQUEST}, true))
+	t.Run(testSelects(selection, "measuring event", model.Service{LocalId: "s", Interaction: model.EVENT, FunctionIds: []string{model.MEASURING_FUNCTION_PREFIX + "f"}}, false))
+}
+
+func TestConfiguredServiceSelection(t *testing.T) {
+	selection, err := NewServiceSelection([]string{"request"}, []string{"urn:custom:"}, []string{"aspect1"}, []string{"cmd_.*"}, []string{".*_readonly"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Run(testSelects(selection, "request", model.Service{LocalId: "s", Interaction: model.REQUEST}, true))
+	t.Run(testSelects(selection, "event+request", model.Service{LocalId: "s", Interaction: model.EVENT_AND_REQUEST}, false))
+	t.Run(testSelects(selection, "controlling", model.Service{LocalId: "s", Interaction: model.EVENT, FunctionIds: []string{model.CONTROLLING_FUNCTION_PREFIX + "f"}}, false))
+	t.Run(testSelects(selection, "custom function", model.Service{LocalId: "s", Interaction: model.EVENT, FunctionIds: []string{"urn:custom:f"}}, true))
+	t.Run(testSelects(selection, "aspect", model.Service{LocalId: "s", Interaction: model.EVENT, AspectIds: []string{"aspect2", "aspect1"}}, true))
+	t.Run(testSelects(selection, "include", model.Service{LocalId: "cmd_set", Interaction: model.EVENT}, true))
+	t.Run(testSelects(selection, "include partial", model.Service{LocalId: "x_cmd_set", Interaction: model.EVENT}, false))
+	t.Run(testSelects(selection, "exclude", model.Service{LocalId: "cmd_readonly", Interaction: model.REQUEST}, false))
+}
+
+func TestServiceSelectionLocalIdsOnly(t *testing.T) {
+	selection, err := NewServiceSelection(nil, nil, nil, nil, []string{".*_readonly"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Run(testSelects(selection, "request", model.Service{LocalId: "s", Interaction: model.REQUEST}, true))
+	t.Run(testSelects(selection, "controlling", model.Service{LocalId: "s", Interaction: model.EVENT, FunctionIds: []string{model.CONTROLLING_FUNCTION_PREFIX + "f"}}, true))
+	t.Run(testSelects(selection, "exclude", model.Service{LocalId: "cmd_readonly", Interaction: model.REQUEST}, false))
+	t.Run(testSelects(selection, "measuring event", model.Service{LocalId: "s", Interaction: model.EVENT, FunctionIds: []string{model.MEASURING_FUNCTION_PREFIX + "f"}}, false))
+
+	selection, err = NewServiceSelection(nil, nil, nil, []string{"status"}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Run(testSelects(selection, "include", model.Service{LocalId: "status", Interaction: model.EVENT}, true))
+	t.Run(testSelects(selection, "default with include", model.Service{LocalId: "s", Interaction: model.REQUEST}, true))
+}
+
+func TestInvalidServiceSelection(t *testing.T) {
+	_, err := NewServiceSelection(nil, nil, nil, []string{"("}, nil)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestGetHandledServices(t *testing.T) {
+	defer SetServiceSelection(DefaultServiceSelection)
+	services := []model.Service{
+		{LocalId: "s1", ProtocolId: "p1", Interaction: model.EVENT},
+		{LocalId: "s2", ProtocolId: "p1", Interaction: model.REQUEST},
+		{LocalId: "s3", ProtocolId: "p2", Interaction: model.REQUEST},
+	}
+	result := GetHandledServices(services, map[string]bool{"p1": true})
+	if !reflect.DeepEqual(result, []model.Service{services[1]}) {
+		t.Error(result)
+	}
+
+	selection, err := NewServiceSelection(nil, nil, nil, []string{"s1"}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	SetServiceSelection(selection)
+	result = GetHandledServices(services, map[string]bool{"p1": true})
+	if !reflect.DeepEqual(result, []model.Service{services[0], services[1]}) {
+		t.Error(result)
+	}
+}
+
+func testSelects(selection ServiceSelection, name string, service model.Service, expected bool) (string, func(t *testing.T)) {
+	return name, func(t *testing.T) {
+		if selection.Selects(service) != expected {
+			t.Error(service, expected)
+		}
+	}
+}
@@ -16,6 +16,14 @@
 
 package known
 
-import "connection-check/pkg/topicgenerator/common"
+import (
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/httpclient"
+	"connection-check/pkg/topicgenerator/common"
+)
 
 var Generators = map[string]common.TopicGenerator{}
+
+//optional setup of generators that depend on the configuration; called once before the generator is used
+//client is the http client shared by all components of the service
+var Configurators = map[string]func(config configuration.Config, client *httpclient.Client) error{}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package remote
+
+import (
+	"bytes"
+	"connection-check/pkg/configuration"
+	"connection-check/pkg/devices/cache"
+	"connection-check/pkg/httpclient"
+	"connection-check/pkg/model"
+	"connection-check/pkg/topicgenerator/common"
+	"connection-check/pkg/topicgenerator/known"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"sort"
+)
+
+const CacheSize = 10 * 1024 * 1024 //10MB
+
+var Default = &Generator{}
+
+func init() {
+	known.Generators["remote"] = Default.Generate
+	known.Configurators["remote"] = Default.Configure
+}
+
+//requests topic candidates from an external service
+type Generator struct {
+	Url    string
+	Client *httpclient.Client //if nil, httpclient.Default is used
+	cache  *cache.Cache
+}
+
+type Request struct {
+	Device           model.Device     `json:"device"`
+	DeviceType       model.DeviceType `json:"device_type"`
+	HandledProtocols []string         `json:"handled_protocols"`
+}
+
+type Response struct {
+	Topics                 []string `json:"topics"`
+	NoSubscriptionExpected bool     `json:"no_subscription_expected"`
+}
+
+func New(url string, cacheExpiration int) *Generator {
+	result := &Generator{}
+	result.init(url, cacheExpiration, nil)
+	return result
+}
+
+func (this *Generator) Configure(config configuration.Config, client *httpclient.Client) error {
+	if config.TopicGeneratorRemoteUrl == "" {
+		return errors.New("missing topic_generator_remote_url for remote topic generator")
+	}
+	this.Client = client
+	this.init(config.TopicGeneratorRemoteUrl, config.TopicGeneratorRemoteCacheExpiration, config.MemcacheUrls)
+	return nil
+}
+
+func (this *Generator) init(url string, cacheExpiration int, memcacheUrls []string) {
+	this.Url = url
+	this.cache = cache.New(&cache.CacheConfig{
+		L1Expiration:   cacheExpiration,
+		L1Size:         CacheSize,
+		L2Expiration:   int32(cacheExpiration),
+		L2MemcacheUrls: memcacheUrls,
+	})
+}
+
+func (this *Generator) Generate(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (topicCandidates []string, err error) {
+	if this.cache == nil {
+		return topicCandidates, errors.New("remote topic generator is not configured")
+	}
+	response := Response{}
+	err = this.cache.Use("remote-topics."+deviceType.Id+"."+device.Id, func() (interface{}, error) {
+		return this.request(device, deviceType, handledProtocols)
+	}, &response)
+	if err != nil {
+		return topicCandidates, err
+	}
+	if response.NoSubscriptionExpected {
+		return topicCandidates, common.NoSubscriptionExpected
+	}
+	return response.Topics, nil
+}
+
+func (this *Generator) request(device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool) (result Response, err error) {
+	protocols := []string{}
+	for protocol, handled := range handledProtocols {
+		if handled {
+			protocols = append(protocols, protocol)
+		}
+	}
+	sort.Strings(protocols)
+	b := new(bytes.Buffer)
+	err = json.NewEncoder(b).Encode(Request{
+		Device:           device,
+		DeviceType:       deviceType,
+		HandledProtocols: protocols,
+	})
+	if err != nil {
+		return result, err
+	}
+	req, err := http.NewRequest("POST", this.Url, b)
+	if err != nil {
+		debug.PrintStack()
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := this.Client.Do(httpclient.Idempotent(req))
+	if err != nil {
+		debug.PrintStack()
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return result, errors.New("unable to get topics from remote topic generator: " + resp.Status + ": " + string(buf))
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		debug.PrintStack()
+		return result, err
+	}
+	return result, nil
+}
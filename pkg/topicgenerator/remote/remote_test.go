@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package remote
+
+import (
+	"connection-check/pkg/model"
+	"connection-check/pkg/topicgenerator/common"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRemoteGenerator(t *testing.T) {
+	mux := sync.Mutex{}
+	requests := []Request{}
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		request := Request{}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		requests = append(requests, request)
+		switch request.DeviceType.Id {
+		case "dt1":
+			json.NewEncoder(w).Encode(Response{Topics: []string{"cmd/" + request.Device.LocalId + "/+"}})
+		case "dt2":
+			json.NewEncoder(w).Encode(Response{NoSubscriptionExpected: true})
+		default:
+			http.Error(w, "unknown device-type", 404)
+		}
+	}))
+	defer mock.Close()
+
+	gen := New(mock.URL, 60)
+	handledProtocols := map[string]bool{"p2": true, "p1": true, "p3": false}
+
+	t.Run("first request", testGenerate(gen, model.Device{Id: "d1", LocalId: "l1"}, model.DeviceType{Id: "dt1"}, handledProtocols, []string{"cmd/l1/+"}, nil))
+	t.Run("cached request", testGenerate(gen, model.Device{Id: "d1", LocalId: "l1"}, model.DeviceType{Id: "dt1"}, handledProtocols, []string{"cmd/l1/+"}, nil))
+	t.Run("other device", testGenerate(gen, model.Device{Id: "d2", LocalId: "l2"}, model.DeviceType{Id: "dt1"}, handledProtocols, []string{"cmd/l2/+"}, nil))
+	t.Run("no subscription expected", testGenerate(gen, model.Device{Id: "d3", LocalId: "l3"}, model.DeviceType{Id: "dt2"}, handledProtocols, nil, common.NoSubscriptionExpected))
+	t.Run("cached no subscription expected", testGenerate(gen, model.Device{Id: "d3", LocalId: "l3"}, model.DeviceType{Id: "dt2"}, handledProtocols, nil, common.NoSubscriptionExpected))
+
+	t.Run("error", func(t *testing.T) {
+		_, err := gen.Generate(model.Device{Id: "d4"}, model.DeviceType{Id: "unknown"}, handledProtocols)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("check requests", func(t *testing.T) {
+		mux.Lock()
+		defer mux.Unlock()
+		if len(requests) != 4 {
+			t.Error(len(requests), requests)
+			return
+		}
+		if !reflect.DeepEqual(requests[0].HandledProtocols, []string{"p1", "p2"}) {
+			t.Error(requests[0].HandledProtocols)
+		}
+		if requests[0].Device.Id != "d1" || requests[1].Device.Id != "d2" || requests[2].Device.Id != "d3" {
+			t.Error(requests)
+		}
+	})
+}
+
+func testGenerate(gen *Generator, device model.Device, deviceType model.DeviceType, handledProtocols map[string]bool, expectedTopics []string, expectedErr error) func(t *testing.T) {
+	return func(t *testing.T) {
+		topics, err := gen.Generate(device, deviceType, handledProtocols)
+		if err != expectedErr {
+			t.Error(err, expectedErr)
+			return
+		}
+		if !reflect.DeepEqual(topics, expectedTopics) {
+			t.Error(topics, expectedTopics)
+		}
+	}
+}
@@ -19,7 +19,9 @@ package topicgenerator
 import (
 	"connection-check/pkg/topicgenerator/known"
 	_ "connection-check/pkg/topicgenerator/mqtt"
+	_ "connection-check/pkg/topicgenerator/remote"
 	_ "connection-check/pkg/topicgenerator/senergy"
 )
 
 var Known = known.Generators
+var Configurators = known.Configurators
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vernemq
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+)
+
+func (this *VernemqManagementApi) GetClusterNodes() (result []Node, err error) {
+	temp := NodeWrapper{}
+	err = this.query("/api/v1/cluster/show", &temp)
+	if err != nil {
+		return result, err
+	}
+	return temp.Table, nil
+}
+
+//calls fetch with path extended by --limit and a --node filter for every running cluster node and accept after the final fetch of the node
+//vernemq applies --limit per node and has no offset: if a node result reaches the limit, the node is fetched again with a doubled limit
+//truncated is true if a node result reaches NodeResultMaxLimit or a node is not running
+func (this *VernemqManagementApi) queryNodes(path string, fetch func(nodePath string) (count int, err error), accept func()) (truncated bool, err error) {
+	nodes, err := this.GetClusterNodes()
+	if err != nil {
+		return truncated, err
+	}
+	for _, node := range nodes {
+		if !node.Running {
+			log.Println("WARNING: vernemq node is not running; result is incomplete", node.Node)
+			truncated = true
+			continue
+		}
+		limit := this.NodeResultLimit
+		for {
+			count, err := fetch(path + "&--limit=" + strconv.Itoa(limit) + "&--node=" + url.QueryEscape(node.Node))
+			if err != nil {
+				return truncated, err
+			}
+			if count < limit {
+				break
+			}
+			if limit >= this.NodeResultMaxLimit {
+				log.Println("WARNING: vernemq node result reached the max limit of "+strconv.Itoa(limit)+"; result is truncated", node.Node)
+				truncated = true
+				break
+			}
+			limit = limit * 2
+			if limit > this.NodeResultMaxLimit {
+				limit = this.NodeResultMaxLimit
+			}
+		}
+		accept()
+	}
+	return truncated, nil
+}
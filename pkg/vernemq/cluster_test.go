@@ -0,0 +1,127 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vernemq
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestClusterAwareOnlineClients(t *testing.T) {
+	mux := sync.Mutex{}
+	queriedNodes := []string{}
+	running := map[string]bool{"node1": true, "node2": true, "node3": false}
+	clients := map[string][]Client{
+		"node1": {{Id: "c1", User: "u"}, {Id: "c2", User: "u"}},
+		"node2": {{Id: "c3", User: "u"}},
+		"node3": {{Id: "c4", User: "u"}},
+	}
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		switch r.URL.Path {
+		case "/api/v1/cluster/show":
+			nodes := NodeWrapper{}
+			for _, node := range []string{"node1", "node2", "node3"} {
+				nodes.Table = append(nodes.Table, Node{Node: node, Running: running[node]})
+			}
+			json.NewEncoder(w).Encode(nodes)
+		case "/api/v1/session/show":
+			node := r.URL.Query().Get("--node")
+			limit, err := strconv.Atoi(r.URL.Query().Get("--limit"))
+			if err != nil {
+				http.Error(w, "invalid limit", 400)
+				return
+			}
+			queriedNodes = append(queriedNodes, node+":"+strconv.Itoa(limit))
+			result := clients[node]
+			if len(result) > limit {
+				result = result[:limit]
+			}
+			json.NewEncoder(w).Encode(ClientWrapper{Table: result})
+		default:
+			http.Error(w, "not found", 404)
+		}
+	}))
+	defer mock.Close()
+
+	api := New(mock.URL)
+	api.NodeResultLimit = 10
+
+	t.Run("not running node", testReadClusterClients(api, []string{"c1", "c2", "c3"}, true))
+
+	mux.Lock()
+	running["node3"] = true
+	mux.Unlock()
+	t.Run("all nodes", testReadClusterClients(api, []string{"c1", "c2", "c3", "c4"}, false))
+
+	t.Run("check queried nodes", func(t *testing.T) {
+		mux.Lock()
+		defer mux.Unlock()
+		expected := []string{"node1:10", "node2:10", "node1:10", "node2:10", "node3:10"}
+		if !reflect.DeepEqual(queriedNodes, expected) {
+			t.Error(queriedNodes)
+		}
+		queriedNodes = []string{}
+	})
+
+	//node1 exceeds the limit; it is queried again with a doubled limit until its result is complete
+	mux.Lock()
+	clients["node1"] = []Client{{Id: "c1", User: "u"}, {Id: "c2", User: "u"}, {Id: "c5", User: "u"}, {Id: "c6", User: "u"}, {Id: "c7", User: "u"}}
+	mux.Unlock()
+	api.NodeResultLimit = 2
+	t.Run("node limit exceeded", testReadClusterClients(api, []string{"c1", "c2", "c3", "c4", "c5", "c6", "c7"}, false))
+	t.Run("check doubled limits", func(t *testing.T) {
+		mux.Lock()
+		defer mux.Unlock()
+		expected := []string{"node1:2", "node1:4", "node1:8", "node2:2", "node3:2"}
+		if !reflect.DeepEqual(queriedNodes, expected) {
+			t.Error(queriedNodes)
+		}
+	})
+
+	api.NodeResultMaxLimit = 4
+	t.Run("node max limit reached", testReadClusterClients(api, []string{"c1", "c2", "c3", "c4", "c5", "c6"}, true))
+}
+
+func testReadClusterClients(api *VernemqManagementApi, expectedIds []string, expectedTruncated bool) func(t *testing.T) {
+	return func(t *testing.T) {
+		result, truncated, err := api.GetOnlineClients("")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if truncated != expectedTruncated {
+			t.Error(truncated, expectedTruncated)
+		}
+		ids := []string{}
+		for _, client := range result {
+			ids = append(ids, client.Id)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, expectedIds) {
+			t.Error(ids, expectedIds)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vernemq
+
+import "net/url"
+
+//disconnects the session of the client (vmq-admin session disconnect)
+//if cleanup is true, the session state (e.g. queued messages and persistent subscriptions) is removed as well
+func (this *VernemqManagementApi) DisconnectClient(mountpoint string, clientId string, cleanup bool) error {
+	path := "/api/v1/session/disconnect?client-id=" + url.QueryEscape(clientId)
+	if mountpoint != "" {
+		path = path + "&mountpoint=" + url.QueryEscape(mountpoint)
+	}
+	if cleanup {
+		path = path + "&--cleanup"
+	}
+	return this.query(path, nil)
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vernemq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestDisconnectClient(t *testing.T) {
+	queries := []url.Values{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/session/disconnect" {
+			http.Error(w, "not found", 404)
+			return
+		}
+		queries = append(queries, r.URL.Query())
+		if r.URL.Query().Get("client-id") == "unknown" {
+			http.Error(w, "session not found", 400)
+			return
+		}
+		w.Write([]byte(`{"table":[],"type":"table"}`))
+	}))
+	defer mock.Close()
+
+	api := New(mock.URL)
+	err := api.DisconnectClient("", "client1", false)
+	if err != nil {
+		t.Error(err)
+	}
+	err = api.DisconnectClient("tenant", "client:2", true)
+	if err != nil {
+		t.Error(err)
+	}
+	err = api.DisconnectClient("", "unknown", false)
+	if err == nil {
+		t.Error("expected error for unknown session")
+	}
+
+	expected := []url.Values{
+		{"client-id": {"client1"}},
+		{"client-id": {"client:2"}, "mountpoint": {"tenant"}, "--cleanup": {""}},
+		{"client-id": {"unknown"}},
+	}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Error(queries)
+	}
+}
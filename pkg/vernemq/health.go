@@ -0,0 +1,130 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vernemq
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+type ClusterHealth struct {
+	Nodes            []Node `json:"nodes"`
+	RunningNodes     int    `json:"running_nodes"`
+	ExpectedNodes    int    `json:"expected_nodes,omitempty"`
+	NetsplitDetected int64  `json:"netsplit_detected"`
+	NetsplitResolved int64  `json:"netsplit_resolved"`
+	Partitioned      bool   `json:"partitioned"`
+	Degraded         bool   `json:"degraded"`
+}
+
+//combines cluster/show with the netsplit metrics of /metrics
+//the cluster is partitioned if a node is not running or fewer than expectedNodes are running
+//the netsplit metrics are lifetime counters of the queried node and only informational; a current netsplit shows up as a not running node in cluster/show
+//if the metrics are unavailable, the error is logged and the health is built from cluster/show alone
+//expectedNodes <= 0 disables the node count check
+func (this *VernemqManagementApi) GetClusterHealth(expectedNodes int) (result ClusterHealth, err error) {
+	result.Nodes, err = this.GetClusterNodes()
+	if err != nil {
+		return result, err
+	}
+	metrics, err := this.GetMetrics()
+	if err != nil {
+		log.Println("WARNING: unable to read vernemq netsplit metrics; use cluster/show only", err)
+		err = nil
+	} else {
+		result.NetsplitDetected = int64(metrics["netsplit_detected"])
+		result.NetsplitResolved = int64(metrics["netsplit_resolved"])
+	}
+	for _, node := range result.Nodes {
+		if node.Running {
+			result.RunningNodes++
+		}
+	}
+	if expectedNodes > 0 {
+		result.ExpectedNodes = expectedNodes
+	}
+	result.Partitioned = result.RunningNodes < len(result.Nodes) ||
+		result.RunningNodes < expectedNodes
+	result.Degraded = result.Partitioned || result.RunningNodes == 0
+	return result, nil
+}
+
+//reads the prometheus metrics of the vernemq node; values of metrics with multiple label sets are summed up
+func (this *VernemqManagementApi) GetMetrics() (result map[string]float64, err error) {
+	req, err := http.NewRequest("GET", this.Url+"/metrics", nil)
+	if err != nil {
+		debug.PrintStack()
+		return result, err
+	}
+	resp, err := this.Client.Do(req)
+	if err != nil {
+		debug.PrintStack()
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return result, errors.New(resp.Status + ":" + string(buf))
+	}
+	return parseMetrics(resp.Body)
+}
+
+//parses lines like 'name{label="value"} 42' or 'name 42'
+func parseMetrics(reader io.Reader) (result map[string]float64, err error) {
+	result = map[string]float64{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := ""
+		rest := ""
+		if labelsStart := strings.Index(line, "{"); labelsStart >= 0 {
+			labelsEnd := strings.LastIndex(line, "}")
+			if labelsEnd < labelsStart {
+				continue
+			}
+			name = line[:labelsStart]
+			rest = line[labelsEnd+1:]
+		} else {
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			name = parts[0]
+			rest = parts[1]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		result[name] = result[name] + value
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vernemq
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseMetrics(t *testing.T) {
+	metrics, err := parseMetrics(strings.NewReader(`# HELP netsplit_detected The number of detected netsplits.
+# TYPE netsplit_detected counter
+netsplit_detected{node="VerneMQ@node1"} 2
+netsplit_detected{node="VerneMQ@node2"} 1
+netsplit_resolved 2 1600000000000
+invalid
+socket_open{node="VerneMQ@node1",mqtt_version="4"} 12.5
+`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if metrics["netsplit_detected"] != 3 || metrics["netsplit_resolved"] != 2 || metrics["socket_open"] != 12.5 {
+		t.Error(metrics)
+	}
+	if _, ok := metrics["invalid"]; ok {
+		t.Error(metrics)
+	}
+}
+
+func TestGetClusterHealth(t *testing.T) {
+	mux := sync.Mutex{}
+	nodes := []Node{{Node: "node1", Running: true}, {Node: "node2", Running: true}}
+	metrics := "netsplit_detected 1\nnetsplit_resolved 1\n"
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		switch r.URL.Path {
+		case "/api/v1/cluster/show":
+			json.NewEncoder(w).Encode(NodeWrapper{Table: nodes})
+		case "/metrics":
+			if metrics == "" {
+				http.Error(w, "unavailable", 503)
+				return
+			}
+			w.Write([]byte(metrics))
+		default:
+			http.Error(w, "not found", 404)
+		}
+	}))
+	defer mock.Close()
+	api := New(mock.URL)
+
+	t.Run("healthy", testGetClusterHealth(api, 2, false))
+	t.Run("missing expected node", testGetClusterHealth(api, 3, true))
+
+	mux.Lock()
+	nodes[1].Running = false
+	mux.Unlock()
+	t.Run("node not running", testGetClusterHealth(api, 0, true))
+
+	mux.Lock()
+	nodes[1].Running = true
+	metrics = "netsplit_detected 2\nnetsplit_resolved 1\n"
+	mux.Unlock()
+	t.Run("netsplit counters of resolved netsplit", testGetClusterHealth(api, 0, false))
+
+	mux.Lock()
+	metrics = ""
+	mux.Unlock()
+	t.Run("metrics unavailable", testGetClusterHealth(api, 2, false))
+}
+
+func testGetClusterHealth(api *VernemqManagementApi, expectedNodes int, expectedPartitioned bool) func(t *testing.T) {
+	return func(t *testing.T) {
+		health, err := api.GetClusterHealth(expectedNodes)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if health.Partitioned != expectedPartitioned || health.Degraded != expectedPartitioned {
+			t.Error(health)
+		}
+	}
+}
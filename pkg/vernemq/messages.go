@@ -17,8 +17,12 @@
 package vernemq
 
 type Client struct {
-	Id   string `json:"client_id"`
-	User string `json:"user"`
+	Id              string `json:"client_id"`
+	User            string `json:"user"`
+	PeerHost        string `json:"peer_host,omitempty"`
+	PeerPort        int    `json:"peer_port,omitempty"`
+	ProtocolVersion int    `json:"protocol,omitempty"`
+	Mountpoint      string `json:"mountpoint,omitempty"`
 }
 
 type ClientWrapper struct {
@@ -26,11 +30,25 @@ type ClientWrapper struct {
 }
 
 type Subscription struct {
-	ClientId string `json:"client_id"`
-	User     string `json:"user"`
-	Topic    string `json:"topic"`
+	ClientId        string `json:"client_id"`
+	User            string `json:"user"`
+	Topic           string `json:"topic"`
+	ShareGroup      string `json:"share_group,omitempty"` //set if the subscription is a shared subscription; Topic is then without the $share/<group>/ prefix
+	PeerHost        string `json:"peer_host,omitempty"`
+	PeerPort        int    `json:"peer_port,omitempty"`
+	ProtocolVersion int    `json:"protocol,omitempty"`
+	Mountpoint      string `json:"mountpoint,omitempty"`
 }
 
 type SubscriptionWrapper struct {
 	Table []Subscription `json:"table"`
 }
+
+type Node struct {
+	Node    string `json:"Node"`
+	Running bool   `json:"Running"`
+}
+
+type NodeWrapper struct {
+	Table []Node `json:"table"`
+}
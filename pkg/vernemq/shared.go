@@ -0,0 +1,81 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vernemq
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const SharedSubscriptionPrefix = "$share/"
+
+//splits a mqtt 5 shared subscription topic ($share/<group>/<topic>) in its group and the actual topic
+func ParseSharedTopic(topic string) (group string, sharedTopic string, isShared bool) {
+	if !strings.HasPrefix(topic, SharedSubscriptionPrefix) {
+		return "", topic, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(topic, SharedSubscriptionPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", topic, false
+	}
+	return parts[0], parts[1], true
+}
+
+func SharedTopic(group string, topic string) string {
+	return SharedSubscriptionPrefix + group + "/" + topic
+}
+
+func normalizeSharedSubscriptions(subscriptions []Subscription) []Subscription {
+	for i, subscription := range subscriptions {
+		if group, topic, isShared := ParseSharedTopic(subscription.Topic); isShared {
+			subscriptions[i].ShareGroup = group
+			subscriptions[i].Topic = topic
+		}
+	}
+	return subscriptions
+}
+
+//returns a subscription of the share group if at least SharedSubscriptionMinMembers clients are online; otherwise nil
+func (this *VernemqManagementApi) checkOnlineSharedSubscription(mountpoint string, group string, topic string) (subscription *Subscription, err error) {
+	minMembers := this.SharedSubscriptionMinMembers
+	if minMembers < 1 {
+		minMembers = 1
+	}
+	limit := 1
+	if minMembers > 1 {
+		limit = this.NodeResultLimit
+	}
+	path := "/api/v1/session/show?--is_online=true&--client_id&" + SessionDetailFields + "&--topic=" + url.QueryEscape(SharedTopic(group, topic)) + "&--limit=" + strconv.Itoa(limit) + mountpointFilter(mountpoint)
+	temp := SubscriptionWrapper{}
+	err = this.query(path, &temp)
+	if err != nil {
+		return nil, err
+	}
+	members := map[string]bool{}
+	for _, member := range temp.Table {
+		members[member.ClientId] = true
+	}
+	if len(temp.Table) == 0 || len(members) < minMembers {
+		return nil, nil
+	}
+	subscription = &temp.Table[0]
+	subscription.Topic = topic
+	subscription.ShareGroup = group
+	subscription.Mountpoint = resultMountpoint(subscription.Mountpoint, mountpoint)
+	return subscription, nil
+}
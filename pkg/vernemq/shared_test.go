@@ -0,0 +1,59 @@
+/*
+ * Copyright 2020 InfAI (CC SES)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vernemq
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSharedTopic(t *testing.T) {
+	t.Run(testParseSharedTopic("$share/group/command/device/service", "group", "command/device/service", true))
+	t.Run(testParseSharedTopic("$share/group/#", "group", "#", true))
+	t.Run(testParseSharedTopic("command/device/service", "", "command/device/service", false))
+	t.Run(testParseSharedTopic("$share/group", "", "$share/group", false))
+	t.Run(testParseSharedTopic("$share//topic", "", "$share//topic", false))
+	t.Run(testParseSharedTopic("$share/group/", "", "$share/group/", false))
+}
+
+func TestNormalizeSharedSubscriptions(t *testing.T) {
+	result := normalizeSharedSubscriptions([]Subscription{
+		{ClientId: "c1", Topic: "topic1"},
+		{ClientId: "c2", Topic: "$share/g1/topic1"},
+		{ClientId: "c3", Topic: "$share/g2/foo/bar"},
+	})
+	expected := []Subscription{
+		{ClientId: "c1", Topic: "topic1"},
+		{ClientId: "c2", Topic: "topic1", ShareGroup: "g1"},
+		{ClientId: "c3", Topic: "foo/bar", ShareGroup: "g2"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Error(result, expected)
+	}
+}
+
+func testParseSharedTopic(topic string, expectedGroup string, expectedTopic string, expectedShared bool) (string, func(t *testing.T)) {
+	return topic, func(t *testing.T) {
+		group, sharedTopic, isShared := ParseSharedTopic(topic)
+		if group != expectedGroup || sharedTopic != expectedTopic || isShared != expectedShared {
+			t.Error(group, sharedTopic, isShared)
+		}
+		if isShared && SharedTopic(group, sharedTopic) != topic {
+			t.Error(SharedTopic(group, sharedTopic))
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package vernemq
 
 import (
+	"connection-check/pkg/httpclient"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -24,142 +25,175 @@ import (
 	"net/http"
 	"net/url"
 	"runtime/debug"
-	"strconv"
 )
 
 func New(url string) *VernemqManagementApi {
 	return &VernemqManagementApi{
-		Url:             url,
-		NodeResultLimit: 100,
+		Url:                          url,
+		NodeResultLimit:              100,
+		NodeResultMaxLimit:           DefaultNodeResultMaxLimit,
+		SharedSubscriptionMinMembers: 1,
 	}
 }
 
+const DefaultNodeResultMaxLimit = 1000000
+
 type VernemqManagementApi struct {
-	Url             string
-	NodeResultLimit int
+	Url                string
+	NodeResultLimit    int                //initial --limit per node
+	NodeResultMaxLimit int                //the limit is doubled up to this value while a node result reaches it
+	Client             *httpclient.Client //if nil, httpclient.Default is used
+
+	//share groups of mqtt 5 shared subscriptions ($share/<group>/<topic>) that are accepted as subscription of a device
+	SharedSubscriptionGroups []string
+	//minimal count of online clients in a share group to accept a shared subscription
+	SharedSubscriptionMinMembers int
 }
 
-func (this *VernemqManagementApi) GetOnlineClients() (result []Client, err error) {
-	path := "/api/v1/session/show?--is_online=true&--client_id&--user&--limit=" + strconv.Itoa(this.NodeResultLimit)
-	req, err := http.NewRequest("GET", this.Url+path, nil)
-	if err != nil {
-		debug.PrintStack()
-		return result, err
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		debug.PrintStack()
-		return result, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		buf, _ := ioutil.ReadAll(resp.Body)
-		err = errors.New(resp.Status + ":" + string(buf))
-		log.Println("ERROR: unable to get resource", err)
-		return result, err
-	}
-	temp := ClientWrapper{}
-	err = json.NewDecoder(resp.Body).Decode(&temp)
-	if err != nil {
-		log.Println("ERROR: unable to unmarshal result of", this.Url+path)
-		return result, err
-	}
-	return temp.Table, nil
+//returns online clients of all cluster nodes
+//truncated is true if a node result reached NodeResultMaxLimit or a node is not running
+func (this *VernemqManagementApi) GetOnlineClients(mountpoint string) (result []Client, truncated bool, err error) {
+	path := "/api/v1/session/show?--is_online=true&--client_id&--user" + mountpointFilter(mountpoint)
+	var nodeResult []Client
+	truncated, err = this.queryNodes(path, func(nodePath string) (count int, err error) {
+		temp := ClientWrapper{}
+		err = this.query(nodePath, &temp)
+		nodeResult = temp.Table
+		return len(temp.Table), err
+	}, func() {
+		for _, client := range nodeResult {
+			client.Mountpoint = resultMountpoint(client.Mountpoint, mountpoint)
+			result = append(result, client)
+		}
+	})
+	return result, truncated, err
 }
 
-func (this *VernemqManagementApi) GetOnlineSubscriptions() (result []Subscription, err error) {
-	path := "/api/v1/session/show?--is_online=true&--user&--client_id&--topic&--limit=" + strconv.Itoa(this.NodeResultLimit)
-	req, err := http.NewRequest("GET", this.Url+path, nil)
-	if err != nil {
-		debug.PrintStack()
-		return result, err
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		debug.PrintStack()
-		return result, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		buf, _ := ioutil.ReadAll(resp.Body)
-		err = errors.New(resp.Status + ":" + string(buf))
-		log.Println("ERROR: unable to get result from vernemq", err)
-		return result, err
-	}
-	temp := SubscriptionWrapper{}
-	err = json.NewDecoder(resp.Body).Decode(&temp)
-	if err != nil {
-		log.Println("ERROR: unable to unmarshal result of", this.Url+path)
-		return result, err
-	}
-	return temp.Table, nil
+//returns online subscriptions of all cluster nodes
+//truncated is true if a node result reached NodeResultMaxLimit or a node is not running
+func (this *VernemqManagementApi) GetOnlineSubscriptions(mountpoint string) (result []Subscription, truncated bool, err error) {
+	path := "/api/v1/session/show?--is_online=true&--user&--client_id&--topic" + mountpointFilter(mountpoint)
+	var nodeResult []Subscription
+	truncated, err = this.queryNodes(path, func(nodePath string) (count int, err error) {
+		temp := SubscriptionWrapper{}
+		err = this.query(nodePath, &temp)
+		nodeResult = temp.Table
+		return len(temp.Table), err
+	}, func() {
+		for _, subscription := range normalizeSharedSubscriptions(nodeResult) {
+			subscription.Mountpoint = resultMountpoint(subscription.Mountpoint, mountpoint)
+			result = append(result, subscription)
+		}
+	})
+	return result, truncated, err
 }
 
-func (this *VernemqManagementApi) CheckOnlineSubscriptions(topics []string) (onlineSubscriptionExists bool, err error) {
+//returns the first online subscription of one of the topics; nil if no topic is subscribed
+func (this *VernemqManagementApi) CheckOnlineSubscriptions(mountpoint string, topics []string) (subscription *Subscription, err error) {
 	for _, topic := range topics {
-		onlineSubscriptionExists, err = this.CheckOnlineSubscription(topic)
+		subscription, err = this.CheckOnlineSubscription(mountpoint, topic)
 		if err != nil {
 			return
 		}
-		if onlineSubscriptionExists {
+		if subscription != nil {
 			return
 		}
 	}
 	return
 }
 
-func (this *VernemqManagementApi) CheckOnlineSubscription(topic string) (onlineSubscriptionExists bool, err error) {
-	path := "/api/v1/session/show?--is_online=true&--topic=" + url.QueryEscape(topic) + "&--limit=1"
-	req, err := http.NewRequest("GET", this.Url+path, nil)
-	if err != nil {
-		debug.PrintStack()
-		return false, err
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		debug.PrintStack()
-		return false, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		buf, _ := ioutil.ReadAll(resp.Body)
-		err = errors.New(resp.Status + ":" + string(buf))
-		log.Println("ERROR: unable to get result from vernemq", err)
-		return false, err
+//returns an online subscription of the topic; nil if the topic is not subscribed
+func (this *VernemqManagementApi) CheckOnlineSubscription(mountpoint string, topic string) (subscription *Subscription, err error) {
+	if group, sharedTopic, isShared := ParseSharedTopic(topic); isShared {
+		return this.checkOnlineSharedSubscription(mountpoint, group, sharedTopic)
+	}
+	subscription, err = this.checkOnlineExactSubscription(mountpoint, topic)
+	if err != nil || subscription != nil {
+		return
+	}
+	for _, group := range this.SharedSubscriptionGroups {
+		subscription, err = this.checkOnlineSharedSubscription(mountpoint, group, topic)
+		if err != nil || subscription != nil {
+			return
+		}
 	}
+	return nil, nil
+}
+
+func (this *VernemqManagementApi) checkOnlineExactSubscription(mountpoint string, topic string) (subscription *Subscription, err error) {
+	path := "/api/v1/session/show?--is_online=true&--client_id&" + SessionDetailFields + "&--topic=" + url.QueryEscape(topic) + "&--limit=1" + mountpointFilter(mountpoint)
 	temp := SubscriptionWrapper{}
-	err = json.NewDecoder(resp.Body).Decode(&temp)
-	if err != nil {
-		log.Println("ERROR: unable to unmarshal result of", this.Url+path)
-		return false, err
+	err = this.query(path, &temp)
+	if err != nil || len(temp.Table) == 0 {
+		return nil, err
+	}
+	subscription = &temp.Table[0]
+	subscription.Topic = topic
+	subscription.Mountpoint = resultMountpoint(subscription.Mountpoint, mountpoint)
+	return subscription, nil
+}
+
+//returns the online session of the client; nil if the client is not online
+func (this *VernemqManagementApi) CheckOnlineClient(mountpoint string, clientId string) (client *Client, err error) {
+	path := "/api/v1/session/show?--is_online=true&" + SessionDetailFields + "&--client_id=" + url.QueryEscape(clientId) + "&--limit=1" + mountpointFilter(mountpoint)
+	temp := ClientWrapper{}
+	err = this.query(path, &temp)
+	if err != nil || len(temp.Table) == 0 {
+		return nil, err
+	}
+	client = &temp.Table[0]
+	client.Id = clientId
+	client.Mountpoint = resultMountpoint(client.Mountpoint, mountpoint)
+	return client, nil
+}
+
+//session columns requested by the online checks
+const SessionDetailFields = "--user&--peer_host&--peer_port&--protocol"
+
+//matches sessions of all mountpoints; the empty mountpoint is the default mountpoint of vernemq
+const AllMountpoints = "*"
+
+//AllMountpoints results in no filter; the mountpoint is then requested as column
+func mountpointFilter(mountpoint string) string {
+	if mountpoint == AllMountpoints {
+		return "&--mountpoint"
+	}
+	return "&--mountpoint=" + url.QueryEscape(mountpoint)
+}
+
+//vernemq returns no column for filtered fields
+func resultMountpoint(column string, filter string) string {
+	if filter != AllMountpoints {
+		return filter
 	}
-	return len(temp.Table) > 0, nil
+	return column
 }
 
-func (this *VernemqManagementApi) CheckOnlineClient(clientId string) (onlineClientExists bool, err error) {
-	path := "/api/v1/session/show?--is_online=true&--client_id=" + url.QueryEscape(clientId) + "&--limit=1"
+func (this *VernemqManagementApi) query(path string, result interface{}) (err error) {
 	req, err := http.NewRequest("GET", this.Url+path, nil)
 	if err != nil {
 		debug.PrintStack()
-		return false, err
+		return err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := this.Client.Do(req)
 	if err != nil {
 		debug.PrintStack()
-		return false, err
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
 		buf, _ := ioutil.ReadAll(resp.Body)
 		err = errors.New(resp.Status + ":" + string(buf))
 		log.Println("ERROR: unable to get result from vernemq", err)
-		return false, err
+		return err
 	}
-	temp := SubscriptionWrapper{}
-	err = json.NewDecoder(resp.Body).Decode(&temp)
+	if result == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(result)
 	if err != nil {
 		log.Println("ERROR: unable to unmarshal result of", this.Url+path)
-		return false, err
+		return err
 	}
-	return len(temp.Table) > 0, nil
+	return nil
 }
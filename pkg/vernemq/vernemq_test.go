@@ -23,6 +23,7 @@ import (
 	"github.com/ory/dockertest/v3"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -168,6 +169,10 @@ func TestCheckOnlineSubscription(t *testing.T) {
 	t.Run(testCheckOnlineSubscription(managementUrl, "unknown", false))
 	t.Run(testCheckOnlineSubscription(managementUrl, "foo/unknown", false))
 	t.Run(testCheckOnlineSubscription(managementUrl, "unknown/foo", false))
+
+	t.Run("client id topic1", testCheckOnlineSubscriptionClient(managementUrl, "topic1", "client1"))
+	t.Run("client id foo bar", testCheckOnlineSubscriptionClient(managementUrl, "foo/bar", "client3"))
+	t.Run("client id placeholder", testCheckOnlineSubscriptionClient(managementUrl, "with/placeholder/#", "placeholder"))
 }
 
 func TestCheckOnlineClient(t *testing.T) {
@@ -207,18 +212,72 @@ func TestCheckOnlineClient(t *testing.T) {
 	t.Run(testCheckOnlineClient(managementUrl, "uuid:senergy:foo-bar-batz-unknown", false))
 }
 
+func TestCheckOnlineSharedSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer cancel()
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	brokerUrl, managementUrl, err := docker.VernemqWithManagementApi(pool, ctx, wg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	t.Run("create test client 1", testStartTestClient(ctx, wg, brokerUrl, "client1", []string{"$share/connector/command/d1/+", "$share/single/command/d2/+"}))
+	t.Run("create test client 2", testStartTestClient(ctx, wg, brokerUrl, "client2", []string{"$share/connector/command/d1/+", "$share/unknown/command/d3/+"}))
+	time.Sleep(1 * time.Second)
+
+	t.Run(testCheckOnlineSharedSubscription(managementUrl, []string{"connector", "single"}, 1, "command/d1/+", true))
+	t.Run(testCheckOnlineSharedSubscription(managementUrl, []string{"connector", "single"}, 1, "command/d2/+", true))
+	t.Run(testCheckOnlineSharedSubscription(managementUrl, []string{"connector", "single"}, 1, "command/d3/+", false))
+	t.Run(testCheckOnlineSharedSubscription(managementUrl, []string{"connector", "single"}, 2, "command/d1/+", true))
+	t.Run(testCheckOnlineSharedSubscription(managementUrl, []string{"connector", "single"}, 2, "command/d2/+", false))
+	t.Run(testCheckOnlineSharedSubscription(managementUrl, nil, 1, "command/d1/+", false))
+	t.Run(testCheckOnlineSharedSubscription(managementUrl, nil, 1, "$share/unknown/command/d3/+", true))
+
+	t.Run("read normalized online subscriptions", testReadOnlineSubscriptions(managementUrl, []Subscription{
+		{ClientId: "client1", User: "test", Topic: "command/d1/+", ShareGroup: "connector"},
+		{ClientId: "client1", User: "test", Topic: "command/d2/+", ShareGroup: "single"},
+		{ClientId: "client2", User: "test", Topic: "command/d1/+", ShareGroup: "connector"},
+		{ClientId: "client2", User: "test", Topic: "command/d3/+", ShareGroup: "unknown"},
+	}))
+}
+
+func testCheckOnlineSharedSubscription(url string, groups []string, minMembers int, topic string, expected bool) (string, func(t *testing.T)) {
+	return strings.Replace(topic, "/", " ", -1) + " " + strconv.Itoa(minMembers), func(t *testing.T) {
+		api := New(url)
+		api.SharedSubscriptionGroups = groups
+		api.SharedSubscriptionMinMembers = minMembers
+		result, err := api.CheckOnlineSubscription("", topic)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if (result != nil) != expected {
+			t.Error(result, expected)
+		}
+	}
+}
+
 func testCheckOnlineClient(url string, clientId string, expected bool) (string, func(t *testing.T)) {
 	return strings.Replace(clientId, "/", " ", -1), func(t *testing.T) {
 		api := &VernemqManagementApi{
 			Url:             url,
 			NodeResultLimit: 10000,
 		}
-		result, err := api.CheckOnlineClient(clientId)
+		result, err := api.CheckOnlineClient("", clientId)
 		if err != nil {
 			t.Error(err)
 			return
 		}
-		if result != expected {
+		if (result != nil) != expected {
 			t.Error(result, expected)
 		}
 	}
@@ -230,28 +289,48 @@ func testCheckOnlineSubscription(url string, topic string, expected bool) (strin
 			Url:             url,
 			NodeResultLimit: 10000,
 		}
-		result, err := api.CheckOnlineSubscription(topic)
+		result, err := api.CheckOnlineSubscription("", topic)
 		if err != nil {
 			t.Error(err)
 			return
 		}
-		if result != expected {
+		if (result != nil) != expected {
 			t.Error(result, expected)
 		}
 	}
 }
 
+func testCheckOnlineSubscriptionClient(url string, topic string, expectedClientId string) func(t *testing.T) {
+	return func(t *testing.T) {
+		api := &VernemqManagementApi{
+			Url:             url,
+			NodeResultLimit: 10000,
+		}
+		result, err := api.CheckOnlineSubscription("", topic)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if result == nil || result.ClientId != expectedClientId {
+			t.Error(result, expectedClientId)
+		}
+	}
+}
+
 func testReadOnlineClients(url string, expected []Client) func(t *testing.T) {
 	return func(t *testing.T) {
 		api := &VernemqManagementApi{
 			Url:             url,
 			NodeResultLimit: 10000,
 		}
-		result, err := api.GetOnlineClients()
+		result, truncated, err := api.GetOnlineClients("")
 		if err != nil {
 			t.Error(err)
 			return
 		}
+		if truncated {
+			t.Error("unexpected truncated result")
+		}
 		sort.SliceStable(expected, func(i, j int) bool {
 			return expected[i].Id < expected[j].Id
 		})
@@ -272,11 +351,14 @@ func testReadOnlineSubscriptions(url string, expected []Subscription) func(t *te
 			Url:             url,
 			NodeResultLimit: 10000,
 		}
-		result, err := api.GetOnlineSubscriptions()
+		result, truncated, err := api.GetOnlineSubscriptions("")
 		if err != nil {
 			t.Error(err)
 			return
 		}
+		if truncated {
+			t.Error("unexpected truncated result")
+		}
 		sort.SliceStable(expected, func(i, j int) bool {
 			return expected[i].Topic < expected[j].Topic
 		})